@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/doomsday-project/doomsday/server/auth"
@@ -72,7 +74,9 @@ func (c *Client) doRequest(
 	}
 
 	if (resp.StatusCode / 100) != 2 {
-		return parseError(resp.StatusCode)
+		//Only the start of the body is needed to explain the error
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return parseError(resp.StatusCode, body)
 	}
 
 	if output != nil {
@@ -100,15 +104,111 @@ func (c *Client) UserpassAuth(username, password string) error {
 	return err
 }
 
+const (
+	CacheItemTypeCertificate = "certificate"
+	//CacheItemTypeCRL is a certificate revocation list. Its subject is that of
+	// its issuer, and it expires at its NextUpdate time.
+	CacheItemTypeCRL = "crl"
+	//CacheItemTypeSSHCertificate is an OpenSSH certificate. Its common name is
+	// its key ID, and its issuer is the fingerprint of the CA key that signed it.
+	CacheItemTypeSSHCertificate = "ssh_certificate"
+	//CacheItemTypePGPKey is an OpenPGP primary key or subkey. Its common name
+	// is the key's first user ID.
+	CacheItemTypePGPKey = "pgp_key"
+)
+
 type CacheItem struct {
+	//Type is one of the CacheItemType constants
+	Type       string          `json:"type"`
 	Paths      []CacheItemPath `json:"paths"`
 	CommonName string          `json:"common_name"`
 	NotAfter   int64           `json:"not_after"`
+
+	Subject          string `json:"subject"`
+	Issuer           string `json:"issuer"`
+	IssuerCommonName string `json:"issuer_common_name"`
+	//SerialNumber is hex encoded
+	SerialNumber   string   `json:"serial_number"`
+	DNSNames       []string `json:"dns_names,omitempty"`
+	IPAddresses    []string `json:"ip_addresses,omitempty"`
+	URIs           []string `json:"uris,omitempty"`
+	EmailAddresses []string `json:"email_addresses,omitempty"`
+	NotBefore      int64    `json:"not_before"`
+	KeyAlgorithm   string   `json:"key_algorithm"`
+	//KeySize is in bits
+	KeySize            int    `json:"key_size"`
+	SignatureAlgorithm string `json:"signature_algorithm"`
+	//Fingerprint is the hex encoded SHA-256 hash of the DER certificate
+	Fingerprint  string   `json:"fingerprint"`
+	IsCA         bool     `json:"is_ca"`
+	KeyUsages    []string `json:"key_usages,omitempty"`
+	ExtKeyUsages []string `json:"ext_key_usages,omitempty"`
+	//EffectiveNotAfter is the earliest expiry of this cert and the issuers in
+	// its chain which are known to the server
+	EffectiveNotAfter int64 `json:"effective_not_after"`
+	//Issuers are the fingerprints of the certs known to the server which could
+	// have issued this cert
+	Issuers []string `json:"issuers,omitempty"`
+	//Replaces are the rotated events in the server's history where this cert
+	// took the place of an older one with the same subject, newest first
+	Replaces []HistoryEvent `json:"replaces,omitempty"`
+	//KeyMismatch is true if the private key at any of the paths does not match
+	// the cert
+	KeyMismatch bool `json:"key_mismatch"`
+	//Findings are the rules of the server's policy which the cert violates
+	Findings []PolicyFinding `json:"findings,omitempty"`
+	//Principals are the users or hosts that an SSH certificate is valid for
+	Principals []string `json:"principals,omitempty"`
+	//KeyID is the hex encoded fingerprint of an OpenPGP key
+	KeyID string `json:"key_id,omitempty"`
+	//Unverified is true if the expiry or name of an OpenPGP key comes from a
+	// self-signature which the server couldn't check, because it uses a hash,
+	// key algorithm or curve which the server doesn't support. Self-signatures
+	// which are checked and don't verify are ignored.
+	Unverified bool `json:"unverified,omitempty"`
+}
+
+type PolicyFinding struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+//Name returns the common name of the cert, or if it has none, the first of
+// its subject alternative names. If it has neither, the full subject is
+// returned. CRLs are named for their issuer. Items which aren't X.509 certs
+// have their type prepended.
+func (c CacheItem) Name() string {
+	switch c.Type {
+	case CacheItemTypeCRL:
+		return "CRL: " + c.name()
+	case CacheItemTypeSSHCertificate:
+		return "SSH: " + c.name()
+	case CacheItemTypePGPKey:
+		return "PGP: " + c.name()
+	}
+
+	return c.name()
+}
+
+func (c CacheItem) name() string {
+	for _, names := range [][]string{{c.CommonName}, c.DNSNames, c.IPAddresses, c.URIs, c.EmailAddresses, c.Principals} {
+		if len(names) > 0 && names[0] != "" {
+			return names[0]
+		}
+	}
+
+	return c.Subject
 }
 
 type CacheItemPath struct {
 	Backend  string `json:"backend"`
 	Location string `json:"location"`
+	//KeyMismatch is true if a private key was stored alongside the cert at this
+	// location which does not match the cert
+	KeyMismatch bool `json:"key_mismatch,omitempty"`
+	//Stale is true if this path was loaded from the server's cache file when
+	// it started, and its backend hasn't been refreshed since
+	Stale bool `json:"stale,omitempty"`
 }
 
 type CacheItems []CacheItem
@@ -147,20 +247,244 @@ func (c CacheItems) Filter(filter CacheItemFilter) CacheItems {
 	return ret
 }
 
+//CacheWarning describes a path which the server could not check for certs
+type CacheWarning struct {
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	//Stale is true if the last refresh of the backend failed, so this warning
+	// is from an earlier refresh and may no longer apply
+	Stale bool `json:"stale,omitempty"`
+}
+
 type GetCacheResponse struct {
-	Content CacheItems `json:"content"`
+	Content  CacheItems     `json:"content"`
+	Warnings []CacheWarning `json:"warnings"`
+	//Total is how many certs matched the query, across all pages
+	Total int `json:"total"`
+	//NextCursor is given if there are more certs after this page. Pass it as
+	// the Cursor of the same query to get them.
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 //GetCache gets the cache list
 func (c *Client) GetCache() (CacheItems, error) {
-	resp := GetCacheResponse{}
-	err := c.doRequest("GET", "/v1/cache", nil, &resp)
+	resp, err := c.GetCacheWithWarnings()
 	return resp.Content, err
 }
 
-//RefreshCache makes a request to asynchronously refresh the server cache
-func (c *Client) RefreshCache() error {
-	return c.doRequest("POST", "/v1/cache/refresh", nil, nil)
+//GetCacheWithWarnings gets the cache list along with any warnings about paths
+// which could not be checked
+func (c *Client) GetCacheWithWarnings() (*GetCacheResponse, error) {
+	return c.GetCacheWithQuery(GetCacheQuery{})
+}
+
+//GetCacheQuery restricts which certs are returned from the cache. The zero
+// value returns everything.
+type GetCacheQuery struct {
+	//Findings restricts the results to certs with a finding for any of these
+	// policy rules. The rule "any" matches any finding.
+	Findings []string
+	//Beyond and Within restrict the results to certs which expire after, or no
+	// later than, this long from now. They are in the form 1y2d3h4m.
+	Beyond string
+	Within string
+	//Backend restricts the results to certs found in this backend
+	Backend string
+	//Name restricts the results to certs with a common name or subject
+	// alternative name containing this, ignoring case
+	Name string
+	//NameRegex restricts the results to certs with a common name or subject
+	// alternative name matching this regular expression
+	NameRegex string
+	//Path restricts the results to certs found at a location starting with
+	// this. If Backend is also given, the location must be in that backend.
+	Path string
+	//Issuer restricts the results to certs whose issuer contains this,
+	// ignoring case
+	Issuer string
+	//Sort is the field to order the results by, one of "not_after",
+	// "effective_not_after", "not_before" or "name". It may be prefixed with
+	// "-" for descending order. The default is "not_after".
+	Sort string
+	//Limit, if non-zero, is the most certs to return
+	Limit int
+	//Cursor is the NextCursor from the previous page of the same query
+	Cursor string
+}
+
+func (q GetCacheQuery) values() url.Values {
+	ret := url.Values{}
+	for _, finding := range q.Findings {
+		ret.Add("finding", finding)
+	}
+
+	for key, value := range map[string]string{
+		"beyond":     q.Beyond,
+		"within":     q.Within,
+		"backend":    q.Backend,
+		"name":       q.Name,
+		"name_regex": q.NameRegex,
+		"path":       q.Path,
+		"issuer":     q.Issuer,
+		"sort":       q.Sort,
+		"cursor":     q.Cursor,
+	} {
+		if value != "" {
+			ret.Set(key, value)
+		}
+	}
+
+	if q.Limit > 0 {
+		ret.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	return ret
+}
+
+//GetCacheWithQuery gets the certs in the cache which match the query, along
+// with any warnings about paths which could not be checked
+func (c *Client) GetCacheWithQuery(query GetCacheQuery) (*GetCacheResponse, error) {
+	resp := GetCacheResponse{}
+	path := "/v1/cache"
+	if values := query.values(); len(values) > 0 {
+		path += "?" + values.Encode()
+	}
+
+	err := c.doRequest("GET", path, nil, &resp)
+	return &resp, err
+}
+
+//CacheItemDetail is everything in a CacheItem, plus further details parsed
+// from the certificate
+type CacheItemDetail struct {
+	CacheItem
+	Version        int    `json:"version"`
+	SubjectKeyID   string `json:"subject_key_id,omitempty"`
+	AuthorityKeyID string `json:"authority_key_id,omitempty"`
+	MaxPathLen     *int   `json:"max_path_len,omitempty"`
+	//NumRevoked is only given for CRLs
+	NumRevoked            *int     `json:"num_revoked,omitempty"`
+	CRLDistributionPoints []string `json:"crl_distribution_points,omitempty"`
+	OCSPServers           []string `json:"ocsp_servers,omitempty"`
+	IssuingCertificateURL []string `json:"issuing_certificate_url,omitempty"`
+	//PEM is only given if it was requested
+	PEM string `json:"pem,omitempty"`
+}
+
+//GetCacheItem gets the details of the cert with the given SHA-256 fingerprint.
+// If withPEM is true, the PEM encoded cert is also returned.
+func (c *Client) GetCacheItem(fingerprint string, withPEM bool) (*CacheItemDetail, error) {
+	resp := CacheItemDetail{}
+	path := fmt.Sprintf("/v1/cache/%s", url.PathEscape(fingerprint))
+	if withPEM {
+		path += "?pem=true"
+	}
+
+	err := c.doRequest("GET", path, nil, &resp)
+	return &resp, err
+}
+
+type GetCacheItemChainResponse struct {
+	//Chain is the cert followed by each of its issuers known to the server, up
+	// to the root. Where there are multiple candidate issuers, the one which
+	// expires last is given.
+	Chain CacheItems `json:"chain"`
+	//Dependents are all of the certs known to the server which were issued
+	// directly or indirectly by the cert
+	Dependents CacheItems `json:"dependents"`
+}
+
+//GetCacheItemChain returns the issuing chain of the cert with the given
+// fingerprint, and all of the certs that depend on it
+func (c *Client) GetCacheItemChain(fingerprint string) (*GetCacheItemChainResponse, error) {
+	resp := GetCacheItemChainResponse{}
+	err := c.doRequest("GET", fmt.Sprintf("/v1/cache/%s/chain", url.PathEscape(fingerprint)), nil, &resp)
+	return &resp, err
+}
+
+const (
+	TaskStatePending = "pending"
+	TaskStateRunning = "running"
+	TaskStateDone    = "done"
+	TaskStateFailed  = "failed"
+	//TaskStateSkipped means the task was not run because the same kind of task
+	// for the same backend was about to run, and the server no longer knows the
+	// outcome of that task
+	TaskStateSkipped = "skipped"
+)
+
+//Task is a refresh or authentication of a backend run by the server's
+// scheduler
+type Task struct {
+	ID      uint   `json:"id"`
+	Backend string `json:"backend"`
+	//Kind is either "refresh" or "auth"
+	Kind   string `json:"kind"`
+	Reason string `json:"reason"`
+	State  string `json:"state"`
+	//StartedAt and FinishedAt are in seconds since the epoch, or 0 if the task
+	// hasn't got that far
+	StartedAt  int64 `json:"started_at,omitempty"`
+	FinishedAt int64 `json:"finished_at,omitempty"`
+	//Error is why the task failed, if it did
+	Error string `json:"error,omitempty"`
+	//Stats are the results of a refresh, once it has finished
+	Stats *TaskStats `json:"stats,omitempty"`
+	//FoldedInto is set if this task was not run because an identical task was
+	// about to run. It is the ID of that task, whose state and outcome are
+	// reported in place of this one's.
+	FoldedInto *uint `json:"folded_into,omitempty"`
+}
+
+//Finished returns true if the task is not waiting to run or running
+func (t Task) Finished() bool {
+	return t.State != TaskStatePending && t.State != TaskStateRunning
+}
+
+type TaskStats struct {
+	NumPaths    int                `json:"num_paths"`
+	NumFiltered int                `json:"num_filtered"`
+	NumSuccess  int                `json:"num_success"`
+	NumCerts    int                `json:"num_certs"`
+	NumOther    int                `json:"num_other"`
+	Warnings    []BackendPathError `json:"warnings"`
+	Errors      []BackendPathError `json:"errors"`
+}
+
+type RefreshResponse struct {
+	Tasks []Task `json:"tasks"`
+}
+
+//RefreshCache makes a request to asynchronously refresh the server cache. The
+// tasks queued to refresh each backend are returned.
+func (c *Client) RefreshCache() ([]Task, error) {
+	resp := RefreshResponse{}
+	err := c.doRequest("POST", "/v1/cache/refresh", nil, &resp)
+	return resp.Tasks, err
+}
+
+//RefreshBackend makes a request to asynchronously refresh only the named
+// backend, and returns the task queued to do it
+func (c *Client) RefreshBackend(name string) (*Task, error) {
+	resp := Task{}
+	err := c.doRequest("POST", fmt.Sprintf("/v1/backends/%s/refresh", url.PathEscape(name)), nil, &resp)
+	return &resp, err
+}
+
+//AuthBackend makes a request to asynchronously re-authenticate to the named
+// backend, and returns the task queued to do it
+func (c *Client) AuthBackend(name string) (*Task, error) {
+	resp := Task{}
+	err := c.doRequest("POST", fmt.Sprintf("/v1/backends/%s/auth", url.PathEscape(name)), nil, &resp)
+	return &resp, err
+}
+
+//GetTask gets the current state of the task with the given ID
+func (c *Client) GetTask(id uint) (*Task, error) {
+	resp := Task{}
+	err := c.doRequest("GET", fmt.Sprintf("/v1/tasks/%d", id), nil, &resp)
+	return &resp, err
 }
 
 type InfoResponse struct {
@@ -201,3 +525,161 @@ func (c *Client) GetSchedulerState() (*GetSchedulerResponse, error) {
 	err := c.doRequest("GET", "/v1/scheduler", nil, &resp)
 	return &resp, err
 }
+
+type GetBackendsResponse struct {
+	Backends []Backend `json:"backends"`
+}
+
+//Backend is the status of one of the server's storage backends
+type Backend struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	//RefreshInterval is how often the backend is refreshed, in seconds
+	RefreshInterval int64          `json:"refresh_interval"`
+	Refresh         BackendRunInfo `json:"refresh"`
+	Auth            BackendRunInfo `json:"auth"`
+	//AuthExpiresAt is when the backend's current auth runs out, or 0 if it
+	// never does
+	AuthExpiresAt int64 `json:"auth_expires_at,omitempty"`
+	//Stale is true if the backend's certs were loaded from the server's cache
+	// file and have not been refreshed since
+	Stale bool `json:"stale,omitempty"`
+	//The path and cert counts are from the last successful refresh
+	NumPaths    int `json:"num_paths"`
+	NumFiltered int `json:"num_filtered"`
+	NumSuccess  int `json:"num_success"`
+	NumCerts    int `json:"num_certs"`
+	NumOther    int `json:"num_other"`
+	//NumCached is how many items from the backend are currently in the cache
+	NumCached int `json:"num_cached"`
+	//Include and Exclude are the filters applied to the paths listed from the
+	// backend, if any are configured
+	Include *BackendPathFilter `json:"include,omitempty"`
+	Exclude *BackendPathFilter `json:"exclude,omitempty"`
+}
+
+//BackendPathFilter selects the paths under any of the directories in Under, or
+// which match any of the patterns in Matching
+type BackendPathFilter struct {
+	Under    []string `json:"under,omitempty"`
+	Matching []string `json:"matching,omitempty"`
+}
+
+//BackendRunInfo describes the runs of one kind of task against a backend.
+// Times are in seconds since the epoch, or 0 if they haven't happened.
+type BackendRunInfo struct {
+	LastStarted         int64 `json:"last_started"`
+	LastFinished        int64 `json:"last_finished"`
+	LastSuccessStarted  int64 `json:"last_success_started"`
+	LastSuccessFinished int64 `json:"last_success_finished"`
+	//LastError is the error from the last run, if it failed
+	LastError string `json:"last_error,omitempty"`
+	//Running is true if the task is being run right now
+	Running bool `json:"running,omitempty"`
+	//Next is when the task is next scheduled to run, or 0 if it isn't
+	Next int64 `json:"next,omitempty"`
+}
+
+//Failed returns true if the last run finished with an error
+func (b BackendRunInfo) Failed() bool {
+	return b.LastError != ""
+}
+
+//GetBackends gets the status of each of the server's backends
+func (c *Client) GetBackends() ([]Backend, error) {
+	resp := GetBackendsResponse{}
+	err := c.doRequest("GET", "/v1/backends", nil, &resp)
+	return resp.Backends, err
+}
+
+type GetBackendErrorsResponse struct {
+	Backend string `json:"backend"`
+	//LastError is the error from the last refresh of the backend, if it failed
+	LastError string             `json:"last_error,omitempty"`
+	Errors    []BackendPathError `json:"errors"`
+}
+
+type BackendPathError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+//GetBackendErrors gets the errors from the last refresh of the named backend
+func (c *Client) GetBackendErrors(name string) (*GetBackendErrorsResponse, error) {
+	resp := GetBackendErrorsResponse{}
+	err := c.doRequest("GET", fmt.Sprintf("/v1/backends/%s/errors", url.PathEscape(name)), nil, &resp)
+	return &resp, err
+}
+
+const (
+	HistoryEventAdded = "added"
+	//HistoryEventRemoved means a cert is no longer at a path, but is still at
+	// other paths in the same backend
+	HistoryEventRemoved = "removed"
+	//HistoryEventVanished means a cert is no longer at a path, nothing replaced
+	// it there, and it isn't anywhere else in the backend
+	HistoryEventVanished = "vanished"
+	HistoryEventReplaced = "replaced"
+	//HistoryEventRotated means a cert was replaced by one with the same subject
+	// which expires later
+	HistoryEventRotated = "rotated"
+)
+
+//HistoryEvent is a change in what the server found at a path during a refresh
+type HistoryEvent struct {
+	At       int64  `json:"at"`
+	Kind     string `json:"kind"`
+	Backend  string `json:"backend"`
+	Location string `json:"location"`
+	//Old is the cert that was at the path before the change, if any
+	Old *HistoryCert `json:"old,omitempty"`
+	//New is the cert that is at the path after the change, if any
+	New *HistoryCert `json:"new,omitempty"`
+}
+
+type HistoryCert struct {
+	Name        string `json:"name"`
+	CommonName  string `json:"common_name"`
+	Subject     string `json:"subject"`
+	Fingerprint string `json:"fingerprint"`
+	NotAfter    int64  `json:"not_after"`
+}
+
+type GetHistoryResponse struct {
+	Events []HistoryEvent `json:"events"`
+}
+
+//GetHistoryQuery restricts which events are returned from the history. The
+// zero value returns everything.
+type GetHistoryQuery struct {
+	//Search matches events at paths starting with it, or which involve a cert
+	// with it as its name
+	Search  string
+	Backend string
+}
+
+func (q GetHistoryQuery) values() url.Values {
+	ret := url.Values{}
+	if q.Search != "" {
+		ret.Set("search", q.Search)
+	}
+
+	if q.Backend != "" {
+		ret.Set("backend", q.Backend)
+	}
+
+	return ret
+}
+
+//GetHistory gets the events recorded by the server which match the query,
+// newest first
+func (c *Client) GetHistory(query GetHistoryQuery) ([]HistoryEvent, error) {
+	resp := GetHistoryResponse{}
+	path := "/v1/history"
+	if values := query.values(); len(values) > 0 {
+		path += "?" + values.Encode()
+	}
+
+	err := c.doRequest("GET", path, nil, &resp)
+	return resp.Events, err
+}
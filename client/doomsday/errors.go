@@ -1,6 +1,9 @@
 package doomsday
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type ErrUnauthorized struct {
 	message string
@@ -18,6 +21,14 @@ func (e *ErrBadRequest) Error() string {
 	return e.message
 }
 
+type ErrNotFound struct {
+	message string
+}
+
+func (e *ErrNotFound) Error() string {
+	return e.message
+}
+
 type ErrInternalServer struct {
 	message string
 }
@@ -26,12 +37,20 @@ func (e *ErrInternalServer) Error() string {
 	return e.message
 }
 
-func parseError(code int) (err error) {
+//parseError returns the error for a response with the given status code. For
+// a 400, the body of the response is included, as that is where the server
+// explains what was wrong with the request.
+func parseError(code int, body []byte) (err error) {
 	switch code {
 	case 400:
 		err = &ErrBadRequest{message: "400 - Bad Request"}
+		if reason := strings.TrimSpace(string(body)); reason != "" {
+			err = &ErrBadRequest{message: "400 - Bad Request: " + reason}
+		}
 	case 401:
 		err = &ErrUnauthorized{message: "401 - Unauthorized"}
+	case 404:
+		err = &ErrNotFound{message: "404 - Not Found"}
 	case 500:
 		err = &ErrInternalServer{message: "500 - Internal Server Error"}
 	default:
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doomsday-project/doomsday/client/doomsday"
+	"github.com/olekukonko/tablewriter"
+	"github.com/starkandwayne/goutils/ansi"
+)
+
+type backendsCmd struct{}
+
+func (*backendsCmd) Run() error {
+	backends, err := client.GetBackends()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorder(false)
+	table.SetRowLine(true)
+	table.SetAutoWrapText(false)
+	table.SetReflowDuringAutoWrap(false)
+	table.SetHeader([]string{"Name", "Type", "Status", "Last Refresh", "Next Refresh", "Auth", "Paths", "Certs"})
+
+	now := time.Now()
+	failures := []string{}
+	for _, backend := range backends {
+		table.Append([]string{
+			backend.Name,
+			backend.Type,
+			genBackendStatusStr(backend),
+			genLastRunStr(backend.Refresh, now),
+			genNextRunStr(backend.Refresh, now),
+			genAuthStr(backend, now),
+			fmt.Sprintf("%d/%d\n(%d filtered)", backend.NumSuccess, backend.NumPaths, backend.NumFiltered),
+			strconv.Itoa(backend.NumCached),
+		})
+
+		if backend.Auth.Failed() {
+			failures = append(failures, ansi.Sprintf("@R{Auth of `%s' failed:} %s", backend.Name, backend.Auth.LastError))
+		}
+
+		if backend.Refresh.Failed() {
+			failures = append(failures, ansi.Sprintf("@R{Refresh of `%s' failed:} %s", backend.Name, backend.Refresh.LastError))
+		}
+	}
+	table.Render()
+
+	filters := []string{}
+	for _, backend := range backends {
+		filters = append(filters, genPathFilterLines(backend)...)
+	}
+
+	if len(filters) > 0 {
+		fmt.Println("")
+		fmt.Println("Path filters:")
+		for _, filter := range filters {
+			fmt.Println(filter)
+		}
+	}
+
+	if len(failures) > 0 {
+		fmt.Println("")
+		for _, failure := range failures {
+			fmt.Println(failure)
+		}
+	}
+
+	return nil
+}
+
+func genBackendStatusStr(backend doomsday.Backend) string {
+	switch {
+	case backend.Auth.Failed():
+		return ansi.Sprintf("@R{AUTH FAILED}")
+	case backend.Refresh.Failed():
+		return ansi.Sprintf("@R{FAILED}")
+	case backend.Stale:
+		return ansi.Sprintf("@Y{STALE}")
+	case backend.Refresh.LastSuccessFinished == 0:
+		return ansi.Sprintf("@C{PENDING}")
+	}
+
+	return ansi.Sprintf("@G{OK}")
+}
+
+//genPathFilterLines describes the include and exclude filters of the backend
+func genPathFilterLines(backend doomsday.Backend) []string {
+	ret := []string{}
+	for _, f := range []struct {
+		verb   string
+		filter *doomsday.BackendPathFilter
+	}{
+		{"include", backend.Include},
+		{"exclude", backend.Exclude},
+	} {
+		if f.filter == nil {
+			continue
+		}
+
+		if len(f.filter.Under) > 0 {
+			ret = append(ret, fmt.Sprintf("  %s: %s paths under %s", backend.Name, f.verb, strings.Join(f.filter.Under, ", ")))
+		}
+
+		if len(f.filter.Matching) > 0 {
+			ret = append(ret, fmt.Sprintf("  %s: %s paths matching %s", backend.Name, f.verb, strings.Join(f.filter.Matching, ", ")))
+		}
+	}
+
+	return ret
+}
+
+func genLastRunStr(info doomsday.BackendRunInfo, now time.Time) string {
+	if info.Running {
+		return "running"
+	}
+
+	if info.LastFinished == 0 {
+		return "never"
+	}
+
+	started, finished := time.Unix(info.LastStarted, 0), time.Unix(info.LastFinished, 0)
+	return fmt.Sprintf("%s ago\n(took %s)", formatSince(now, finished), finished.Sub(started))
+}
+
+func genNextRunStr(info doomsday.BackendRunInfo, now time.Time) string {
+	if info.Next == 0 {
+		return "-"
+	}
+
+	next := time.Unix(info.Next, 0)
+	if !next.After(now) {
+		return "now"
+	}
+
+	return fmt.Sprintf("in %s", next.Sub(now).Truncate(time.Second))
+}
+
+func genAuthStr(backend doomsday.Backend, now time.Time) string {
+	if backend.AuthExpiresAt == 0 {
+		return "does not expire"
+	}
+
+	expiry := time.Unix(backend.AuthExpiresAt, 0)
+	if !expiry.After(now) {
+		return ansi.Sprintf("@R{expired %s ago}", formatSince(now, expiry))
+	}
+
+	return fmt.Sprintf("expires in %s\nrenews %s", expiry.Sub(now).Truncate(time.Second), genNextRunStr(backend.Auth, now))
+}
+
+func formatSince(now, t time.Time) string {
+	return now.Sub(t).Truncate(time.Second).String()
+}
@@ -13,11 +13,13 @@ type dashboardCmd struct {
 }
 
 func (d *dashboardCmd) Run() error {
-	results, err := client.GetCache()
+	resp, err := client.GetCacheWithWarnings()
 	if err != nil {
 		return err
 	}
 
+	results := resp.Content
+
 	expiredBound := time.Duration(0)
 	expired := results.Filter(doomsday.CacheItemFilter{
 		Within: &expiredBound,
@@ -43,7 +45,7 @@ func (d *dashboardCmd) Run() error {
 		t.SetHeader([]string{"Common Name", "Path"})
 
 		for _, v := range expired {
-			t.Append([]string{v.CommonName, genPathStr(v)})
+			t.Append([]string{v.Name(), genPathStr(v)})
 		}
 		t.Render()
 	}
@@ -116,5 +118,7 @@ func (d *dashboardCmd) Run() error {
 		fmt.Println("Could not find any certs which expire soon")
 	}
 
+	printWarnings(resp.Warnings)
+
 	return nil
 }
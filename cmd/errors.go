@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/doomsday-project/doomsday/client/doomsday"
+	"github.com/olekukonko/tablewriter"
+)
+
+type errorsCmd struct {
+	Backend *string
+}
+
+func (e *errorsCmd) Run() error {
+	resp, err := client.GetBackendErrors(*e.Backend)
+	if err != nil {
+		if _, is404 := err.(*doomsday.ErrNotFound); is404 {
+			err = fmt.Errorf("No backend with the name `%s' exists", *e.Backend)
+		}
+		return err
+	}
+
+	fmt.Println("")
+	if resp.LastError == "" {
+		fmt.Printf("The last refresh of `%s' succeeded\n", resp.Backend)
+	} else {
+		fmt.Printf("The last refresh of `%s' failed: %s\n", resp.Backend, resp.LastError)
+	}
+
+	if len(resp.Errors) == 0 {
+		return nil
+	}
+
+	fmt.Println("")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorder(false)
+	table.SetRowLine(true)
+	table.SetAutoWrapText(false)
+	table.SetReflowDuringAutoWrap(false)
+	table.SetHeader([]string{"Path", "Error"})
+	for _, pathErr := range resp.Errors {
+		table.Append([]string{pathErr.Path, pathErr.Message})
+	}
+	table.Render()
+
+	return nil
+}
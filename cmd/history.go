@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/doomsday-project/doomsday/client/doomsday"
+	"github.com/olekukonko/tablewriter"
+	"github.com/starkandwayne/goutils/ansi"
+)
+
+type historyCmd struct {
+	Search  *string
+	Backend *string
+}
+
+func (h *historyCmd) Run() error {
+	events, err := client.GetHistory(doomsday.GetHistoryQuery{
+		Search:  *h.Search,
+		Backend: *h.Backend,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("")
+	if len(events) == 0 {
+		fmt.Println("No matching events have been recorded")
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorder(false)
+	table.SetRowLine(true)
+	table.SetAutoWrapText(false)
+	table.SetReflowDuringAutoWrap(false)
+	table.SetHeader([]string{"Time", "Event", "Path", "Old", "New"})
+	for _, event := range events {
+		table.Append([]string{
+			time.Unix(event.At, 0).Local().Format("2006-01-02 15:04:05"),
+			genEventKindStr(event.Kind),
+			fmt.Sprintf("%s->%s", event.Backend, event.Location),
+			genHistoryCertStr(event.Old),
+			genHistoryCertStr(event.New),
+		})
+	}
+	table.Render()
+
+	return nil
+}
+
+func genEventKindStr(kind string) string {
+	switch kind {
+	case doomsday.HistoryEventAdded, doomsday.HistoryEventRotated:
+		return ansi.Sprintf("@G{%s}", kind)
+	case doomsday.HistoryEventRemoved:
+		return ansi.Sprintf("@Y{%s}", kind)
+	case doomsday.HistoryEventVanished:
+		return ansi.Sprintf("@R{%s}", kind)
+	}
+
+	return ansi.Sprintf("@C{%s}", kind)
+}
+
+func genHistoryCertStr(cert *doomsday.HistoryCert) string {
+	if cert == nil {
+		return ""
+	}
+
+	expiry := time.Unix(cert.NotAfter, 0).Local().Format("2006-01-02")
+	return fmt.Sprintf("%s (%s)\nexpires %s", cert.Name, cert.Fingerprint[:16], expiry)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doomsday-project/doomsday/client/doomsday"
+	"github.com/doomsday-project/doomsday/duration"
+	"github.com/olekukonko/tablewriter"
+	"github.com/starkandwayne/goutils/ansi"
+)
+
+type inspectCmd struct {
+	Cert *string
+	PEM  *bool
+}
+
+func (i *inspectCmd) Run() error {
+	items, err := client.GetCache()
+	if err != nil {
+		return err
+	}
+
+	matches := matchCerts(items, *i.Cert)
+	if len(matches) == 0 {
+		return fmt.Errorf("No cert with the fingerprint or common name `%s' was found", *i.Cert)
+	}
+
+	for _, match := range matches {
+		detail, err := client.GetCacheItem(match.Fingerprint, *i.PEM)
+		if err != nil {
+			return err
+		}
+
+		chain, err := client.GetCacheItemChain(match.Fingerprint)
+		if err != nil {
+			return err
+		}
+
+		printCertDetail(detail, chain)
+	}
+
+	return nil
+}
+
+//matchCerts returns the certs whose SHA-256 fingerprint starts with the given
+// string (ignoring colons and case), or whose name is the given string.
+func matchCerts(items doomsday.CacheItems, search string) doomsday.CacheItems {
+	const minFingerprintPrefix = 8
+	normalized := strings.ToLower(strings.Replace(search, ":", "", -1))
+
+	ret := doomsday.CacheItems{}
+	for _, item := range items {
+		if item.Fingerprint == normalized ||
+			(len(normalized) >= minFingerprintPrefix && strings.HasPrefix(item.Fingerprint, normalized)) ||
+			strings.EqualFold(item.CommonName, search) ||
+			item.Name() == search {
+			ret = append(ret, item)
+		}
+	}
+
+	return ret
+}
+
+func printCertDetail(cert *doomsday.CacheItemDetail, chain *doomsday.GetCacheItemChainResponse) {
+	fmt.Println("")
+	table := tablewriter.NewWriter(os.Stdout)
+
+	table.SetAutoFormatHeaders(false)
+	table.SetAutoWrapText(false)
+	table.SetReflowDuringAutoWrap(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetRowLine(true)
+
+	table.SetHeader([]string{"NAME", cert.Name()})
+
+	appendRow := func(key, value string) {
+		if value != "" {
+			table.Append([]string{key, value})
+		}
+	}
+
+	timeFmt := func(t int64) string {
+		return time.Unix(t, 0).UTC().Format(time.RFC1123)
+	}
+
+	expiryFmt := func(t int64) string {
+		if expiresIn := time.Until(time.Unix(t, 0)); expiresIn > 0 {
+			return fmt.Sprintf("in %s", duration.Format(expiresIn))
+		}
+
+		return ansi.Sprintf("@R{EXPIRED}")
+	}
+
+	//The first element of the chain is the cert itself
+	issuedBy := []string{}
+	for _, issuer := range chain.Chain[1:] {
+		issuedBy = append(issuedBy, fmt.Sprintf("%s (%s)", issuer.Name(), issuer.Fingerprint[:16]))
+	}
+
+	dependents := []string{}
+	for _, dependent := range chain.Dependents {
+		dependents = append(dependents, fmt.Sprintf("%s (%s)", dependent.Name(), dependent.Fingerprint[:16]))
+	}
+
+	replaces := []string{}
+	for _, event := range cert.Replaces {
+		replaces = append(replaces, fmt.Sprintf("%s (%s) at %s->%s",
+			event.Old.Name, event.Old.Fingerprint[:16], event.Backend, event.Location))
+	}
+
+	sans := append(append(append(append([]string{},
+		cert.DNSNames...), cert.IPAddresses...), cert.URIs...), cert.EmailAddresses...)
+
+	key := cert.KeyAlgorithm
+	if cert.KeySize != 0 {
+		key = fmt.Sprintf("%s %d", cert.KeyAlgorithm, cert.KeySize)
+	}
+
+	appendRow("SUBJECT", cert.Subject)
+	appendRow("ISSUER", cert.Issuer)
+	appendRow("SERIAL", cert.SerialNumber)
+	notBeforeLabel, notAfterLabel := "NOT BEFORE", "NOT AFTER"
+	if cert.Type == doomsday.CacheItemTypeCRL {
+		notBeforeLabel, notAfterLabel = "THIS UPDATE", "NEXT UPDATE"
+	}
+
+	appendRow(notBeforeLabel, timeFmt(cert.NotBefore))
+	appendRow(notAfterLabel, fmt.Sprintf("%s (%s)", timeFmt(cert.NotAfter), expiryFmt(cert.NotAfter)))
+	if cert.EffectiveNotAfter != 0 && cert.EffectiveNotAfter < cert.NotAfter {
+		appendRow("CHAIN EXPIRY", fmt.Sprintf("%s (%s)", timeFmt(cert.EffectiveNotAfter), expiryFmt(cert.EffectiveNotAfter)))
+	}
+	appendRow("SANS", strings.Join(sans, "\n"))
+	appendRow("KEY", key)
+	appendRow("SIGNATURE", cert.SignatureAlgorithm)
+	appendRow("PRINCIPALS", strings.Join(cert.Principals, "\n"))
+	if cert.Type == "" || cert.Type == doomsday.CacheItemTypeCertificate {
+		appendRow("CA", strconv.FormatBool(cert.IsCA))
+	}
+	if cert.NumRevoked != nil {
+		appendRow("REVOKED", strconv.Itoa(*cert.NumRevoked))
+	}
+	if cert.MaxPathLen != nil {
+		appendRow("MAX PATH LEN", strconv.Itoa(*cert.MaxPathLen))
+	}
+	appendRow("KEY USAGES", strings.Join(cert.KeyUsages, "\n"))
+	appendRow("EXT KEY USAGES", strings.Join(cert.ExtKeyUsages, "\n"))
+	appendRow("SUBJECT KEY ID", cert.SubjectKeyID)
+	appendRow("AUTHORITY KEY ID", cert.AuthorityKeyID)
+	appendRow("CRL", strings.Join(cert.CRLDistributionPoints, "\n"))
+	appendRow("OCSP", strings.Join(cert.OCSPServers, "\n"))
+	findings := []string{}
+	for _, finding := range cert.Findings {
+		findings = append(findings, ansi.Sprintf("@Y{%s}: %s", finding.Rule, finding.Message))
+	}
+
+	appendRow("POLICY", strings.Join(findings, "\n"))
+	appendRow("SHA-256", cert.Fingerprint)
+	appendRow("PGP FINGERPRINT", cert.KeyID)
+	if cert.Unverified {
+		appendRow("SELF-SIGNATURE", ansi.Sprintf("@Y{not verified}"))
+	}
+	appendRow("ISSUED BY", strings.Join(issuedBy, "\n"))
+	appendRow("DEPENDENTS", strings.Join(dependents, "\n"))
+	appendRow("REPLACES", strings.Join(replaces, "\n"))
+	appendRow("PATHS", genPathStr(cert.CacheItem))
+
+	table.SetHeaderColor(tablewriter.Color(tablewriter.FgMagentaColor, tablewriter.Bold), tablewriter.Color(tablewriter.BgBlackColor))
+	table.SetColumnColor(tablewriter.Color(tablewriter.FgMagentaColor, tablewriter.Bold), tablewriter.Color(tablewriter.BgBlackColor))
+	table.Render()
+
+	if cert.PEM != "" {
+		fmt.Println("")
+		fmt.Print(cert.PEM)
+	}
+
+	fmt.Println("")
+}
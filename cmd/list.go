@@ -13,42 +13,64 @@ import (
 )
 
 type listCmd struct {
-	Beyond *string
-	Within *string
+	Beyond    *string
+	Within    *string
+	Findings  *[]string
+	Backend   *string
+	Name      *string
+	NameRegex *string
+	Path      *string
+	Issuer    *string
+	Sort      *string
+	Limit     *int
+	Cursor    *string
 }
 
 func (s *listCmd) Run() error {
-	results, err := client.GetCache()
-	if err != nil {
-		return err
+	query := doomsday.GetCacheQuery{
+		Backend:   *s.Backend,
+		Name:      *s.Name,
+		NameRegex: *s.NameRegex,
+		Path:      *s.Path,
+		Issuer:    *s.Issuer,
+		Sort:      *s.Sort,
+		Limit:     *s.Limit,
+		Cursor:    *s.Cursor,
+	}
+	if s.Findings != nil {
+		query.Findings = *s.Findings
 	}
 
-	filter := doomsday.CacheItemFilter{}
-
-	//Parse the durations
+	//Check the durations here to give a better error than the server would
 	if s.Beyond != nil && *s.Beyond != "" {
-		dur, err := duration.Parse(*s.Beyond)
-		if err != nil {
+		if _, err := duration.Parse(*s.Beyond); err != nil {
 			return fmt.Errorf("When parsing beyond duration: %s", err)
 		}
 
-		filter.Beyond = &dur
+		query.Beyond = *s.Beyond
 	}
 
 	if s.Within != nil && *s.Within != "" {
-		dur, err := duration.Parse(*s.Within)
-		if err != nil {
+		if _, err := duration.Parse(*s.Within); err != nil {
 			return fmt.Errorf("When parsing within duration: %s", err)
 		}
 
-		filter.Within = &dur
+		query.Within = *s.Within
 	}
 
-	results = results.Filter(filter)
+	resp, err := client.GetCacheWithQuery(query)
+	if err != nil {
+		return err
+	}
 
 	//Printing
 	fmt.Println("")
-	printList(results)
+	printList(resp.Content)
+	if resp.NextCursor != "" {
+		fmt.Printf("\nShowing %d of %d certs. To see the next page, run again with the same flags and --cursor %s\n",
+			len(resp.Content), resp.Total, resp.NextCursor)
+	}
+	printWarnings(resp.Warnings)
 
 	return nil
 }
@@ -59,7 +81,18 @@ func printList(items doomsday.CacheItems) {
 	table.SetRowLine(true)
 	table.SetAutoWrapText(false)
 	table.SetReflowDuringAutoWrap(false)
-	table.SetHeader([]string{"Common Name", "Expiry", "Path"})
+	//Only show the findings column if there's anything to put in it
+	showFindings := false
+	for _, result := range items {
+		showFindings = showFindings || len(result.Findings) > 0
+	}
+
+	header := []string{"Common Name", "Expiry", "Path"}
+	if showFindings {
+		header = append(header, "Findings")
+	}
+
+	table.SetHeader(header)
 	for _, result := range items {
 		expiresIn := time.Until(time.Unix(result.NotAfter, 0))
 
@@ -67,15 +100,38 @@ func printList(items doomsday.CacheItems) {
 		if expiresIn > 0 {
 			expStr = duration.Format(expiresIn)
 		}
-		table.Append([]string{
-			result.CommonName,
+
+		//Call out certs which will be taken down early by an issuer in their chain
+		if result.EffectiveNotAfter != 0 && result.EffectiveNotAfter < result.NotAfter {
+			chainStr := ansi.Sprintf("@R{EXPIRED}")
+			if chainExpiresIn := time.Until(time.Unix(result.EffectiveNotAfter, 0)); chainExpiresIn > 0 {
+				chainStr = duration.Format(chainExpiresIn)
+			}
+			expStr = fmt.Sprintf("%s\n(chain: %s)", expStr, chainStr)
+		}
+
+		row := []string{
+			result.Name(),
 			expStr,
 			genPathStr(result),
-		})
+		}
+		if showFindings {
+			row = append(row, genFindingsStr(result))
+		}
+		table.Append(row)
 	}
 	table.Render()
 }
 
+func genFindingsStr(item doomsday.CacheItem) string {
+	rules := []string{}
+	for _, finding := range item.Findings {
+		rules = append(rules, ansi.Sprintf("@Y{%s}", finding.Rule))
+	}
+
+	return strings.Join(rules, "\n")
+}
+
 func genPathStr(item doomsday.CacheItem) string {
 	fmtPaths := []string{}
 	for i := 0; i < len(item.Paths); i++ {
@@ -87,9 +143,48 @@ func genPathStr(item doomsday.CacheItem) string {
 			}
 			backendStr = string(b)
 		}
-		fmtPaths = append(fmtPaths, fmt.Sprintf("%s%s", backendStr, item.Paths[i].Location))
+		pathStr := fmt.Sprintf("%s%s", backendStr, item.Paths[i].Location)
+		if item.Paths[i].KeyMismatch {
+			pathStr += ansi.Sprintf(" @R{(KEY MISMATCH)}")
+		}
+		if item.Paths[i].Stale {
+			pathStr += ansi.Sprintf(" @Y{(STALE)}")
+		}
+		fmtPaths = append(fmtPaths, pathStr)
 	}
 
 	ret := strings.Join(fmtPaths, "\n")
 	return ret
 }
+
+func printWarnings(warnings []doomsday.CacheWarning) {
+	if len(warnings) == 0 {
+		return
+	}
+
+	fmt.Println("")
+	header := tablewriter.NewWriter(os.Stdout)
+	header.SetHeader([]string{"WARNINGS"})
+	header.SetHeaderColor(tablewriter.Colors{
+		tablewriter.Bold,
+		tablewriter.BgBlackColor,
+		tablewriter.FgHiYellowColor,
+	})
+	header.SetHeaderLine(false)
+	header.Render()
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorder(false)
+	table.SetRowLine(true)
+	table.SetAutoWrapText(false)
+	table.SetReflowDuringAutoWrap(false)
+	table.SetHeader([]string{"Backend", "Path", "Warning"})
+	for _, warning := range warnings {
+		message := warning.Message
+		if warning.Stale {
+			message += ansi.Sprintf(" @Y{(STALE)}")
+		}
+		table.Append([]string{warning.Backend, warning.Path, message})
+	}
+	table.Render()
+}
@@ -50,6 +50,20 @@ func registerCommands(app *kingpin.Application) {
 			Short('b').PlaceHolder("1y2d3h4m").String(),
 		Within: listCom.Flag("within", "Restrict to certs that expire in less than the given duration").
 			Short('w').PlaceHolder("1y2d3h4m").String(),
+		Findings: listCom.Flag("finding", "Restrict to certs which violate the given policy rule, or `any' rule. Can be given multiple times").
+			Short('f').PlaceHolder("RULE").Strings(),
+		Backend: listCom.Flag("backend", "Restrict to certs found in the given backend").String(),
+		Name: listCom.Flag("name", "Restrict to certs with a common name or SAN containing the given string").
+			Short('n').String(),
+		NameRegex: listCom.Flag("name-regex", "Restrict to certs with a common name or SAN matching the given regular expression").
+			PlaceHolder("REGEX").String(),
+		Path: listCom.Flag("path", "Restrict to certs found at paths starting with the given prefix").
+			Short('p').String(),
+		Issuer: listCom.Flag("issuer", "Restrict to certs whose issuer contains the given string").String(),
+		Sort: listCom.Flag("sort", "Order by not_after, effective_not_after, not_before, or name. "+
+			"Prefix with `-' to reverse the order").Short('s').PlaceHolder("FIELD").String(),
+		Limit:  listCom.Flag("limit", "Show at most this many certs").Short('l').Int(),
+		Cursor: listCom.Flag("cursor", "Show the page of certs after the one which gave this cursor").String(),
 	}
 
 	_ = app.Command("dashboard", "See your impending doom").Alias("dash")
@@ -60,8 +74,33 @@ func registerCommands(app *kingpin.Application) {
 	cmdIndex["scheduler"] = &schedulerCmd{}
 	cmdIndex["sched"] = cmdIndex["scheduler"]
 
-	_ = app.Command("refresh", "Refresh the servers cache")
-	cmdIndex["refresh"] = &refreshCmd{}
+	refreshCom := app.Command("refresh", "Refresh the servers cache")
+	cmdIndex["refresh"] = &refreshCmd{
+		Backend: refreshCom.Flag("backend", "Only refresh the backend with this name").Short('b').String(),
+		Wait:    refreshCom.Flag("wait", "Wait for the refresh to finish, and fail if it did not succeed").Short('w').Bool(),
+	}
+
+	inspectCom := app.Command("inspect", "Show the details of a cert in the server cache")
+	cmdIndex["inspect"] = &inspectCmd{
+		Cert: inspectCom.Arg("cert", "The SHA-256 fingerprint (or a prefix of at least 8 characters) "+
+			"or common name of the cert").Required().String(),
+		PEM: inspectCom.Flag("pem", "Also print the PEM encoded cert").Bool(),
+	}
+
+	_ = app.Command("backends", "Show the status of each backend the server is configured with")
+	cmdIndex["backends"] = &backendsCmd{}
+
+	errorsCom := app.Command("errors", "List the paths that failed during the last refresh of a backend")
+	cmdIndex["errors"] = &errorsCmd{
+		Backend: errorsCom.Arg("backend", "The name of the backend").Required().String(),
+	}
+
+	historyCom := app.Command("history", "Show when certs appeared at, were replaced at, or were removed from paths")
+	cmdIndex["history"] = &historyCmd{
+		Search: historyCom.Arg("path|name", "Only show events at paths starting with this, "+
+			"or for certs with this common name").String(),
+		Backend: historyCom.Flag("backend", "Only show events from this backend").Short('b').String(),
+	}
 
 	_ = app.Command("info", "Get info about the currently targeted doomsday server")
 	cmdIndex["info"] = &infoCmd{}
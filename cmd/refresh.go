@@ -1,7 +1,94 @@
 package main
 
-type refreshCmd struct{}
+import (
+	"fmt"
+	"time"
+
+	"github.com/doomsday-project/doomsday/client/doomsday"
+	"github.com/starkandwayne/goutils/ansi"
+)
+
+type refreshCmd struct {
+	Backend *string
+	Wait    *bool
+}
 
 func (r *refreshCmd) Run() error {
-	return client.RefreshCache()
+	var tasks []doomsday.Task
+	if *r.Backend == "" {
+		var err error
+		tasks, err = client.RefreshCache()
+		if err != nil {
+			return err
+		}
+	} else {
+		task, err := client.RefreshBackend(*r.Backend)
+		if err != nil {
+			if _, is404 := err.(*doomsday.ErrNotFound); is404 {
+				err = fmt.Errorf("No backend with the name `%s' exists", *r.Backend)
+			}
+			return err
+		}
+
+		tasks = []doomsday.Task{*task}
+	}
+
+	if !*r.Wait {
+		return nil
+	}
+
+	failed := 0
+	for _, task := range tasks {
+		finished, err := waitForTask(task)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(genTaskResultStr(finished))
+		if finished.State != doomsday.TaskStateDone {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d refreshes did not succeed", failed, len(tasks))
+	}
+
+	return nil
+}
+
+//waitForTask polls the server until the given task has finished
+func waitForTask(task doomsday.Task) (*doomsday.Task, error) {
+	for !task.Finished() {
+		time.Sleep(time.Second)
+		next, err := client.GetTask(task.ID)
+		if err != nil {
+			if _, is404 := err.(*doomsday.ErrNotFound); is404 {
+				err = fmt.Errorf("The server no longer knows about the refresh of `%s'", task.Backend)
+			}
+			return nil, err
+		}
+
+		task = *next
+	}
+
+	return &task, nil
+}
+
+func genTaskResultStr(task *doomsday.Task) string {
+	switch task.State {
+	case doomsday.TaskStateFailed:
+		return ansi.Sprintf("@R{Refresh of `%s' failed:} %s", task.Backend, task.Error)
+	case doomsday.TaskStateSkipped:
+		return ansi.Sprintf("@Y{Refresh of `%s' was skipped} in favour of another whose outcome is no longer known", task.Backend)
+	}
+
+	took := time.Unix(task.FinishedAt, 0).Sub(time.Unix(task.StartedAt, 0))
+	ret := ansi.Sprintf("@G{Refreshed `%s'} in %s", task.Backend, took)
+	if task.Stats != nil {
+		ret += fmt.Sprintf(": %d/%d paths searched (%d filtered out). %d certs and %d other items found",
+			task.Stats.NumSuccess, task.Stats.NumPaths, task.Stats.NumFiltered, task.Stats.NumCerts, task.Stats.NumOther)
+	}
+
+	return ret
 }
@@ -1,7 +1,11 @@
 package server
 
 import (
+	"crypto/x509"
 	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
 	"sort"
 	"sync"
 	"time"
@@ -11,6 +15,8 @@ import (
 type Cache struct {
 	store map[string]CacheObject
 	lock  *sync.RWMutex
+	//generation is incremented whenever the store is changed
+	generation uint64
 }
 
 func NewCache() *Cache {
@@ -42,13 +48,16 @@ func (c *Cache) Store(key string, value CacheObject) {
 	c.lock.Lock()
 	sort.Slice(value.Paths, func(i, j int) bool { return value.Paths[i].LessThan(value.Paths[j]) })
 	c.store[key] = value
+	c.generation++
 	c.lock.Unlock()
 }
 
 //ApplyDiff calculates a diff between o and n, and then atomically inserts
 //things new to "n" and deletes things from "o" that are no longer in "n".
-func (c *Cache) ApplyDiff(o, n *Cache) {
+//The changes are returned as history events.
+func (c *Cache) ApplyDiff(o, n *Cache) []HistoryEvent {
 	keysToDelete, keysToAdd := calcDiff(o, n)
+	events := diffEvents(o, n, keysToDelete, keysToAdd, time.Now())
 
 	c.lock.Lock()
 	for key, paths := range keysToDelete {
@@ -58,7 +67,10 @@ func (c *Cache) ApplyDiff(o, n *Cache) {
 	for key, cacheObj := range keysToAdd {
 		c.addNewFrom(key, cacheObj)
 	}
+	c.generation++
 	c.lock.Unlock()
+
+	return events
 }
 
 func calcDiff(o, n *Cache) (toDelete map[string][]PathObject, toAdd map[string]CacheObject) {
@@ -158,6 +170,7 @@ ForLoop:
 func (c *Cache) Merge(key string, obj CacheObject) {
 	c.lock.Lock()
 	c.addNewFrom(key, obj)
+	c.generation++
 	c.lock.Unlock()
 }
 
@@ -179,10 +192,10 @@ func (c *Cache) addNewFrom(key string, obj CacheObject) {
 			oIdx++
 			eIdx++
 		case obj.Paths[oIdx].LessThan(existing.Paths[eIdx]):
-			eIdx++
-		default:
 			existing.Paths = append(existing.Paths, obj.Paths[oIdx])
 			oIdx++
+		default:
+			eIdx++
 		}
 	}
 
@@ -191,28 +204,93 @@ func (c *Cache) addNewFrom(key string, obj CacheObject) {
 }
 
 func (c *Cache) Map() map[string]CacheObject {
+	ret, _ := c.Snapshot()
+	return ret
+}
+
+//Generation returns a number which changes whenever the cache does
+func (c *Cache) Generation() uint64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.generation
+}
+
+//Snapshot returns a copy of the cache's contents along with the generation
+// that they are from
+func (c *Cache) Snapshot() (map[string]CacheObject, uint64) {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	ret := make(map[string]CacheObject, len(c.store))
 	for k, v := range c.store {
 		ret[k] = v
 	}
-	return ret
+	return ret, c.generation
 }
 
 type CacheObject struct {
+	//Type is one of the CacheObjectType constants. If empty, the object is a
+	// certificate.
+	Type     string
 	Subject  pkix.Name
 	NotAfter time.Time
 	Paths    []PathObject
+
+	Issuer             pkix.Name
+	SerialNumber       *big.Int
+	DNSNames           []string
+	IPAddresses        []net.IP
+	URIs               []*url.URL
+	EmailAddresses     []string
+	NotBefore          time.Time
+	PublicKeyAlgorithm x509.PublicKeyAlgorithm
+	//KeySize is in bits
+	KeySize            int
+	SignatureAlgorithm x509.SignatureAlgorithm
+	//Fingerprint is the hex encoded SHA-256 hash of the DER certificate
+	Fingerprint string
+	IsCA        bool
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	//RawSubject, RawIssuer, SubjectKeyID and AuthorityKeyID are used to link
+	// certs to their issuers
+	RawSubject     []byte
+	RawIssuer      []byte
+	SubjectKeyID   []byte
+	AuthorityKeyID []byte
+	//Findings are the policy rules that the cert violates
+	Findings []PolicyFinding
+	//KeyAlgorithmName and SignatureAlgorithmName are used in place of
+	// PublicKeyAlgorithm and SignatureAlgorithm for objects which aren't X.509
+	KeyAlgorithmName       string
+	SignatureAlgorithmName string
+	//Principals are the users or hosts that an SSH certificate is valid for
+	Principals []string
+	//Usages are what an SSH certificate or OpenPGP key may be used for
+	Usages []string
+	//KeyID is the hex encoded fingerprint of an OpenPGP key
+	KeyID string
+	//Unverified is true if the self-signature that gives an OpenPGP key its
+	// expiry, or its user ID, uses an algorithm that can't be checked
+	Unverified bool
+	//Raw is the DER encoded certificate or CRL, the wire format of an SSH
+	// certificate, or the packets of an OpenPGP key and its self-signature
+	Raw []byte
 }
 
 type PathObject struct {
 	Location string
 	Source   string
+	//KeyMismatch is true if there was a private key alongside the cert at this
+	// location which did not match it
+	KeyMismatch bool
 }
 
 func (lhs PathObject) LessThan(rhs PathObject) bool {
 	if lhs.Source == rhs.Source {
+		if lhs.Location == rhs.Location {
+			return !lhs.KeyMismatch && rhs.KeyMismatch
+		}
+
 		return lhs.Location < rhs.Location
 	}
 
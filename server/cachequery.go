@@ -0,0 +1,279 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doomsday-project/doomsday/client/doomsday"
+	"github.com/doomsday-project/doomsday/duration"
+)
+
+//DefaultCacheSort is the order that the cache is returned in if the query
+// doesn't ask for another
+const DefaultCacheSort = "not_after"
+
+//cacheSortKey is the part of an item that the results are ordered by. Items
+// with the same key are ordered by fingerprint so that the order is total,
+// which a cursor needs to pick up from the right place.
+type cacheSortKey struct {
+	Num         int64  `json:"n,omitempty"`
+	Str         string `json:"s,omitempty"`
+	Fingerprint string `json:"f"`
+}
+
+func (k cacheSortKey) less(o cacheSortKey) bool {
+	if k.Num != o.Num {
+		return k.Num < o.Num
+	}
+
+	if k.Str != o.Str {
+		return k.Str < o.Str
+	}
+
+	return k.Fingerprint < o.Fingerprint
+}
+
+//cacheSortFields are the fields that the cache can be sorted by
+var cacheSortFields = map[string]func(doomsday.CacheItem) cacheSortKey{
+	"not_after": func(item doomsday.CacheItem) cacheSortKey {
+		return cacheSortKey{Num: item.NotAfter, Fingerprint: item.Fingerprint}
+	},
+	"effective_not_after": func(item doomsday.CacheItem) cacheSortKey {
+		notAfter := item.EffectiveNotAfter
+		if notAfter == 0 {
+			notAfter = item.NotAfter
+		}
+
+		return cacheSortKey{Num: notAfter, Fingerprint: item.Fingerprint}
+	},
+	"not_before": func(item doomsday.CacheItem) cacheSortKey {
+		return cacheSortKey{Num: item.NotBefore, Fingerprint: item.Fingerprint}
+	},
+	"name": func(item doomsday.CacheItem) cacheSortKey {
+		return cacheSortKey{Str: strings.ToLower(item.Name()), Fingerprint: item.Fingerprint}
+	},
+}
+
+//cacheCursor marks where the previous page of results ended
+type cacheCursor struct {
+	Sort string       `json:"sort"`
+	Last cacheSortKey `json:"last"`
+}
+
+//cacheQuery is what the client asked for in a request to list the cache
+type cacheQuery struct {
+	findings  []string
+	beyond    *time.Time
+	within    *time.Time
+	backend   string
+	name      string
+	nameRegex *regexp.Regexp
+	path      string
+	issuer    string
+	sort      string
+	sortKey   func(doomsday.CacheItem) cacheSortKey
+	desc      bool
+	limit     int
+	cursor    *cacheCursor
+}
+
+//parseCacheQuery reads the query parameters of a request to list the cache.
+// The error, if any, is suitable to show to the client.
+func parseCacheQuery(values url.Values, now time.Time) (*cacheQuery, error) {
+	ret := &cacheQuery{
+		findings: values["finding"],
+		backend:  values.Get("backend"),
+		name:     strings.ToLower(values.Get("name")),
+		path:     values.Get("path"),
+		issuer:   strings.ToLower(values.Get("issuer")),
+		sort:     values.Get("sort"),
+	}
+
+	for _, finding := range ret.findings {
+		if finding != "any" && !isPolicyRule(finding) {
+			return nil, fmt.Errorf("Unknown policy rule `%s'", finding)
+		}
+	}
+
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{
+		{"beyond", &ret.beyond},
+		{"within", &ret.within},
+	} {
+		if values.Get(param.name) == "" {
+			continue
+		}
+
+		dur, err := duration.Parse(values.Get(param.name))
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse `%s' duration: %s", param.name, err)
+		}
+
+		cutoff := now.Add(dur)
+		*param.dest = &cutoff
+	}
+
+	if nameRegex := values.Get("name_regex"); nameRegex != "" {
+		var err error
+		ret.nameRegex, err = regexp.Compile(nameRegex)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse `name_regex': %s", err)
+		}
+	}
+
+	if ret.sort == "" {
+		ret.sort = DefaultCacheSort
+	}
+
+	field := strings.TrimPrefix(ret.sort, "-")
+	ret.desc = field != ret.sort
+	ret.sortKey = cacheSortFields[field]
+	if ret.sortKey == nil {
+		return nil, fmt.Errorf("Unknown sort field `%s'", field)
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		var err error
+		ret.limit, err = strconv.Atoi(limit)
+		if err != nil || ret.limit <= 0 {
+			return nil, fmt.Errorf("`limit' must be a positive integer")
+		}
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		ret.cursor = &cacheCursor{}
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err == nil {
+			err = json.Unmarshal(raw, ret.cursor)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Malformed cursor")
+		}
+
+		if ret.cursor.Sort != ret.sort {
+			return nil, fmt.Errorf("The cursor is for a different sort order")
+		}
+	}
+
+	return ret, nil
+}
+
+//matches returns true if the item passes all of the filters in the query
+func (q *cacheQuery) matches(item doomsday.CacheItem) bool {
+	notAfter := time.Unix(item.NotAfter, 0)
+	if q.beyond != nil && !notAfter.After(*q.beyond) {
+		return false
+	}
+
+	if q.within != nil && notAfter.After(*q.within) {
+		return false
+	}
+
+	if len(q.findings) > 0 && !hasFinding(item, q.findings) {
+		return false
+	}
+
+	if q.backend != "" || q.path != "" {
+		found := false
+		for _, path := range item.Paths {
+			if (q.backend == "" || path.Backend == q.backend) && strings.HasPrefix(path.Location, q.path) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if q.name != "" || q.nameRegex != nil {
+		found := false
+		for _, name := range itemNames(item) {
+			if (q.name == "" || strings.Contains(strings.ToLower(name), q.name)) &&
+				(q.nameRegex == nil || q.nameRegex.MatchString(name)) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if q.issuer != "" &&
+		!strings.Contains(strings.ToLower(item.IssuerCommonName), q.issuer) &&
+		!strings.Contains(strings.ToLower(item.Issuer), q.issuer) {
+		return false
+	}
+
+	return true
+}
+
+//itemNames returns the common name and subject alternative names of the item
+func itemNames(item doomsday.CacheItem) []string {
+	ret := []string{item.CommonName}
+	for _, names := range [][]string{item.DNSNames, item.IPAddresses, item.URIs, item.EmailAddresses, item.Principals} {
+		ret = append(ret, names...)
+	}
+
+	return ret
+}
+
+func (q *cacheQuery) less(a, b cacheSortKey) bool {
+	if q.desc {
+		return b.less(a)
+	}
+
+	return a.less(b)
+}
+
+//apply filters and sorts the items, and returns the page of them after the
+// cursor. total is how many items matched across all pages. next is the cursor
+// for the following page, or empty if this is the last.
+func (q *cacheQuery) apply(items doomsday.CacheItems) (page doomsday.CacheItems, total int, next string) {
+	type keyedItem struct {
+		key  cacheSortKey
+		item doomsday.CacheItem
+	}
+
+	matched := []keyedItem{}
+	for _, item := range items {
+		if q.matches(item) {
+			matched = append(matched, keyedItem{key: q.sortKey(item), item: item})
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool { return q.less(matched[i].key, matched[j].key) })
+	total = len(matched)
+
+	//Carry on from the first item after the end of the last page, even if
+	// the last item on that page has since gone
+	if q.cursor != nil {
+		start := sort.Search(len(matched), func(i int) bool { return q.less(q.cursor.Last, matched[i].key) })
+		matched = matched[start:]
+	}
+
+	if q.limit > 0 && len(matched) > q.limit {
+		matched = matched[:q.limit]
+		raw, _ := json.Marshal(&cacheCursor{Sort: q.sort, Last: matched[len(matched)-1].key})
+		next = base64.RawURLEncoding.EncodeToString(raw)
+	}
+
+	page = make(doomsday.CacheItems, 0, len(matched))
+	for _, m := range matched {
+		page = append(page, m.item)
+	}
+
+	return page, total, next
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/doomsday-project/doomsday/client/doomsday"
+	"golang.org/x/crypto/ssh"
+)
+
+//newCacheObject extracts the information we keep about a certificate into a
+// CacheObject found at the given paths.
+func newCacheObject(cert *x509.Certificate, paths ...PathObject) CacheObject {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return CacheObject{
+		Type:               CacheObjectTypeCertificate,
+		Subject:            cert.Subject,
+		NotAfter:           cert.NotAfter,
+		Paths:              paths,
+		Issuer:             cert.Issuer,
+		SerialNumber:       cert.SerialNumber,
+		DNSNames:           cert.DNSNames,
+		IPAddresses:        cert.IPAddresses,
+		URIs:               cert.URIs,
+		EmailAddresses:     cert.EmailAddresses,
+		NotBefore:          cert.NotBefore,
+		PublicKeyAlgorithm: cert.PublicKeyAlgorithm,
+		KeySize:            publicKeySize(cert.PublicKey),
+		SignatureAlgorithm: cert.SignatureAlgorithm,
+		Fingerprint:        hex.EncodeToString(fingerprint[:]),
+		IsCA:               cert.IsCA,
+		KeyUsage:           cert.KeyUsage,
+		ExtKeyUsage:        cert.ExtKeyUsage,
+		RawSubject:         cert.RawSubject,
+		RawIssuer:          cert.RawIssuer,
+		SubjectKeyID:       cert.SubjectKeyId,
+		AuthorityKeyID:     cert.AuthorityKeyId,
+		Raw:                cert.Raw,
+	}
+}
+
+//newCacheItem converts a CacheObject into its API representation
+func newCacheItem(obj CacheObject) doomsday.CacheItem {
+	paths := []doomsday.CacheItemPath{}
+	keyMismatch := false
+	for _, path := range obj.Paths {
+		paths = append(paths, doomsday.CacheItemPath{
+			Backend:     path.Source,
+			Location:    path.Location,
+			KeyMismatch: path.KeyMismatch,
+		})
+		keyMismatch = keyMismatch || path.KeyMismatch
+	}
+
+	ret := doomsday.CacheItem{
+		Type:               obj.Type,
+		Paths:              paths,
+		CommonName:         obj.Subject.CommonName,
+		NotAfter:           obj.NotAfter.Unix(),
+		Subject:            obj.Subject.String(),
+		Issuer:             obj.Issuer.String(),
+		IssuerCommonName:   obj.Issuer.CommonName,
+		DNSNames:           obj.DNSNames,
+		EmailAddresses:     obj.EmailAddresses,
+		NotBefore:          obj.NotBefore.Unix(),
+		KeyAlgorithm:       obj.PublicKeyAlgorithm.String(),
+		KeySize:            obj.KeySize,
+		SignatureAlgorithm: obj.SignatureAlgorithm.String(),
+		Fingerprint:        obj.Fingerprint,
+		IsCA:               obj.IsCA,
+		KeyUsages:          keyUsageStrings(obj.KeyUsage),
+		ExtKeyUsages:       extKeyUsageStrings(obj.ExtKeyUsage),
+		KeyMismatch:        keyMismatch,
+	}
+
+	if obj.Type == "" {
+		ret.Type = CacheObjectTypeCertificate
+	}
+
+	switch obj.Type {
+	//CRLs have no key of their own
+	case CacheObjectTypeCRL:
+		ret.KeyAlgorithm, ret.SignatureAlgorithm = "", ""
+	case CacheObjectTypeSSHCertificate, CacheObjectTypePGPKey:
+		//These names aren't DNs, so they shouldn't be escaped like them
+		ret.Subject, ret.Issuer = obj.Subject.CommonName, obj.Issuer.CommonName
+		ret.KeyAlgorithm = obj.KeyAlgorithmName
+		ret.SignatureAlgorithm = obj.SignatureAlgorithmName
+		ret.KeyUsages = obj.Usages
+		ret.ExtKeyUsages = nil
+		ret.Principals = obj.Principals
+		ret.KeyID = obj.KeyID
+		ret.Unverified = obj.Unverified
+	}
+
+	if obj.SerialNumber != nil {
+		ret.SerialNumber = fmt.Sprintf("%x", obj.SerialNumber)
+	}
+
+	for _, ip := range obj.IPAddresses {
+		ret.IPAddresses = append(ret.IPAddresses, ip.String())
+	}
+
+	for _, uri := range obj.URIs {
+		ret.URIs = append(ret.URIs, uri.String())
+	}
+
+	for _, finding := range obj.Findings {
+		ret.Findings = append(ret.Findings, doomsday.PolicyFinding{
+			Rule:    finding.Rule,
+			Message: finding.Message,
+		})
+	}
+
+	return ret
+}
+
+//newCacheItemDetail converts a CacheObject into its detailed API
+// representation, which includes everything from newCacheItem and then some.
+func newCacheItemDetail(obj CacheObject, withPEM bool) (*doomsday.CacheItemDetail, error) {
+	switch obj.Type {
+	case CacheObjectTypeCRL:
+		return newCRLCacheItemDetail(obj, withPEM)
+	case CacheObjectTypeSSHCertificate:
+		return newSSHCertCacheItemDetail(obj, withPEM)
+	case CacheObjectTypePGPKey:
+		//There's nothing more to say about a key than is in the cache item
+		return &doomsday.CacheItemDetail{CacheItem: newCacheItem(obj)}, nil
+	}
+
+	cert, err := x509.ParseCertificate(obj.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse cached certificate: %s", err)
+	}
+
+	ret := &doomsday.CacheItemDetail{
+		CacheItem:             newCacheItem(obj),
+		Version:               cert.Version,
+		SubjectKeyID:          hex.EncodeToString(cert.SubjectKeyId),
+		AuthorityKeyID:        hex.EncodeToString(cert.AuthorityKeyId),
+		CRLDistributionPoints: cert.CRLDistributionPoints,
+		OCSPServers:           cert.OCSPServer,
+		IssuingCertificateURL: cert.IssuingCertificateURL,
+	}
+
+	if cert.BasicConstraintsValid && cert.IsCA && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+		maxPathLen := cert.MaxPathLen
+		ret.MaxPathLen = &maxPathLen
+	}
+
+	if withPEM {
+		ret.PEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: obj.Raw}))
+	}
+
+	return ret, nil
+}
+
+func newCRLCacheItemDetail(obj CacheObject, withPEM bool) (*doomsday.CacheItemDetail, error) {
+	crl, err := x509.ParseDERCRL(obj.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse cached CRL: %s", err)
+	}
+
+	numRevoked := len(crl.TBSCertList.RevokedCertificates)
+	ret := &doomsday.CacheItemDetail{
+		CacheItem:      newCacheItem(obj),
+		Version:        crl.TBSCertList.Version + 1,
+		AuthorityKeyID: hex.EncodeToString(obj.AuthorityKeyID),
+		NumRevoked:     &numRevoked,
+	}
+
+	if withPEM {
+		ret.PEM = string(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: obj.Raw}))
+	}
+
+	return ret, nil
+}
+
+func newSSHCertCacheItemDetail(obj CacheObject, withPEM bool) (*doomsday.CacheItemDetail, error) {
+	key, err := ssh.ParsePublicKey(obj.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse cached SSH certificate: %s", err)
+	}
+
+	ret := &doomsday.CacheItemDetail{CacheItem: newCacheItem(obj)}
+	//SSH certs don't come in PEM, so give them in authorized_keys format
+	if withPEM {
+		ret.PEM = string(ssh.MarshalAuthorizedKey(key))
+	}
+
+	return ret, nil
+}
+
+//publicKeySize returns the size of the key in bits, or 0 if the key type is
+// not known
+func publicKeySize(key interface{}) int {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return len(k) * 8
+	case *dsa.PublicKey:
+		return k.P.BitLen()
+	}
+
+	return 0
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digital_signature"},
+	{x509.KeyUsageContentCommitment, "content_commitment"},
+	{x509.KeyUsageKeyEncipherment, "key_encipherment"},
+	{x509.KeyUsageDataEncipherment, "data_encipherment"},
+	{x509.KeyUsageKeyAgreement, "key_agreement"},
+	{x509.KeyUsageCertSign, "cert_sign"},
+	{x509.KeyUsageCRLSign, "crl_sign"},
+	{x509.KeyUsageEncipherOnly, "encipher_only"},
+	{x509.KeyUsageDecipherOnly, "decipher_only"},
+}
+
+func keyUsageStrings(usage x509.KeyUsage) []string {
+	ret := []string{}
+	for _, u := range keyUsageNames {
+		if usage&u.usage != 0 {
+			ret = append(ret, u.name)
+		}
+	}
+
+	return ret
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:                            "any",
+	x509.ExtKeyUsageServerAuth:                     "server_auth",
+	x509.ExtKeyUsageClientAuth:                     "client_auth",
+	x509.ExtKeyUsageCodeSigning:                    "code_signing",
+	x509.ExtKeyUsageEmailProtection:                "email_protection",
+	x509.ExtKeyUsageIPSECEndSystem:                 "ipsec_end_system",
+	x509.ExtKeyUsageIPSECTunnel:                    "ipsec_tunnel",
+	x509.ExtKeyUsageIPSECUser:                      "ipsec_user",
+	x509.ExtKeyUsageTimeStamping:                   "time_stamping",
+	x509.ExtKeyUsageOCSPSigning:                    "ocsp_signing",
+	x509.ExtKeyUsageMicrosoftServerGatedCrypto:     "microsoft_server_gated_crypto",
+	x509.ExtKeyUsageNetscapeServerGatedCrypto:      "netscape_server_gated_crypto",
+	x509.ExtKeyUsageMicrosoftCommercialCodeSigning: "microsoft_commercial_code_signing",
+	x509.ExtKeyUsageMicrosoftKernelCodeSigning:     "microsoft_kernel_code_signing",
+}
+
+func extKeyUsageStrings(usages []x509.ExtKeyUsage) []string {
+	ret := []string{}
+	for _, u := range usages {
+		name, found := extKeyUsageNames[u]
+		if !found {
+			name = fmt.Sprintf("unknown(%d)", u)
+		}
+
+		ret = append(ret, name)
+	}
+
+	return ret
+}
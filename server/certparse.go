@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+)
+
+//oidSignedData is the PKCS#7 content type for signed data, which is what
+// certificate bundles (.p7b/.p7c) are encoded as
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     pkcs7RawCertificates `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue        `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+type pkcs7RawCertificates struct {
+	Raw asn1.RawContent
+}
+
+//parseCertBytes finds certificates in the given value, which may be PEM
+// (containing CERTIFICATE or PKCS7 blocks), DER, a DER PKCS#7 bundle, or any
+// of those base64 encoded.
+func parseCertBytes(b []byte) []*x509.Certificate {
+	if certs := parsePEMCerts(b); len(certs) > 0 {
+		return certs
+	}
+
+	if certs := parseDERCerts(b); len(certs) > 0 {
+		return certs
+	}
+
+	//Only unwrap one layer of base64 so that we don't spend our time decoding
+	// every string we see over and over
+	if decoded, ok := decodeBase64(b); ok {
+		if certs := parsePEMCerts(decoded); len(certs) > 0 {
+			return certs
+		}
+
+		return parseDERCerts(decoded)
+	}
+
+	return nil
+}
+
+func parsePEMCerts(b []byte) []*x509.Certificate {
+	certs := []*x509.Certificate{}
+	//Populate a potential chain of certs (or even just one) into this here slice
+	var pemBlock *pem.Block
+	var rest = b
+	for {
+		pemBlock, rest = pem.Decode(rest)
+		if pemBlock == nil {
+			break
+		}
+
+		//Skip over potential private keys in a cert chain.
+		switch pemBlock.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(pemBlock.Bytes)
+			if err == nil {
+				certs = append(certs, cert)
+			}
+		case "PKCS7":
+			certs = append(certs, parsePKCS7Certs(pemBlock.Bytes)...)
+		}
+
+		if len(rest) == 0 {
+			break
+		}
+	}
+
+	return certs
+}
+
+//parseDERCerts parses one or more concatenated DER certificates, or a DER
+// encoded PKCS#7 bundle
+func parseDERCerts(b []byte) []*x509.Certificate {
+	//Everything we're looking for is a DER SEQUENCE
+	if len(b) == 0 || b[0] != 0x30 {
+		return nil
+	}
+
+	certs, err := x509.ParseCertificates(b)
+	if err == nil {
+		return certs
+	}
+
+	return parsePKCS7Certs(b)
+}
+
+//parsePKCS7Certs returns the certificates in a DER encoded PKCS#7 SignedData
+// structure. Signatures and CRLs are ignored.
+func parsePKCS7Certs(b []byte) []*x509.Certificate {
+	var info pkcs7ContentInfo
+	_, err := asn1.Unmarshal(b, &info)
+	if err != nil || !info.ContentType.Equal(oidSignedData) {
+		return nil
+	}
+
+	var signedData pkcs7SignedData
+	_, err = asn1.Unmarshal(info.Content.Bytes, &signedData)
+	if err != nil || len(signedData.Certificates.Raw) == 0 {
+		return nil
+	}
+
+	var certSet asn1.RawValue
+	_, err = asn1.Unmarshal(signedData.Certificates.Raw, &certSet)
+	if err != nil {
+		return nil
+	}
+
+	certs, err := x509.ParseCertificates(certSet.Bytes)
+	if err != nil {
+		return nil
+	}
+
+	return certs
+}
+
+//decodeBase64 decodes standard or URL base64, with or without padding, that
+// may be broken over multiple lines
+func decodeBase64(b []byte) ([]byte, bool) {
+	trimmed := strings.Join(strings.Fields(string(b)), "")
+	//Anything shorter couldn't possibly hold a certificate
+	const minLen = 64
+	if len(trimmed) < minLen {
+		return nil, false
+	}
+
+	for _, encoding := range []*base64.Encoding{
+		base64.StdEncoding,
+		base64.RawStdEncoding,
+		base64.URLEncoding,
+		base64.RawURLEncoding,
+	} {
+		decoded, err := encoding.DecodeString(trimmed)
+		if err == nil {
+			return decoded, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+//certs.pem holds two self signed certs, for "Keystore Example" and "Bundle
+// Example", and certs.p7b is the same two certs in a DER PKCS#7 bundle, made
+// with openssl crl2pkcs7
+func TestParseCertBytes(t *testing.T) {
+	pemCerts := readTestFile(t, "certs.pem")
+	p7b := readTestFile(t, "certs.p7b")
+
+	var der [][]byte
+	for rest := pemCerts; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		der = append(der, block.Bytes)
+	}
+
+	if len(der) != 2 {
+		t.Fatalf("Got %d certs in certs.pem, want 2", len(der))
+	}
+
+	keyBlock := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("not a key")})
+	pemP7B := pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: p7b})
+	both := []string{"Keystore Example", "Bundle Example"}
+
+	tests := []struct {
+		name  string
+		value []byte
+		//want is the common name of each cert expected
+		want []string
+	}{
+		{name: "pem", value: pemCerts, want: both},
+		{name: "pem with a key", value: append(append([]byte{}, keyBlock...), pemCerts...), want: both},
+		{name: "pem pkcs7", value: pemP7B, want: both},
+		{name: "der", value: der[1], want: both[1:]},
+		{name: "concatenated der", value: bytes.Join(der, nil), want: both},
+		{name: "der pkcs7", value: p7b, want: both},
+		{name: "base64 pem", value: []byte(base64.StdEncoding.EncodeToString(pemCerts)), want: both},
+		{name: "base64 der", value: []byte(base64.StdEncoding.EncodeToString(der[0])), want: both[:1]},
+		{name: "unpadded url base64 pkcs7", value: []byte(base64.RawURLEncoding.EncodeToString(p7b)), want: both},
+		{
+			name:  "base64 over several lines",
+			value: []byte(strings.Join(splitEvery(base64.StdEncoding.EncodeToString(der[0]), 76), "\n")),
+			want:  both[:1],
+		},
+		{name: "base64 twice", value: []byte(base64.StdEncoding.EncodeToString([]byte(base64.StdEncoding.EncodeToString(der[0]))))},
+		{name: "truncated der", value: der[0][:len(der[0])/2]},
+		{name: "only a key", value: keyBlock},
+		{name: "short base64", value: []byte("aGVsbG8gd29ybGQ=")},
+		{name: "not a cert", value: []byte("password123")},
+		{name: "empty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			certs := parseCertBytes(test.value)
+			if len(certs) != len(test.want) {
+				t.Fatalf("Got %d certs, want %d", len(certs), len(test.want))
+			}
+
+			for i, cert := range certs {
+				if cert.Subject.CommonName != test.want[i] {
+					t.Errorf("Cert %d: got %q, want %q", i, cert.Subject.CommonName, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func splitEvery(s string, n int) []string {
+	var ret []string
+	for len(s) > n {
+		ret = append(ret, s[:n])
+		s = s[n:]
+	}
+
+	return append(ret, s)
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/doomsday-project/doomsday/client/doomsday"
+)
+
+//chainGraph links each cert in a cache to the certs in that cache which could
+// have issued it. A cert is considered to be issued by another if its issuer
+// DN is the other's subject DN and, if both are present, its authority key ID
+// is the other's subject key ID. OpenPGP subkeys are linked to their primary
+// key in the same way. Keys in the graph are SHA-256 fingerprints.
+type chainGraph struct {
+	objs       map[string]CacheObject
+	issuers    map[string][]string
+	dependents map[string][]string
+	effective  map[string]time.Time
+}
+
+func newChainGraph(cache map[string]CacheObject) *chainGraph {
+	ret := &chainGraph{
+		objs:       make(map[string]CacheObject, len(cache)),
+		issuers:    map[string][]string{},
+		dependents: map[string][]string{},
+		effective:  make(map[string]time.Time, len(cache)),
+	}
+
+	bySubject := map[string][]string{}
+	for _, obj := range cache {
+		ret.objs[obj.Fingerprint] = obj
+		//Objects such as SSH certs have nothing to link on
+		if len(obj.RawSubject) > 0 {
+			bySubject[string(obj.RawSubject)] = append(bySubject[string(obj.RawSubject)], obj.Fingerprint)
+		}
+	}
+
+	for fingerprint, obj := range ret.objs {
+		if len(obj.RawIssuer) == 0 {
+			continue
+		}
+
+		for _, candidate := range bySubject[string(obj.RawIssuer)] {
+			if candidate == fingerprint {
+				continue
+			}
+
+			issuer := ret.objs[candidate]
+			if len(obj.AuthorityKeyID) > 0 && len(issuer.SubjectKeyID) > 0 &&
+				!bytes.Equal(obj.AuthorityKeyID, issuer.SubjectKeyID) {
+				continue
+			}
+
+			ret.issuers[fingerprint] = append(ret.issuers[fingerprint], candidate)
+			ret.dependents[candidate] = append(ret.dependents[candidate], fingerprint)
+		}
+	}
+
+	for _, list := range ret.issuers {
+		sort.Strings(list)
+	}
+
+	for _, list := range ret.dependents {
+		sort.Strings(list)
+	}
+
+	ret.computeEffective()
+	return ret
+}
+
+//effectiveNotAfter returns the time at which the cert stops being usable,
+// which is the earlier of its own expiry and that of its issuer. Where there
+// are multiple candidate issuers (such as with a renewed or cross-signed CA),
+// the issuer which lasts the longest is used. Certs which only issue each
+// other, such as CAs which cross-sign each other with nothing above them in
+// the cache, are each treated as a root.
+func (g *chainGraph) effectiveNotAfter(fingerprint string) time.Time {
+	return g.effective[fingerprint]
+}
+
+//computeEffective fills in the effective expiry of every cert in the graph.
+// Cross-signing can make loops in the graph, so the certs are grouped into
+// strongly connected components with Tarjan's algorithm, which finishes each
+// component only after every component it can reach. So the issuers outside
+// of a component are always done by the time the component is.
+func (g *chainGraph) computeEffective() {
+	fingerprints := make([]string, 0, len(g.objs))
+	for fingerprint := range g.objs {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	sort.Strings(fingerprints)
+
+	index := make(map[string]int, len(g.objs))
+	lowLink := make(map[string]int, len(g.objs))
+	onStack := map[string]bool{}
+	stack := []string{}
+
+	var visit func(string)
+	visit = func(fingerprint string) {
+		index[fingerprint] = len(index)
+		lowLink[fingerprint] = index[fingerprint]
+		stack = append(stack, fingerprint)
+		onStack[fingerprint] = true
+
+		for _, issuer := range g.issuers[fingerprint] {
+			if _, seen := index[issuer]; !seen {
+				visit(issuer)
+				if lowLink[issuer] < lowLink[fingerprint] {
+					lowLink[fingerprint] = lowLink[issuer]
+				}
+			} else if onStack[issuer] && index[issuer] < lowLink[fingerprint] {
+				lowLink[fingerprint] = index[issuer]
+			}
+		}
+
+		if lowLink[fingerprint] != index[fingerprint] {
+			return
+		}
+
+		var component []string
+		for {
+			last := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[last] = false
+			component = append(component, last)
+			if last == fingerprint {
+				break
+			}
+		}
+
+		g.computeComponentEffective(component)
+	}
+
+	for _, fingerprint := range fingerprints {
+		if _, seen := index[fingerprint]; !seen {
+			visit(fingerprint)
+		}
+	}
+}
+
+//computeComponentEffective fills in the effective expiry of the certs in a
+// strongly connected component, once those of all the issuers outside of it
+// are known
+func (g *chainGraph) computeComponentEffective(component []string) {
+	inComponent := make(map[string]bool, len(component))
+	for _, fingerprint := range component {
+		inComponent[fingerprint] = true
+	}
+
+	isRoot := true
+	bestOutside := map[string]time.Time{}
+	for _, fingerprint := range component {
+		for _, issuer := range g.issuers[fingerprint] {
+			if inComponent[issuer] {
+				continue
+			}
+
+			isRoot = false
+			if t := g.effective[issuer]; t.After(bestOutside[fingerprint]) {
+				bestOutside[fingerprint] = t
+			}
+		}
+	}
+
+	for _, fingerprint := range component {
+		g.effective[fingerprint] = g.objs[fingerprint].NotAfter
+		if !isRoot {
+			g.effective[fingerprint] = earlierTime(g.objs[fingerprint].NotAfter, bestOutside[fingerprint])
+		}
+	}
+
+	if isRoot {
+		return
+	}
+
+	//A cert may get to the best issuer outside of the component by way of the
+	// other certs in it. Each pass can only make expiries later, and only to
+	// one of the finitely many expiries in the component, so this finishes.
+	for changed := true; changed; {
+		changed = false
+		for _, fingerprint := range component {
+			for _, issuer := range g.issuers[fingerprint] {
+				if !inComponent[issuer] {
+					continue
+				}
+
+				t := earlierTime(g.objs[fingerprint].NotAfter, g.effective[issuer])
+				if t.After(g.effective[fingerprint]) {
+					g.effective[fingerprint] = t
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+func earlierTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+
+	return a
+}
+
+//chain returns the fingerprints of the cert and its issuers, up to the last
+// issuer that is in the cache. Where there are multiple candidate issuers, the
+// one with the latest effective expiry is followed.
+func (g *chainGraph) chain(fingerprint string) []string {
+	ret := []string{fingerprint}
+	seen := map[string]bool{fingerprint: true}
+	for cur := fingerprint; ; {
+		var next string
+		var nextExpiry time.Time
+		for _, issuer := range g.issuers[cur] {
+			if seen[issuer] {
+				continue
+			}
+
+			if t := g.effectiveNotAfter(issuer); next == "" || t.After(nextExpiry) {
+				next, nextExpiry = issuer, t
+			}
+		}
+
+		if next == "" {
+			break
+		}
+
+		ret = append(ret, next)
+		seen[next] = true
+		cur = next
+	}
+
+	return ret
+}
+
+//descendants returns the fingerprints of all certs issued directly or
+// indirectly by the given cert
+func (g *chainGraph) descendants(fingerprint string) []string {
+	ret := []string{}
+	seen := map[string]bool{fingerprint: true}
+	queue := []string{fingerprint}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, dependent := range g.dependents[cur] {
+			if seen[dependent] {
+				continue
+			}
+
+			seen[dependent] = true
+			ret = append(ret, dependent)
+			queue = append(queue, dependent)
+		}
+	}
+
+	return ret
+}
+
+//annotate fills in the chain information of the given item
+func (g *chainGraph) annotate(item *doomsday.CacheItem) {
+	item.EffectiveNotAfter = g.effectiveNotAfter(item.Fingerprint).Unix()
+	item.Issuers = g.issuers[item.Fingerprint]
+}
+
+//items returns the annotated CacheItems for the given fingerprints
+func (g *chainGraph) items(fingerprints []string) doomsday.CacheItems {
+	ret := doomsday.CacheItems{}
+	for _, fingerprint := range fingerprints {
+		item := newCacheItem(g.objs[fingerprint])
+		g.annotate(&item)
+		ret = append(ret, item)
+	}
+
+	return ret
+}
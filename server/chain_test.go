@@ -0,0 +1,122 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func chainTestCert(fingerprint, subject, issuer string, year int) CacheObject {
+	return CacheObject{
+		Fingerprint: fingerprint,
+		RawSubject:  []byte(subject),
+		RawIssuer:   []byte(issuer),
+		NotAfter:    time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestChainGraphEffectiveNotAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		certs []CacheObject
+		//want is the year of the effective expiry of each cert
+		want map[string]int
+	}{
+		{
+			name: "chain",
+			certs: []CacheObject{
+				chainTestCert("root", "R", "R", 2030),
+				chainTestCert("intermediate", "I", "R", 2028),
+				chainTestCert("leaf", "L", "I", 2029),
+			},
+			want: map[string]int{"root": 2030, "intermediate": 2028, "leaf": 2028},
+		},
+		{
+			name: "renewed issuer",
+			certs: []CacheObject{
+				chainTestCert("old", "R", "R", 2025),
+				chainTestCert("new", "R", "R", 2035),
+				chainTestCert("leaf", "L", "R", 2030),
+			},
+			want: map[string]int{"old": 2025, "new": 2035, "leaf": 2030},
+		},
+		{
+			name: "issuer not in cache",
+			certs: []CacheObject{
+				chainTestCert("leaf", "L", "I", 2030),
+			},
+			want: map[string]int{"leaf": 2030},
+		},
+		{
+			name: "cross-signed with nothing above",
+			certs: []CacheObject{
+				chainTestCert("a", "A", "B", 2030),
+				chainTestCert("b", "B", "A", 2025),
+				chainTestCert("leaf", "L", "A", 2040),
+			},
+			want: map[string]int{"a": 2030, "b": 2025, "leaf": 2030},
+		},
+		{
+			//x can only get to the root through y2, and y1 can only get there
+			// through x, whichever of them is looked at first
+			name: "cross-signed below a root",
+			certs: []CacheObject{
+				chainTestCert("x", "X", "Y", 2040),
+				chainTestCert("y1", "Y", "X", 2040),
+				chainTestCert("y2", "Y", "R", 2031),
+				chainTestCert("root", "R", "R", 2032),
+			},
+			want: map[string]int{"x": 2031, "y1": 2031, "y2": 2031, "root": 2032},
+		},
+		{
+			name: "loop with a longer way out",
+			certs: []CacheObject{
+				chainTestCert("a", "A", "B", 2040),
+				chainTestCert("b", "B", "C", 2038),
+				chainTestCert("c", "C", "A", 2039),
+				chainTestCert("c2", "C", "R", 2036),
+				chainTestCert("b2", "B", "S", 2033),
+				chainTestCert("r", "R", "R", 2037),
+				chainTestCert("s", "S", "S", 2050),
+			},
+			want: map[string]int{"a": 2036, "b": 2036, "c": 2036, "c2": 2036, "b2": 2033, "r": 2037, "s": 2050},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := map[string]CacheObject{}
+			for _, cert := range test.certs {
+				cache[cert.Fingerprint] = cert
+			}
+
+			graph := newChainGraph(cache)
+			for fingerprint, year := range test.want {
+				got := graph.effectiveNotAfter(fingerprint)
+				if got.Year() != year {
+					t.Errorf("%s: got %d, want %d", fingerprint, got.Year(), year)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheGeneration(t *testing.T) {
+	cache := NewCache()
+	start := cache.Generation()
+
+	cache.Store("a", chainTestCert("a", "A", "A", 2030))
+	afterStore := cache.Generation()
+	if afterStore == start {
+		t.Errorf("Store didn't change the generation")
+	}
+
+	_, generation := cache.Snapshot()
+	if generation != afterStore {
+		t.Errorf("Snapshot gave generation %d, want %d", generation, afterStore)
+	}
+
+	cache.Merge("b", chainTestCert("b", "B", "A", 2030))
+	if cache.Generation() == afterStore {
+		t.Errorf("Merge didn't change the generation")
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/doomsday-project/doomsday/server/auth"
 	"github.com/doomsday-project/doomsday/server/notify"
+	"github.com/doomsday-project/doomsday/storage"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -15,12 +16,22 @@ type Config struct {
 	Backends      []BackendConfig `yaml:"backends"`
 	Server        APIConfig       `yaml:"server"`
 	Notifications notify.Config   `yaml:"notifications"`
+	Policy        PolicyConfig    `yaml:"policy"`
 }
 
 type APIConfig struct {
 	Port    uint16 `yaml:"port"`
 	LogFile string `yaml:"logfile"`
-	TLS     struct {
+	//CacheFile is where the cache is saved to so that it survives restarts
+	CacheFile string `yaml:"cache_file"`
+	//HistorySize is the number of history events to keep
+	HistorySize int `yaml:"history_size"`
+	//Metrics, if true, serves Prometheus metrics at /metrics
+	Metrics bool `yaml:"metrics"`
+	//MetricsToken, if set, is the bearer token needed to read /metrics in
+	// place of the usual auth
+	MetricsToken string `yaml:"metrics_token"`
+	TLS          struct {
 		Cert string `yaml:"cert"`
 		Key  string `yaml:"key"`
 	} `yaml:"tls"`
@@ -36,6 +47,11 @@ type BackendConfig struct {
 	//in minutes
 	RefreshInterval int                    `yaml:"refresh_interval"`
 	Properties      map[string]interface{} `yaml:"properties"`
+	//Include and Exclude restrict which listed paths are fetched
+	Include *storage.PathFilter `yaml:"include"`
+	Exclude *storage.PathFilter `yaml:"exclude"`
+	//Keystore configures how PKCS#12 and JKS keystores are opened
+	Keystore KeystoreConfig `yaml:"keystore"`
 }
 
 func ParseConfig(path string) (*Config, error) {
@@ -52,7 +68,11 @@ func ParseConfig(path string) (*Config, error) {
 	//Set defaults
 	conf := Config{
 		Server: APIConfig{
-			Port: 8111,
+			Port:        8111,
+			HistorySize: DefaultHistorySize,
+		},
+		Notifications: notify.Config{
+			CRLSoonHours: notify.DefaultCRLSoonHours,
 		},
 	}
 
@@ -83,10 +103,34 @@ func ParseConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("Port number is invalid")
 	}
 
+	if conf.Server.HistorySize < 0 {
+		return nil, fmt.Errorf("History size cannot be negative")
+	}
+
+	if conf.Notifications.CRLSoonHours < 0 {
+		return nil, fmt.Errorf("CRL soon hours cannot be negative")
+	}
+
 	for _, b := range conf.Backends {
 		if b.RefreshInterval <= 0 {
 			return nil, fmt.Errorf("Refresh interval for backend must be greater than or equal to 0 - got %d", b.RefreshInterval)
 		}
+
+		if b.Include != nil {
+			if err := b.Include.Validate(); err != nil {
+				return nil, fmt.Errorf("Invalid include filter for backend `%s': %s", b.Name, err)
+			}
+		}
+
+		if b.Exclude != nil {
+			if err := b.Exclude.Validate(); err != nil {
+				return nil, fmt.Errorf("Invalid exclude filter for backend `%s': %s", b.Name, err)
+			}
+		}
+	}
+
+	if _, err := NewPolicy(conf.Policy); err != nil {
+		return nil, fmt.Errorf("Invalid policy: %s", err)
 	}
 
 	return &conf, nil
@@ -1,11 +1,12 @@
 package server
 
 import (
+	"crypto"
 	"crypto/sha1"
 	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,16 +16,40 @@ import (
 )
 
 type Core struct {
-	Backend   storage.Accessor
-	Name      string
+	Backend storage.Accessor
+	Name    string
+	//Type is the kind of storage backend, as given in the config
+	Type string
+	//Include and Exclude, if non-nil, are applied to the paths listed from the
+	// backend before any of them are fetched
+	Include *storage.PathFilter
+	Exclude *storage.PathFilter
+	//Keystore configures the passwords to try on keystores found in secrets
+	Keystore KeystoreConfig
+	//Policy, if non-nil, is checked against each cert found
+	Policy    *Policy
 	cache     *Cache
 	cacheLock sync.RWMutex
 }
 
 type PopulateStats struct {
-	NumPaths   int
-	NumSuccess int
-	NumCerts   int
+	NumPaths    int
+	NumFiltered int
+	NumSuccess  int
+	NumCerts    int
+	//NumOther is the number of CRLs, SSH certs, and OpenPGP keys found
+	NumOther int
+	//Warnings holds the paths for which the backend returned a
+	// *storage.Warning, sorted by path
+	Warnings []PathError
+	//Errors holds the paths which could not be fetched, sorted by path
+	Errors []PathError
+}
+
+//PathError is a failure to fetch a single path from a backend
+type PathError struct {
+	Path string
+	Err  error
 }
 
 func (b *Core) SetCache(c *Cache) {
@@ -38,6 +63,10 @@ func (b *Core) Cache() *Cache {
 	return b.cache
 }
 
+//Populate lists and fetches all paths from the backend and replaces the cache
+// with the certs found. If an error is returned, the cache is left as it was.
+// The returned stats may be non-nil even if an error is returned, in which
+// case they describe the paths that failed.
 func (b *Core) Populate() (*PopulateStats, error) {
 	newCache := NewCache()
 	paths, err := b.Backend.List()
@@ -45,9 +74,12 @@ func (b *Core) Populate() (*PopulateStats, error) {
 		return nil, err
 	}
 
-	results, err := b.populateUsing(newCache, paths)
+	filteredPaths := paths.Filter(b.Include, b.Exclude)
+
+	results, err := b.populateUsing(newCache, filteredPaths)
+	results.NumFiltered = len(paths) - len(filteredPaths)
 	if err != nil {
-		return nil, err
+		return results, err
 	}
 
 	b.SetCache(newCache)
@@ -57,6 +89,9 @@ func (b *Core) Populate() (*PopulateStats, error) {
 type x509CertWrapper struct {
 	path string
 	cert *x509.Certificate
+	//first is true if this was the first cert found at its path, and not the
+	// rest of a chain
+	first bool
 }
 
 func (b *Core) populateUsing(cache *Cache, paths storage.PathList) (*PopulateStats, error) {
@@ -82,55 +117,102 @@ func (b *Core) populateUsing(cache *Cache, paths storage.PathList) (*PopulateSta
 	close(queue)
 
 	certCount := 0
+	otherCount := 0
 	successCount := 0
 	statLock := sync.Mutex{}
 
 	var errLock sync.Mutex
-	var errors []error
+	var errors []PathError
+	var warnings []PathError
 
 	fetch := func() {
-		mySuccessCount, myCertCount := 0, 0
+		mySuccessCount, myCertCount, myOtherCount := 0, 0, 0
 		for path := range queue {
 			secret, err := b.Backend.Get(path)
 			if err != nil {
 				errLock.Lock()
-				errors = append(errors, err)
+				if _, isWarning := err.(*storage.Warning); isWarning {
+					warnings = append(warnings, PathError{Path: path, Err: err})
+				} else {
+					errors = append(errors, PathError{Path: path, Err: err})
+				}
 				errLock.Unlock()
 				continue
 			}
 
+			var passwords []string
+			var secretCerts []x509CertWrapper
+			var secretKeys []crypto.PublicKey
 			for k, v := range secret {
 				certs := wrapCerts(parseCert(v), k)
+				keys := parsePrivateKeys(v)
+				others := wrapObjects(parseObjects(v), k)
+				if len(certs) == 0 {
+					if passwords == nil {
+						passwords = b.Keystore.candidatePasswords(secret)
+					}
+
+					keystoreCerts, err := parseKeystore(v, passwords)
+					if err != nil {
+						errLock.Lock()
+						warnings = append(warnings, PathError{
+							Path: path,
+							Err:  storage.Warnf("Could not open keystore at key `%s': %s", k, err),
+						})
+						errLock.Unlock()
+					}
+
+					certs = wrapCerts(keystoreCerts, k)
+				}
 				if len(certs) == 0 {
-					keys, err := parseYAMLKeys(v)
+					yamlKeys, err := parseYAMLKeys(v)
 					if err == nil {
-						for _, str := range keys {
+						//A PEM key or other object at the top level would otherwise be found
+						// again here
+						keysInTree, othersInTree := len(keys) == 0, len(others) == 0
+						for _, str := range yamlKeys {
 							certs = append(certs, wrapCerts(parseCert(str.Value), k+":"+str.Path)...)
+							if keysInTree {
+								keys = append(keys, parsePrivateKeys(str.Value)...)
+							}
+							if othersInTree {
+								others = append(others, wrapObjects(parseObjects(str.Value), k+":"+str.Path)...)
+							}
 						}
 					}
 				}
-				for _, cert := range certs {
-					myCertCount++
-					cache.Merge(
-						fmt.Sprintf("%s", sha1.Sum(cert.cert.Raw)),
-						CacheObject{
-							Subject:  cert.cert.Subject,
-							NotAfter: cert.cert.NotAfter,
-							Paths: []PathObject{
-								{
-									Location: path + ":" + cert.path,
-									Source:   b.Name,
-								},
-							},
-						},
-					)
+
+				secretCerts = append(secretCerts, certs...)
+				secretKeys = append(secretKeys, keys...)
+
+				for _, other := range others {
+					myOtherCount++
+					obj := other.obj
+					obj.Paths = []PathObject{{
+						Location: path + ":" + other.path,
+						Source:   b.Name,
+					}}
+					cache.Merge(fmt.Sprintf("%s", sha1.Sum(obj.Raw)), obj)
 				}
 			}
+
+			mismatches := keyMismatches(secretCerts, secretKeys)
+			for i, cert := range secretCerts {
+				myCertCount++
+				obj := newCacheObject(cert.cert, PathObject{
+					Location:    path + ":" + cert.path,
+					Source:      b.Name,
+					KeyMismatch: mismatches[i],
+				})
+				obj.Findings = b.Policy.Evaluate(cert.cert)
+				cache.Merge(fmt.Sprintf("%s", sha1.Sum(cert.cert.Raw)), obj)
+			}
 			mySuccessCount++
 		}
 		statLock.Lock()
 		successCount += mySuccessCount
 		certCount += myCertCount
+		otherCount += myOtherCount
 		statLock.Unlock()
 		barrier.Done()
 	}
@@ -141,52 +223,53 @@ func (b *Core) populateUsing(cache *Cache, paths storage.PathList) (*PopulateSta
 
 	barrier.Wait()
 
-	if len(errors) == 0 {
-		errors = append(errors, nil)
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].Path < warnings[j].Path })
+	sort.Slice(errors, func(i, j int) bool { return errors[i].Path < errors[j].Path })
+
+	var err error
+	if len(errors) > 0 {
+		err = fmt.Errorf("Could not fetch %d of %d paths. First error at `%s': %s",
+			len(errors), len(paths), errors[0].Path, errors[0].Err)
 	}
 
 	return &PopulateStats{
 		NumPaths:   len(paths),
 		NumSuccess: successCount,
 		NumCerts:   certCount,
-	}, errors[0]
+		NumOther:   otherCount,
+		Warnings:   warnings,
+		Errors:     errors,
+	}, err
 }
 
+//parseCert returns the certificates found in the given secret value. See
+// parseCertBytes for the supported encodings.
 func parseCert(c string) []*x509.Certificate {
-	certs := []*x509.Certificate{}
-	//Populate a potential chain of certs (or even just one) into this here slice
-	var pemBlock *pem.Block
-	var rest = []byte(c)
-	for {
-		pemBlock, rest = pem.Decode(rest)
-		//Skip over potential private keys in a cert chain.
-		if pemBlock == nil {
-			break
-		}
-
-		if pemBlock.Type != "CERTIFICATE" {
-			continue
-		}
-
-		cert, err := x509.ParseCertificate(pemBlock.Bytes)
-		if err != nil {
-			continue
-		}
+	return parseCertBytes([]byte(c))
+}
 
-		certs = append(certs, cert)
-		if len(rest) == 0 {
-			break
-		}
+func wrapCerts(certs []*x509.Certificate, path string) (ret []x509CertWrapper) {
+	for i, c := range certs {
+		ret = append(ret, x509CertWrapper{
+			path:  path,
+			cert:  c,
+			first: i == 0,
+		})
 	}
 
-	return certs
+	return ret
 }
 
-func wrapCerts(certs []*x509.Certificate, path string) (ret []x509CertWrapper) {
-	for _, c := range certs {
-		ret = append(ret, x509CertWrapper{
+type objectWrapper struct {
+	path string
+	obj  CacheObject
+}
+
+func wrapObjects(objs []CacheObject, path string) (ret []objectWrapper) {
+	for _, o := range objs {
+		ret = append(ret, objectWrapper{
 			path: path,
-			cert: c,
+			obj:  o,
 		})
 	}
 
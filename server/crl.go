@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+)
+
+var oidAuthorityKeyID = asn1.ObjectIdentifier{2, 5, 29, 35}
+
+//parseCRLObjects returns a CacheObject for each CRL in the given value
+func parseCRLObjects(value string) []CacheObject {
+	var ret []CacheObject
+	for _, crl := range parseCRLs(value) {
+		ret = append(ret, newCRLCacheObject(crl))
+	}
+
+	return ret
+}
+
+type parsedCRL struct {
+	crl *pkix.CertificateList
+	//raw is the DER encoding of the CRL
+	raw []byte
+}
+
+//parseCRLs finds certificate revocation lists in the given value, which may be
+// PEM (in X509 CRL blocks), DER, or either of those base64 encoded. CRLs with
+// no NextUpdate are skipped, as they have nothing to track.
+func parseCRLs(c string) []parsedCRL {
+	b := []byte(c)
+	crls := parsePEMCRLs(b)
+	if len(crls) == 0 {
+		crls = parseDERCRL(b)
+	}
+
+	if len(crls) == 0 {
+		if decoded, ok := decodeBase64(b); ok {
+			crls = parsePEMCRLs(decoded)
+			if len(crls) == 0 {
+				crls = parseDERCRL(decoded)
+			}
+		}
+	}
+
+	ret := []parsedCRL{}
+	for _, crl := range crls {
+		if !crl.crl.TBSCertList.NextUpdate.IsZero() {
+			ret = append(ret, crl)
+		}
+	}
+
+	return ret
+}
+
+func parsePEMCRLs(b []byte) []parsedCRL {
+	ret := []parsedCRL{}
+	var pemBlock *pem.Block
+	var rest = b
+	for {
+		pemBlock, rest = pem.Decode(rest)
+		if pemBlock == nil {
+			break
+		}
+
+		if pemBlock.Type != "X509 CRL" {
+			continue
+		}
+
+		crl, err := x509.ParseDERCRL(pemBlock.Bytes)
+		if err == nil {
+			ret = append(ret, parsedCRL{crl: crl, raw: pemBlock.Bytes})
+		}
+	}
+
+	return ret
+}
+
+func parseDERCRL(b []byte) []parsedCRL {
+	if len(b) == 0 || b[0] != 0x30 {
+		return nil
+	}
+
+	crl, err := x509.ParseDERCRL(b)
+	if err != nil {
+		return nil
+	}
+
+	return []parsedCRL{{crl: crl, raw: b}}
+}
+
+//newCRLCacheObject extracts the information we keep about a CRL into a
+// CacheObject found at the given paths. The CRL's issuer is used as its
+// subject, and its NextUpdate as its expiry.
+func newCRLCacheObject(parsed parsedCRL, paths ...PathObject) CacheObject {
+	crl, raw := parsed.crl, parsed.raw
+	var issuer pkix.Name
+	issuer.FillFromRDNSequence(&crl.TBSCertList.Issuer)
+	fingerprint := sha256.Sum256(raw)
+
+	ret := CacheObject{
+		Type:        CacheObjectTypeCRL,
+		Subject:     issuer,
+		NotAfter:    crl.TBSCertList.NextUpdate,
+		Paths:       paths,
+		Issuer:      issuer,
+		NotBefore:   crl.TBSCertList.ThisUpdate,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		Raw:         raw,
+	}
+
+	//Used to link the CRL to the CA that issued it
+	ret.RawIssuer = rawCRLIssuer(crl.TBSCertList.Raw)
+	for _, ext := range crl.TBSCertList.Extensions {
+		if !ext.Id.Equal(oidAuthorityKeyID) {
+			continue
+		}
+
+		var aki struct {
+			ID []byte `asn1:"optional,tag:0"`
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &aki); err == nil {
+			ret.AuthorityKeyID = aki.ID
+		}
+	}
+
+	return ret
+}
+
+//rawCRLIssuer returns the DER encoded issuer name from the given
+// TBSCertList. Re-encoding the parsed name isn't guaranteed to produce the
+// same bytes, which we need to match it against certificate subjects.
+func rawCRLIssuer(tbs []byte) []byte {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(tbs, &seq); err != nil {
+		return nil
+	}
+
+	var elems []asn1.RawValue
+	for rest := seq.Bytes; len(rest) > 0 && len(elems) < 3; {
+		var elem asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &elem)
+		if err != nil {
+			return nil
+		}
+
+		elems = append(elems, elem)
+	}
+
+	//The version is optional
+	if len(elems) > 0 && elems[0].Tag == asn1.TagInteger {
+		elems = elems[1:]
+	}
+
+	//Next is the signature algorithm, and then the issuer
+	if len(elems) < 2 {
+		return nil
+	}
+
+	return elems[1].FullBytes
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+var crlTestNextUpdate = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+//crlTestCA makes a CA cert that can sign CRLs
+func crlTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CRL Example CA", Organization: []string{"Doomsday"}},
+		NotBefore:             time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Could not create CA cert: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Could not parse CA cert: %s", err)
+	}
+
+	return cert, key
+}
+
+func crlTestCRL(t *testing.T, ca *x509.Certificate, key *ecdsa.PrivateKey, number int64) []byte {
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: time.Date(2029, 12, 1, 0, 0, 0, 0, time.UTC),
+		NextUpdate: crlTestNextUpdate,
+	}, ca, key)
+	if err != nil {
+		t.Fatalf("Could not create CRL: %s", err)
+	}
+
+	return der
+}
+
+//crlTestNoNextUpdate makes a CRL without a nextUpdate, which CreateRevocationList
+// won't do. The signature isn't valid, but nothing checks it.
+func crlTestNoNextUpdate(t *testing.T, ca *x509.Certificate) []byte {
+	var issuer pkix.RDNSequence
+	if _, err := asn1.Unmarshal(ca.RawSubject, &issuer); err != nil {
+		t.Fatalf("Could not parse CA subject: %s", err)
+	}
+
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}
+	der, err := asn1.Marshal(pkix.CertificateList{
+		TBSCertList: pkix.TBSCertificateList{
+			Version:    1,
+			Signature:  algorithm,
+			Issuer:     issuer,
+			ThisUpdate: time.Date(2029, 12, 1, 0, 0, 0, 0, time.UTC),
+		},
+		SignatureAlgorithm: algorithm,
+		SignatureValue:     asn1.BitString{Bytes: []byte{0}, BitLength: 8},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal CRL: %s", err)
+	}
+
+	return der
+}
+
+func TestParseCRLObjects(t *testing.T) {
+	ca, key := crlTestCA(t)
+	first, second := crlTestCRL(t, ca, key, 1), crlTestCRL(t, ca, key, 2)
+	pemCRL := func(der []byte) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	}
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+
+	tests := []struct {
+		name  string
+		value []byte
+		//want is the DER of each CRL expected
+		want [][]byte
+	}{
+		{name: "der", value: first, want: [][]byte{first}},
+		{name: "pem", value: pemCRL(first), want: [][]byte{first}},
+		{name: "several pem", value: append(pemCRL(first), pemCRL(second)...), want: [][]byte{first, second}},
+		{name: "pem after a cert", value: append(append([]byte{}, caPEM...), pemCRL(second)...), want: [][]byte{second}},
+		{name: "base64 der", value: []byte(base64.StdEncoding.EncodeToString(first)), want: [][]byte{first}},
+		{name: "base64 pem", value: []byte(base64.StdEncoding.EncodeToString(pemCRL(second))), want: [][]byte{second}},
+		{name: "no next update", value: crlTestNoNextUpdate(t, ca)},
+		{
+			name:  "no next update skipped",
+			value: append(pemCRL(crlTestNoNextUpdate(t, ca)), pemCRL(first)...),
+			want:  [][]byte{first},
+		},
+		{name: "cert", value: ca.Raw},
+		{name: "truncated der", value: first[:len(first)-10]},
+		{name: "not a crl", value: []byte("password123")},
+		{name: "empty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objs := parseCRLObjects(string(test.value))
+			if len(objs) != len(test.want) {
+				t.Fatalf("Got %d CRLs, want %d", len(objs), len(test.want))
+			}
+
+			for i, obj := range objs {
+				if !bytes.Equal(obj.Raw, test.want[i]) {
+					t.Errorf("CRL %d: got a different CRL than expected", i)
+				}
+
+				if obj.Type != CacheObjectTypeCRL {
+					t.Errorf("CRL %d: got type %q", i, obj.Type)
+				}
+
+				if obj.Subject.CommonName != "CRL Example CA" {
+					t.Errorf("CRL %d: got subject %q", i, obj.Subject.CommonName)
+				}
+
+				if !obj.NotAfter.Equal(crlTestNextUpdate) {
+					t.Errorf("CRL %d: got expiry %s, want %s", i, obj.NotAfter, crlTestNextUpdate)
+				}
+
+				if !bytes.Equal(obj.RawIssuer, ca.RawSubject) {
+					t.Errorf("CRL %d: raw issuer doesn't match the CA's raw subject", i)
+				}
+
+				if !bytes.Equal(obj.AuthorityKeyID, ca.SubjectKeyId) {
+					t.Errorf("CRL %d: got authority key ID %x, want %x", i, obj.AuthorityKeyID, ca.SubjectKeyId)
+				}
+			}
+		})
+	}
+}
+
+func TestRawCRLIssuer(t *testing.T) {
+	ca, key := crlTestCA(t)
+	v2, err := x509.ParseDERCRL(crlTestCRL(t, ca, key, 1))
+	if err != nil {
+		t.Fatalf("Could not parse CRL: %s", err)
+	}
+
+	//The version is zero based, so v1 is 0, which isn't encoded
+	v1 := v2.TBSCertList
+	v1.Version, v1.Raw, v1.Extensions = 0, nil, nil
+	v1TBS, err := asn1.Marshal(v1)
+	if err != nil {
+		t.Fatalf("Could not marshal CRL: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		tbs  []byte
+		want []byte
+	}{
+		{name: "v2", tbs: v2.TBSCertList.Raw, want: ca.RawSubject},
+		{name: "v1", tbs: v1TBS, want: ca.RawSubject},
+		{name: "only a version", tbs: []byte{0x30, 0x03, 0x02, 0x01, 0x01}},
+		{name: "not a sequence", tbs: []byte{0x04, 0x01, 0x00}},
+		{name: "empty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := rawCRLIssuer(test.tbs); !bytes.Equal(got, test.want) {
+				t.Errorf("Got issuer %x, want %x", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doomsday-project/doomsday/client/doomsday"
+)
+
+//DefaultHistorySize is how many events are kept in the history if the config
+// doesn't say otherwise
+const DefaultHistorySize = 10000
+
+const (
+	//HistoryEventAdded means a cert appeared at a path that didn't hold it before
+	HistoryEventAdded = "added"
+	//HistoryEventRemoved means a cert disappeared from a path, and nothing took
+	// its place, but the cert is still at other paths in the same backend
+	HistoryEventRemoved = "removed"
+	//HistoryEventVanished means a cert disappeared from a path, nothing took
+	// its place, and the cert isn't anywhere else in the backend either
+	HistoryEventVanished = "vanished"
+	//HistoryEventReplaced means the cert at a path was swapped for another
+	HistoryEventReplaced = "replaced"
+	//HistoryEventRotated means the cert at a path was swapped for another with
+	// the same subject which expires later
+	HistoryEventRotated = "rotated"
+)
+
+//HistoryEvent is a change to what was found at a path during a refresh
+type HistoryEvent struct {
+	At       time.Time `json:"at"`
+	Kind     string    `json:"kind"`
+	Backend  string    `json:"backend"`
+	Location string    `json:"location"`
+	//Old is the cert that was at the path before, if any
+	Old *HistoryCert `json:"old,omitempty"`
+	//New is the cert that is at the path now, if any
+	New *HistoryCert `json:"new,omitempty"`
+}
+
+//HistoryCert identifies the cert involved in an event. This is kept
+// separately from the cache, as the cert may no longer be in it.
+type HistoryCert struct {
+	Name        string    `json:"name"`
+	CommonName  string    `json:"common_name"`
+	Subject     string    `json:"subject"`
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"not_after"`
+}
+
+//History keeps the most recent events, up to a maximum number
+type History struct {
+	lock   sync.RWMutex
+	events []HistoryEvent
+	max    int
+}
+
+func NewHistory(max int) *History {
+	return &History{max: max}
+}
+
+//Add records the given events, dropping the oldest events if there are now
+// too many
+func (h *History) Add(events ...HistoryEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.events = append(h.events, events...)
+	if overflow := len(h.events) - h.max; overflow > 0 {
+		h.events = append([]HistoryEvent{}, h.events[overflow:]...)
+	}
+}
+
+//Events returns all of the recorded events, oldest first
+func (h *History) Events() []HistoryEvent {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return append([]HistoryEvent{}, h.events...)
+}
+
+//diffEvents turns a diff from o to n computed by calcDiff into events. Where a
+// cert was removed from a path and another added to the same path, that is
+// recorded as the one replacing the other, or as a rotation if the new cert
+// has the same subject and expires later. If more than one cert changed at a
+// path (such as the leaf and intermediate of a chain), certs with the same
+// subject are paired up first.
+func diffEvents(o, n *Cache, toDelete map[string][]PathObject, toAdd map[string]CacheObject, at time.Time) []HistoryEvent {
+	type pathKey struct {
+		backend  string
+		location string
+	}
+
+	type change struct {
+		key  string
+		cert *HistoryCert
+		//gone is true if a removed cert is no longer anywhere in the backend
+		gone bool
+	}
+
+	removed := map[pathKey][]change{}
+	for key, paths := range toDelete {
+		obj, found := o.Read(key)
+		if !found {
+			continue
+		}
+
+		_, stillCached := n.Read(key)
+		for _, path := range paths {
+			k := pathKey{backend: path.Source, location: path.Location}
+			removed[k] = append(removed[k], change{key: key, cert: newHistoryCert(obj), gone: !stillCached})
+		}
+	}
+
+	added := map[pathKey][]change{}
+	for key, obj := range toAdd {
+		for _, path := range obj.Paths {
+			k := pathKey{backend: path.Source, location: path.Location}
+			added[k] = append(added[k], change{key: key, cert: newHistoryCert(obj)})
+		}
+	}
+
+	ret := []HistoryEvent{}
+	newEvent := func(k pathKey, kind string, old, new *HistoryCert) HistoryEvent {
+		return HistoryEvent{At: at, Kind: kind, Backend: k.backend, Location: k.location, Old: old, New: new}
+	}
+
+	for k, olds := range removed {
+		news := added[k]
+		delete(added, k)
+		sortChanges := func(c []change) {
+			sort.Slice(c, func(i, j int) bool { return c[i].key < c[j].key })
+		}
+		sortChanges(olds)
+		sortChanges(news)
+
+		//Pair up certs with the same subject, and then whatever is left over
+		pairs := [][2]*change{}
+		usedNew := make([]bool, len(news))
+		var unpairedOld []*change
+		for i := range olds {
+			paired := false
+			for j := range news {
+				if !usedNew[j] && olds[i].cert.Subject == news[j].cert.Subject {
+					pairs = append(pairs, [2]*change{&olds[i], &news[j]})
+					usedNew[j], paired = true, true
+					break
+				}
+			}
+
+			if !paired {
+				unpairedOld = append(unpairedOld, &olds[i])
+			}
+		}
+
+		for j := range news {
+			if usedNew[j] {
+				continue
+			}
+
+			if len(unpairedOld) > 0 {
+				pairs = append(pairs, [2]*change{unpairedOld[0], &news[j]})
+				unpairedOld = unpairedOld[1:]
+				continue
+			}
+
+			ret = append(ret, newEvent(k, HistoryEventAdded, nil, news[j].cert))
+		}
+
+		for _, old := range unpairedOld {
+			kind := HistoryEventRemoved
+			if old.gone {
+				kind = HistoryEventVanished
+			}
+
+			ret = append(ret, newEvent(k, kind, old.cert, nil))
+		}
+
+		for _, pair := range pairs {
+			//Only the path's attributes changed, such as whether it has a
+			// mismatched key
+			if pair[0].key == pair[1].key {
+				continue
+			}
+
+			old, new := pair[0].cert, pair[1].cert
+			kind := HistoryEventReplaced
+			if old.Subject == new.Subject && new.NotAfter.After(old.NotAfter) {
+				kind = HistoryEventRotated
+			}
+
+			ret = append(ret, newEvent(k, kind, old, new))
+		}
+	}
+
+	for k, news := range added {
+		for _, n := range news {
+			ret = append(ret, newEvent(k, HistoryEventAdded, nil, n.cert))
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Backend != ret[j].Backend {
+			return ret[i].Backend < ret[j].Backend
+		}
+
+		return ret[i].Location < ret[j].Location
+	})
+
+	return ret
+}
+
+//matches returns true if the event is at a location starting with the search
+// string, or involves a cert whose name or common name is the search string
+func (e HistoryEvent) matches(search string) bool {
+	if strings.HasPrefix(e.Location, search) {
+		return true
+	}
+
+	for _, cert := range []*HistoryCert{e.Old, e.New} {
+		if cert != nil && (strings.EqualFold(cert.Name, search) || strings.EqualFold(cert.CommonName, search)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//rotationsByNew returns the API representation of the rotated events, newest
+// first, keyed by the fingerprint of the cert which was rotated in
+func rotationsByNew(events []HistoryEvent) map[string][]doomsday.HistoryEvent {
+	ret := map[string][]doomsday.HistoryEvent{}
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Kind != HistoryEventRotated {
+			continue
+		}
+
+		fingerprint := events[i].New.Fingerprint
+		ret[fingerprint] = append(ret[fingerprint], newHistoryEventItem(events[i]))
+	}
+
+	return ret
+}
+
+//newHistoryEventItem converts a HistoryEvent into its API representation
+func newHistoryEventItem(e HistoryEvent) doomsday.HistoryEvent {
+	convert := func(cert *HistoryCert) *doomsday.HistoryCert {
+		if cert == nil {
+			return nil
+		}
+
+		return &doomsday.HistoryCert{
+			Name:        cert.Name,
+			CommonName:  cert.CommonName,
+			Subject:     cert.Subject,
+			Fingerprint: cert.Fingerprint,
+			NotAfter:    cert.NotAfter.Unix(),
+		}
+	}
+
+	return doomsday.HistoryEvent{
+		At:       e.At.Unix(),
+		Kind:     e.Kind,
+		Backend:  e.Backend,
+		Location: e.Location,
+		Old:      convert(e.Old),
+		New:      convert(e.New),
+	}
+}
+
+func newHistoryCert(obj CacheObject) *HistoryCert {
+	item := newCacheItem(obj)
+	return &HistoryCert{
+		Name:        item.Name(),
+		CommonName:  item.CommonName,
+		Subject:     item.Subject,
+		Fingerprint: obj.Fingerprint,
+		NotAfter:    obj.NotAfter,
+	}
+}
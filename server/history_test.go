@@ -0,0 +1,342 @@
+package server
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+//historyTestCert makes a cert at the given "backend:location" paths. A
+// location ending in "!" has a mismatched key.
+func historyTestCert(fingerprint, commonName string, year int, paths ...string) CacheObject {
+	obj := CacheObject{
+		Fingerprint: fingerprint,
+		Subject:     pkix.Name{CommonName: commonName},
+		NotAfter:    time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, path := range paths {
+		parts := strings.SplitN(path, ":", 2)
+		obj.Paths = append(obj.Paths, PathObject{
+			Source:      parts[0],
+			Location:    strings.TrimSuffix(parts[1], "!"),
+			KeyMismatch: strings.HasSuffix(parts[1], "!"),
+		})
+	}
+
+	return obj
+}
+
+func historyTestCache(objs ...CacheObject) *Cache {
+	ret := NewCache()
+	for _, obj := range objs {
+		ret.Store(obj.Fingerprint, obj)
+	}
+
+	return ret
+}
+
+//historyEventSummary describes an event as "kind backend:location old->new",
+// using the fingerprints of the certs
+func historyEventSummary(e HistoryEvent) string {
+	fingerprint := func(cert *HistoryCert) string {
+		if cert == nil {
+			return ""
+		}
+
+		return cert.Fingerprint
+	}
+
+	return fmt.Sprintf("%s %s:%s %s->%s", e.Kind, e.Backend, e.Location, fingerprint(e.Old), fingerprint(e.New))
+}
+
+func TestPathListDiff(t *testing.T) {
+	paths := func(ps ...string) []PathObject {
+		return historyTestCert("", "", 2030, ps...).Paths
+	}
+
+	tests := []struct {
+		name       string
+		old, new   []PathObject
+		wantDelete []PathObject
+		wantAdd    []PathObject
+	}{
+		{name: "same", old: paths("v:/a", "v:/b"), new: paths("v:/a", "v:/b")},
+		{name: "added", old: paths("v:/b"), new: paths("v:/a", "v:/b", "v:/c"), wantAdd: paths("v:/a", "v:/c")},
+		{name: "deleted", old: paths("v:/a", "v:/b", "v:/c"), new: paths("v:/b"), wantDelete: paths("v:/a", "v:/c")},
+		{
+			name:       "both",
+			old:        paths("c:/a", "v:/a", "v:/c"),
+			new:        paths("v:/a", "v:/b", "v:/d"),
+			wantDelete: paths("c:/a", "v:/c"),
+			wantAdd:    paths("v:/b", "v:/d"),
+		},
+		{name: "from nothing", new: paths("v:/a"), wantAdd: paths("v:/a")},
+		{name: "to nothing", old: paths("v:/a"), wantDelete: paths("v:/a")},
+		{name: "key mismatch changed", old: paths("v:/a"), new: paths("v:/a!"), wantDelete: paths("v:/a"), wantAdd: paths("v:/a!")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			toDelete, toAdd := pathListDiff(test.old, test.new)
+			if !reflect.DeepEqual(toDelete, test.wantDelete) {
+				t.Errorf("Got paths to delete %v, want %v", toDelete, test.wantDelete)
+			}
+
+			if !reflect.DeepEqual(toAdd, test.wantAdd) {
+				t.Errorf("Got paths to add %v, want %v", toAdd, test.wantAdd)
+			}
+		})
+	}
+}
+
+func TestCacheApplyDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new []CacheObject
+	}{
+		{
+			name: "path added and removed",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x", "v:/y")},
+			new:  []CacheObject{historyTestCert("a", "web", 2030, "v:/y", "v:/z")},
+		},
+		{
+			name: "cert replaced",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+			new:  []CacheObject{historyTestCert("b", "web", 2031, "v:/x")},
+		},
+		{
+			name: "cert added alongside",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+			new:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x"), historyTestCert("b", "api", 2030, "c:/y")},
+		},
+		{
+			name: "key mismatch changed",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x", "v:/y")},
+			new:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x!", "v:/y")},
+		},
+		{
+			name: "everything removed",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x"), historyTestCert("b", "api", 2030, "v:/y")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := historyTestCache(test.old...)
+			old, new := historyTestCache(test.old...), historyTestCache(test.new...)
+			generation := cache.Generation()
+			cache.ApplyDiff(old, new)
+
+			if got, want := cache.Map(), new.Map(); !reflect.DeepEqual(got, want) {
+				t.Errorf("Got cache %v, want %v", got, want)
+			}
+
+			if cache.Generation() == generation {
+				t.Errorf("ApplyDiff didn't change the generation")
+			}
+		})
+	}
+}
+
+func TestCacheMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []CacheObject
+		merged   CacheObject
+		want     []PathObject
+	}{
+		{
+			name:   "new key",
+			merged: historyTestCert("a", "web", 2030, "v:/x"),
+			want:   historyTestCert("a", "web", 2030, "v:/x").Paths,
+		},
+		{
+			name:     "already there",
+			existing: []CacheObject{historyTestCert("a", "web", 2030, "v:/a", "v:/c")},
+			merged:   historyTestCert("a", "web", 2030, "v:/c"),
+			want:     historyTestCert("a", "web", 2030, "v:/a", "v:/c").Paths,
+		},
+		{
+			name:     "interleaved",
+			existing: []CacheObject{historyTestCert("a", "web", 2030, "v:/a", "v:/c")},
+			merged:   historyTestCert("a", "web", 2030, "c:/z", "v:/b", "v:/c", "v:/d"),
+			want:     historyTestCert("a", "web", 2030, "c:/z", "v:/a", "v:/b", "v:/c", "v:/d").Paths,
+		},
+		{
+			name:     "key mismatch",
+			existing: []CacheObject{historyTestCert("a", "web", 2030, "v:/a")},
+			merged:   historyTestCert("a", "web", 2030, "v:/a!"),
+			want:     historyTestCert("a", "web", 2030, "v:/a", "v:/a!").Paths,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := historyTestCache(test.existing...)
+			cache.Merge(test.merged.Fingerprint, test.merged)
+			got, _ := cache.Read(test.merged.Fingerprint)
+			if !reflect.DeepEqual(got.Paths, test.want) {
+				t.Errorf("Got paths %v, want %v", got.Paths, test.want)
+			}
+		})
+	}
+}
+
+func TestDiffEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new []CacheObject
+		want     []string
+	}{
+		{
+			name: "added",
+			new:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+			want: []string{"added v:/x ->a"},
+		},
+		{
+			name: "removed but still elsewhere",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x", "v:/y")},
+			new:  []CacheObject{historyTestCert("a", "web", 2030, "v:/y")},
+			want: []string{"removed v:/x a->"},
+		},
+		{
+			name: "vanished",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+			want: []string{"vanished v:/x a->"},
+		},
+		{
+			name: "rotated",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+			new:  []CacheObject{historyTestCert("b", "web", 2031, "v:/x")},
+			want: []string{"rotated v:/x a->b"},
+		},
+		{
+			name: "replaced with another subject",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+			new:  []CacheObject{historyTestCert("b", "api", 2031, "v:/x")},
+			want: []string{"replaced v:/x a->b"},
+		},
+		{
+			name: "replaced with an earlier expiry",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+			new:  []CacheObject{historyTestCert("b", "web", 2029, "v:/x")},
+			want: []string{"replaced v:/x a->b"},
+		},
+		{
+			name: "chain rotated",
+			old: []CacheObject{
+				historyTestCert("leaf1", "leaf", 2030, "v:/x"),
+				historyTestCert("int1", "intermediate", 2032, "v:/x"),
+			},
+			new: []CacheObject{
+				historyTestCert("int2", "intermediate", 2035, "v:/x"),
+				historyTestCert("leaf2", "leaf", 2031, "v:/x"),
+			},
+			want: []string{"rotated v:/x int1->int2", "rotated v:/x leaf1->leaf2"},
+		},
+		{
+			name: "same subject paired first",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+			new:  []CacheObject{historyTestCert("b", "api", 2031, "v:/x"), historyTestCert("c", "web", 2031, "v:/x")},
+			want: []string{"added v:/x ->b", "rotated v:/x a->c"},
+		},
+		{
+			name: "key mismatch changed",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+			new:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x!")},
+		},
+		{
+			name: "moved to another backend",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+			new:  []CacheObject{historyTestCert("a", "web", 2030, "c:/x")},
+			want: []string{"added c:/x ->a", "removed v:/x a->"},
+		},
+		{
+			name: "nothing changed",
+			old:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+			new:  []CacheObject{historyTestCert("a", "web", 2030, "v:/x")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old, new := historyTestCache(test.old...), historyTestCache(test.new...)
+			toDelete, toAdd := calcDiff(old, new)
+			at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			got := []string{}
+			for _, event := range diffEvents(old, new, toDelete, toAdd, at) {
+				if !event.At.Equal(at) {
+					t.Errorf("Got event at %s, want %s", event.At, at)
+				}
+
+				got = append(got, historyEventSummary(event))
+			}
+
+			//Events at the same location come in no particular order
+			sort.Strings(got)
+			want := append([]string{}, test.want...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Got events %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRotationsByNew(t *testing.T) {
+	event := func(kind, old, new string, year int) HistoryEvent {
+		return HistoryEvent{
+			At:       time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC),
+			Kind:     kind,
+			Backend:  "v",
+			Location: "/x",
+			Old:      &HistoryCert{Fingerprint: old},
+			New:      &HistoryCert{Fingerprint: new},
+		}
+	}
+
+	rotations := rotationsByNew([]HistoryEvent{
+		event(HistoryEventRotated, "a", "b", 2020),
+		event(HistoryEventReplaced, "c", "d", 2021),
+		event(HistoryEventRotated, "x", "b", 2022),
+		event(HistoryEventRotated, "e", "f", 2023),
+		{Kind: HistoryEventAdded, New: &HistoryCert{Fingerprint: "g"}},
+	})
+
+	got := map[string][]string{}
+	for fingerprint, events := range rotations {
+		for _, e := range events {
+			got[fingerprint] = append(got[fingerprint], fmt.Sprintf("%s@%d", e.Old.Fingerprint, time.Unix(e.At, 0).UTC().Year()))
+		}
+	}
+
+	want := map[string][]string{
+		"b": {"x@2022", "a@2020"},
+		"f": {"e@2023"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got rotations %v, want %v", got, want)
+	}
+}
+
+func TestHistoryAdd(t *testing.T) {
+	history := NewHistory(3)
+	history.Add(HistoryEvent{Location: "1"}, HistoryEvent{Location: "2"})
+	history.Add(HistoryEvent{Location: "3"}, HistoryEvent{Location: "4"}, HistoryEvent{Location: "5"})
+
+	got := []string{}
+	for _, event := range history.Events() {
+		got = append(got, event.Location)
+	}
+
+	if want := []string{"3", "4", "5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got events %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+)
+
+//parsePrivateKeys returns the public halves of the unencrypted PEM private
+// keys found in the given value
+func parsePrivateKeys(c string) []crypto.PublicKey {
+	ret := []crypto.PublicKey{}
+	var pemBlock *pem.Block
+	var rest = []byte(c)
+	for {
+		pemBlock, rest = pem.Decode(rest)
+		if pemBlock == nil {
+			break
+		}
+
+		var key interface{}
+		var err error
+		switch pemBlock.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(pemBlock.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(pemBlock.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(pemBlock.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if signer, isSigner := key.(crypto.Signer); isSigner {
+			ret = append(ret, signer.Public())
+		}
+	}
+
+	return ret
+}
+
+//keyMismatches checks the certs found in a secret against the private keys
+// found in the same secret. If any key matches none of the certs, then the
+// certs which a key would be expected for are returned as mismatched. Those
+// are the first cert found at each key of the secret (as opposed to the rest
+// of a chain), preferring non-CA certs if there are any. The returned slice
+// is aligned with certs.
+func keyMismatches(certs []x509CertWrapper, keys []crypto.PublicKey) []bool {
+	ret := make([]bool, len(certs))
+	if len(keys) == 0 {
+		return ret
+	}
+
+	matched := make([]bool, len(certs))
+	anyKeyUnmatched := false
+	for _, key := range keys {
+		keyMatched := false
+		for i, cert := range certs {
+			if publicKeysEqual(key, cert.cert.PublicKey) {
+				matched[i] = true
+				keyMatched = true
+			}
+		}
+
+		if !keyMatched {
+			anyKeyUnmatched = true
+		}
+	}
+
+	if !anyKeyUnmatched {
+		return ret
+	}
+
+	haveLeaf := false
+	for _, cert := range certs {
+		if cert.first && !cert.cert.IsCA {
+			haveLeaf = true
+			break
+		}
+	}
+
+	for i, cert := range certs {
+		if cert.first && !matched[i] && (!haveLeaf || !cert.cert.IsCA) {
+			ret[i] = true
+		}
+	}
+
+	return ret
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	comparable, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && comparable.Equal(b)
+}
@@ -0,0 +1,663 @@
+package server
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/pkcs12"
+)
+
+//KeystoreConfig configures how PKCS#12 and JKS keystores found in secrets are
+// opened.
+type KeystoreConfig struct {
+	//Passwords are tried, in order, against every keystore found
+	Passwords []string `yaml:"passwords"`
+	//PasswordKeys are keys which, if present in the same secret as a keystore,
+	// hold a password for it. These are tried before Passwords.
+	PasswordKeys []string `yaml:"password_keys"`
+}
+
+var defaultKeystorePasswordKeys = []string{"keystore_password"}
+
+//candidatePasswords returns the passwords to try against keystores in the
+// given secret. The empty password is always tried last.
+func (k KeystoreConfig) candidatePasswords(secret map[string]string) []string {
+	passwordKeys := k.PasswordKeys
+	if passwordKeys == nil {
+		passwordKeys = defaultKeystorePasswordKeys
+	}
+
+	ret := []string{}
+	for _, key := range passwordKeys {
+		if password, found := secret[key]; found {
+			ret = append(ret, password)
+		}
+	}
+
+	return append(append(ret, k.Passwords...), "")
+}
+
+const jksMagic = 0xFEEDFEED
+
+//maxPBKDF2Iterations is the most key derivation iterations that a PKCS#12 file
+// may ask for, either for its MAC or its encryption. Files made by OpenSSL and Java use a few thousand, and the
+// derivation is repeated for every password that is tried, so a file asking
+// for more can't be allowed to hold up the refresh.
+const maxPBKDF2Iterations = 2000000
+
+//parseKeystore returns the certificates in the given PKCS#12 or JKS keystore,
+// which may be base64 encoded. If the value doesn't look like a keystore, no
+// certs and no error are returned. An error is returned if it is a keystore
+// but it could not be opened.
+func parseKeystore(c string, passwords []string) ([]*x509.Certificate, error) {
+	data := []byte(c)
+	if !looksLikeKeystore(data) {
+		var ok bool
+		data, ok = decodeBase64(data)
+		if !ok || !looksLikeKeystore(data) {
+			return nil, nil
+		}
+	}
+
+	if binary.BigEndian.Uint32(data) == jksMagic {
+		return parseJKS(data)
+	}
+
+	return parsePKCS12(data, passwords)
+}
+
+func looksLikeKeystore(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+
+	if binary.BigEndian.Uint32(data) == jksMagic {
+		return true
+	}
+
+	var pfx pfxPDU
+	_, err := asn1.Unmarshal(data, &pfx)
+	return err == nil && pfx.Version == 3
+}
+
+//parseJKS returns the certificates from the entries of a Java keystore. Unlike
+// private keys, certificates in a JKS are not encrypted, so no password is
+// needed to read them.
+func parseJKS(data []byte) ([]*x509.Certificate, error) {
+	r := &jksReader{data: data}
+	r.uint32() //magic
+	version := r.uint32()
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("Unsupported JKS version %d", version)
+	}
+
+	readCert := func() []byte {
+		if version == 2 {
+			r.utf() //certificate type
+		}
+
+		return r.bytes(int(r.uint32()))
+	}
+
+	var ret []*x509.Certificate
+	numEntries := r.uint32()
+	for i := uint32(0); i < numEntries && r.err == nil; i++ {
+		var rawCerts [][]byte
+		tag := r.uint32()
+		r.utf()    //alias
+		r.uint64() //creation time
+		if r.err != nil {
+			break
+		}
+
+		switch tag {
+		case 1: //private key entry
+			r.bytes(int(r.uint32())) //the encrypted key
+			numCerts := r.uint32()
+			for j := uint32(0); j < numCerts && r.err == nil; j++ {
+				rawCerts = append(rawCerts, readCert())
+			}
+		case 2: //trusted certificate entry
+			rawCerts = append(rawCerts, readCert())
+		default:
+			return nil, fmt.Errorf("Unknown JKS entry type %d", tag)
+		}
+
+		for _, raw := range rawCerts {
+			if r.err != nil {
+				break
+			}
+
+			cert, err := x509.ParseCertificate(raw)
+			if err == nil {
+				ret = append(ret, cert)
+			}
+		}
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("Could not read JKS: %s", r.err)
+	}
+
+	return ret, nil
+}
+
+type jksReader struct {
+	data []byte
+	err  error
+}
+
+func (r *jksReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if n < 0 || n > len(r.data) {
+		r.err = fmt.Errorf("unexpected end of data")
+		return nil
+	}
+
+	ret := r.data[:n]
+	r.data = r.data[n:]
+	return ret
+}
+
+func (r *jksReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *jksReader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+//utf reads a Java modified UTF-8 string with a two byte length prefix
+func (r *jksReader) utf() string {
+	b := r.bytes(2)
+	if b == nil {
+		return ""
+	}
+
+	return string(r.bytes(int(binary.BigEndian.Uint16(b))))
+}
+
+//parsePKCS12 tries each of the passwords against the given PKCS#12 data and
+// returns the certificates found with the first that works. Any error other
+// than a wrong password would be the same for every password, so is returned
+// straight away.
+func parsePKCS12(data []byte, passwords []string) ([]*x509.Certificate, error) {
+	for _, password := range passwords {
+		certs, err := parsePKCS12WithPassword(data, password)
+		if err == nil {
+			return certs, nil
+		}
+
+		if err != pkcs12.ErrIncorrectPassword {
+			return nil, fmt.Errorf("Could not open PKCS#12 keystore: %s", err)
+		}
+	}
+
+	return nil, fmt.Errorf("None of the %d candidate passwords are correct for the PKCS#12 keystore", len(passwords))
+}
+
+//parsePKCS12WithPassword returns the certificates in the PKCS#12 data, or
+// pkcs12.ErrIncorrectPassword if the password is wrong
+func parsePKCS12WithPassword(data []byte, password string) ([]*x509.Certificate, error) {
+	//The MAC is checked first so that a wrong password is never used to
+	// decrypt, and so that the iteration count is checked before the PKCS#12
+	// package derives a key with it
+	err := verifyPKCS12MAC(data, password)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		//The PKCS#12 package only handles the legacy encryption schemes, so fall
+		// back to reading the certs ourselves for anything newer
+		if _, notImplemented := err.(pkcs12.NotImplementedError); notImplemented {
+			return parsePBES2PKCS12(data, password)
+		}
+
+		return nil, err
+	}
+
+	var ret []*x509.Certificate
+	for _, block := range blocks {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			ret = append(ret, cert)
+		}
+	}
+
+	return ret, nil
+}
+
+var (
+	oidData              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedData     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidCertBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidPBES2             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256    = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384    = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512    = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC        = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512            = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	errUnsupportedPKCS12 = fmt.Errorf("Unsupported PKCS#12 encryption")
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe pkcs7ContentInfo
+	MacData  pkcs12MacData `asn1:"optional"`
+}
+
+type pkcs12MacData struct {
+	Mac struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Digest    []byte
+	}
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type pkcs12EncryptedData struct {
+	Version              int
+	EncryptedContentInfo struct {
+		ContentType                asn1.ObjectIdentifier
+		ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+		EncryptedContent           asn1.RawValue `asn1:"tag:0,optional"`
+	}
+}
+
+type pkcs12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue `asn1:"tag:0,explicit"`
+	Attributes asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs12CertBag struct {
+	ID    asn1.ObjectIdentifier
+	Value []byte `asn1:"tag:0,explicit"`
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+//verifyPKCS12MAC checks the password against the MAC of the PKCS#12 data,
+// which OpenSSL 3 and newer versions of Java make with SHA-256 where the
+// PKCS#12 package only knows SHA-1. Data with no MAC is let through, in which
+// case a wrong password can only be caught by decryption failing.
+func verifyPKCS12MAC(data []byte, password string) error {
+	var pfx pfxPDU
+	_, err := asn1.Unmarshal(data, &pfx)
+	if err != nil {
+		return err
+	}
+
+	macData := pfx.MacData
+	if len(macData.Mac.Algorithm.Algorithm) == 0 {
+		return nil
+	}
+
+	var newHash func() hash.Hash
+	switch algorithm := macData.Mac.Algorithm.Algorithm; {
+	case algorithm.Equal(oidSHA1):
+		newHash = sha1.New
+	case algorithm.Equal(oidSHA256):
+		newHash = sha256.New
+	case algorithm.Equal(oidSHA384):
+		newHash = sha512.New384
+	case algorithm.Equal(oidSHA512):
+		newHash = sha512.New
+	default:
+		return fmt.Errorf("Unsupported PKCS#12 MAC algorithm %s", algorithm)
+	}
+
+	if macData.Iterations <= 0 || macData.Iterations > maxPBKDF2Iterations {
+		return fmt.Errorf("PKCS#12 MAC iteration count %d is outside of the allowed range (1-%d)",
+			macData.Iterations, maxPBKDF2Iterations)
+	}
+
+	if !pfx.AuthSafe.ContentType.Equal(oidData) {
+		return errUnsupportedPKCS12
+	}
+
+	authSafeBytes, err := octetStringContents(pfx.AuthSafe.Content)
+	if err != nil {
+		return err
+	}
+
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return pkcs12.ErrIncorrectPassword
+	}
+
+	candidates := [][]byte{encodedPassword}
+	if password == "" {
+		//Some implementations use no bytes at all for the empty password,
+		// rather than just the terminator
+		candidates = append(candidates, []byte{})
+	}
+
+	for _, candidate := range candidates {
+		key := pkcs12KDF(newHash, 3, candidate, macData.MacSalt, macData.Iterations, newHash().Size())
+		mac := hmac.New(newHash, key)
+		mac.Write(authSafeBytes)
+		if hmac.Equal(mac.Sum(nil), macData.Mac.Digest) {
+			return nil
+		}
+	}
+
+	return pkcs12.ErrIncorrectPassword
+}
+
+//bmpString returns the password encoded as PKCS#12 expects for key
+// derivation, which is big-endian UTF-16 with a two byte terminator
+func bmpString(s string) ([]byte, error) {
+	ret := make([]byte, 0, 2*len(s)+2)
+	for _, r := range s {
+		if r > 0xFFFF {
+			return nil, fmt.Errorf("Password has characters which can't be used in PKCS#12")
+		}
+
+		ret = append(ret, byte(r>>8), byte(r))
+	}
+
+	return append(ret, 0, 0), nil
+}
+
+//pkcs12KDF derives a key of the given size as described in RFC 7292 appendix
+// B.2. The id is 1 for encryption keys, 2 for IVs and 3 for MAC keys.
+func pkcs12KDF(newHash func() hash.Hash, id byte, password, salt []byte, iterations, size int) []byte {
+	h := newHash()
+	v := h.BlockSize()
+
+	//fill repeats b to make it a whole number of blocks long
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+
+		ret := make([]byte, v*((len(b)+v-1)/v))
+		for i := range ret {
+			ret[i] = b[i%len(b)]
+		}
+		return ret
+	}
+
+	diversifier := bytes.Repeat([]byte{id}, v)
+	input := append(fill(salt), fill(password)...)
+
+	var ret []byte
+	for {
+		h.Reset()
+		h.Write(diversifier)
+		h.Write(input)
+		a := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+
+		ret = append(ret, a...)
+		if len(ret) >= size {
+			return ret[:size]
+		}
+
+		//Add b+1 to each block of the input, as big-endian numbers
+		b := fill(a)
+		for j := 0; j < len(input); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(input[j+k]) + int(b[k]) + carry
+				input[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+}
+
+//parsePBES2PKCS12 reads the certificates out of PKCS#12 data where the
+// certificates are either unencrypted or encrypted with PBES2, as is the
+// default in OpenSSL 3 and newer versions of Java. The password must already
+// have been checked against the MAC.
+func parsePBES2PKCS12(data []byte, password string) ([]*x509.Certificate, error) {
+	var pfx pfxPDU
+	_, err := asn1.Unmarshal(data, &pfx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !pfx.AuthSafe.ContentType.Equal(oidData) {
+		return nil, errUnsupportedPKCS12
+	}
+
+	authSafeBytes, err := octetStringContents(pfx.AuthSafe.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	var authSafe []pkcs7ContentInfo
+	_, err = asn1.Unmarshal(authSafeBytes, &authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*x509.Certificate
+	for _, info := range authSafe {
+		var safeContents []byte
+		switch {
+		case info.ContentType.Equal(oidData):
+			safeContents, err = octetStringContents(info.Content)
+		case info.ContentType.Equal(oidEncryptedData):
+			safeContents, err = decryptPKCS12Data(info.Content.Bytes, password)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var bags []pkcs12SafeBag
+		_, err = asn1.Unmarshal(safeContents, &bags)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, bag := range bags {
+			if !bag.ID.Equal(oidCertBag) {
+				continue
+			}
+
+			var certBag pkcs12CertBag
+			_, err = asn1.Unmarshal(bag.Value.Bytes, &certBag)
+			if err != nil || !certBag.ID.Equal(oidX509Certificate) {
+				continue
+			}
+
+			cert, err := x509.ParseCertificate(certBag.Value)
+			if err == nil {
+				ret = append(ret, cert)
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+func decryptPKCS12Data(data []byte, password string) ([]byte, error) {
+	var encrypted pkcs12EncryptedData
+	_, err := asn1.Unmarshal(data, &encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm := encrypted.EncryptedContentInfo.ContentEncryptionAlgorithm
+	if !algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, errUnsupportedPKCS12
+	}
+
+	var params pbes2Params
+	_, err = asn1.Unmarshal(algorithm.Parameters.FullBytes, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, errUnsupportedPKCS12
+	}
+
+	var kdfParams pbkdf2Params
+	_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams)
+	if err != nil {
+		return nil, err
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdfParams.PRF.Algorithm) == 0, kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, errUnsupportedPKCS12
+	}
+
+	var keyLen int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, errUnsupportedPKCS12
+	}
+
+	var iv []byte
+	_, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv)
+	if err != nil {
+		return nil, err
+	}
+
+	if kdfParams.IterationCount <= 0 || kdfParams.IterationCount > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("PKCS#12 key derivation iteration count %d is outside of the allowed range (1-%d)",
+			kdfParams.IterationCount, maxPBKDF2Iterations)
+	}
+
+	key := pbkdf2.Key([]byte(password), kdfParams.Salt, kdfParams.IterationCount, keyLen, prf)
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := octetStringContents(encrypted.EncryptedContentInfo.EncryptedContent)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) != block.BlockSize() || len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, pkcs12.ErrDecryption
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padLen := int(plaintext[len(plaintext)-1])
+	if padLen == 0 || padLen > block.BlockSize() ||
+		!bytes.Equal(plaintext[len(plaintext)-padLen:], bytes.Repeat([]byte{byte(padLen)}, padLen)) {
+		return nil, pkcs12.ErrIncorrectPassword
+	}
+
+	return plaintext[:len(plaintext)-padLen], nil
+}
+
+//octetStringContents returns the contents of an OCTET STRING, which may have
+// been given either as a primitive, or (as BER allows) as a constructed
+// sequence of OCTET STRINGs.
+func octetStringContents(v asn1.RawValue) ([]byte, error) {
+	if !v.IsCompound {
+		if v.Class == asn1.ClassUniversal && v.Tag == asn1.TagOctetString {
+			return v.Bytes, nil
+		}
+
+		//Implicitly tagged
+		if v.Class == asn1.ClassContextSpecific {
+			return v.Bytes, nil
+		}
+	}
+
+	var ret []byte
+	rest := v.Bytes
+	for len(rest) > 0 {
+		var part asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &part)
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := octetStringContents(part)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, contents...)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//The PKCS#12 files in testdata were made with OpenSSL 3.0 from the same self
+// signed cert. All but empty.p12 have the password "secret".
+// - pbes2.p12 has the OpenSSL 3 defaults: PBES2 and a SHA-256 MAC
+// - plaincerts.p12 doesn't encrypt the certs (-certpbe NONE)
+// - legacy.p12 uses 3DES and a SHA-1 MAC, which the PKCS#12 package reads
+// - sha512mac.p12 has a SHA-512 MAC
+// - empty.p12 has the empty password
+const keystoreTestCommonName = "Keystore Example"
+
+func readTestFile(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("Could not read test file: %s", err)
+	}
+
+	return data
+}
+
+func TestParsePKCS12(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		passwords []string
+		//wantErr is a substring of the error expected, if any
+		wantErr string
+	}{
+		{name: "pbes2", file: "pbes2.p12", passwords: []string{"secret"}},
+		{name: "legacy", file: "legacy.p12", passwords: []string{"secret"}},
+		{name: "sha512 mac", file: "sha512mac.p12", passwords: []string{"secret"}},
+		{name: "unencrypted certs", file: "plaincerts.p12", passwords: []string{"secret"}},
+		{name: "empty password", file: "empty.p12", passwords: []string{""}},
+		{name: "pbes2 falls back", file: "pbes2.p12", passwords: []string{"wrong", "", "secret"}},
+		{name: "legacy falls back", file: "legacy.p12", passwords: []string{"wrong", "secret"}},
+		{
+			name:      "pbes2 wrong password",
+			file:      "pbes2.p12",
+			passwords: []string{"wrong", ""},
+			wantErr:   "None of the 2 candidate passwords",
+		},
+		{
+			name:      "legacy wrong password",
+			file:      "legacy.p12",
+			passwords: []string{"wrong"},
+			wantErr:   "None of the 1 candidate passwords",
+		},
+		{
+			//The certs could be read with any password, but the MAC says that
+			// this one is wrong
+			name:      "unencrypted certs wrong password",
+			file:      "plaincerts.p12",
+			passwords: []string{"wrong"},
+			wantErr:   "None of the 1 candidate passwords",
+		},
+		{
+			name:      "empty password wrong",
+			file:      "empty.p12",
+			passwords: []string{"secret"},
+			wantErr:   "None of the 1 candidate passwords",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			certs, err := parseKeystore(string(readTestFile(t, test.file)), test.passwords)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if len(certs) != 1 || certs[0].Subject.CommonName != keystoreTestCommonName {
+				t.Fatalf("Got %d certs, want the one for %q", len(certs), keystoreTestCommonName)
+			}
+		})
+	}
+}
+
+func TestParsePKCS12Base64(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(readTestFile(t, "pbes2.p12"))
+	certs, err := parseKeystore(encoded, []string{"secret"})
+	if err != nil || len(certs) != 1 {
+		t.Fatalf("Got %d certs and error %v, want 1 cert", len(certs), err)
+	}
+}
+
+func TestParsePKCS12TamperedMAC(t *testing.T) {
+	for _, file := range []string{"pbes2.p12", "plaincerts.p12", "legacy.p12"} {
+		t.Run(file, func(t *testing.T) {
+			data := readTestFile(t, file)
+			//The MAC digest is the first OCTET STRING in the MacData, which is
+			// at the end of the file after its algorithm identifier
+			digestLen := 32
+			if file == "legacy.p12" {
+				digestLen = 20
+			}
+
+			idx := bytes.LastIndex(data, []byte{0x04, byte(digestLen)})
+			if idx < 0 {
+				t.Fatalf("Could not find the MAC digest")
+			}
+			data[idx+2] ^= 0xFF
+
+			_, err := parseKeystore(string(data), []string{"secret"})
+			if err == nil || !strings.Contains(err.Error(), "None of the 1 candidate passwords") {
+				t.Fatalf("Got error %v, want a wrong password", err)
+			}
+		})
+	}
+}
+
+//jksBuilder writes a Java keystore. The keystore's trailing integrity hash
+// isn't written, as nothing reads it.
+type jksBuilder struct {
+	bytes.Buffer
+	version uint32
+}
+
+func newJKSBuilder(version, numEntries uint32) *jksBuilder {
+	b := &jksBuilder{version: version}
+	b.uint32(0xFEEDFEED)
+	b.uint32(version)
+	b.uint32(numEntries)
+	return b
+}
+
+func (b *jksBuilder) uint32(v uint32) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+func (b *jksBuilder) utf(s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
+
+func (b *jksBuilder) header(tag uint32, alias string) {
+	b.uint32(tag)
+	b.utf(alias)
+	binary.Write(b, binary.BigEndian, uint64(1577836800000))
+}
+
+func (b *jksBuilder) cert(der []byte) {
+	if b.version == 2 {
+		b.utf("X.509")
+	}
+
+	b.uint32(uint32(len(der)))
+	b.Write(der)
+}
+
+func (b *jksBuilder) trustedCert(alias string, der []byte) *jksBuilder {
+	b.header(2, alias)
+	b.cert(der)
+	return b
+}
+
+func (b *jksBuilder) privateKey(alias string, chain ...[]byte) *jksBuilder {
+	b.header(1, alias)
+	key := []byte("an encrypted private key")
+	b.uint32(uint32(len(key)))
+	b.Write(key)
+	b.uint32(uint32(len(chain)))
+	for _, der := range chain {
+		b.cert(der)
+	}
+
+	return b
+}
+
+func TestParseJKS(t *testing.T) {
+	var der [][]byte
+	for rest := readTestFile(t, "certs.pem"); ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		der = append(der, block.Bytes)
+	}
+
+	keystore, bundle := der[0], der[1]
+	tests := []struct {
+		name string
+		data []byte
+		//want is the common name of each cert expected
+		want []string
+		//wantErr is a substring of the error expected, if any
+		wantErr string
+	}{
+		{
+			name: "trusted cert",
+			data: newJKSBuilder(2, 1).trustedCert("ca", bundle).Bytes(),
+			want: []string{"Bundle Example"},
+		},
+		{
+			name: "private key chain",
+			data: newJKSBuilder(2, 1).privateKey("server", keystore, bundle).Bytes(),
+			want: []string{"Keystore Example", "Bundle Example"},
+		},
+		{
+			name: "several entries",
+			data: newJKSBuilder(2, 2).privateKey("server", keystore).trustedCert("ca", bundle).Bytes(),
+			want: []string{"Keystore Example", "Bundle Example"},
+		},
+		{
+			name: "version 1",
+			data: newJKSBuilder(1, 2).trustedCert("ca", bundle).privateKey("server", keystore).Bytes(),
+			want: []string{"Bundle Example", "Keystore Example"},
+		},
+		{
+			name: "unparseable cert skipped",
+			data: newJKSBuilder(2, 2).trustedCert("junk", []byte("not a cert")).trustedCert("ca", bundle).Bytes(),
+			want: []string{"Bundle Example"},
+		},
+		{name: "no entries", data: newJKSBuilder(2, 0).Bytes()},
+		{
+			name:    "unsupported version",
+			data:    newJKSBuilder(3, 0).Bytes(),
+			wantErr: "Unsupported JKS version 3",
+		},
+		{
+			name: "unknown entry type",
+			data: func() []byte {
+				b := newJKSBuilder(2, 1)
+				b.header(3, "secret")
+				return b.Bytes()
+			}(),
+			wantErr: "Unknown JKS entry type 3",
+		},
+		{
+			name: "truncated",
+			data: func() []byte {
+				b := newJKSBuilder(2, 1).trustedCert("ca", bundle).Bytes()
+				return b[:len(b)-1]
+			}(),
+			wantErr: "Could not read JKS",
+		},
+		{
+			name:    "more entries than present",
+			data:    newJKSBuilder(2, 2).trustedCert("ca", bundle).Bytes(),
+			wantErr: "Could not read JKS",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			//A JKS doesn't need a password for its certs
+			certs, err := parseKeystore(string(test.data), nil)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			got := []string{}
+			for _, cert := range certs {
+				got = append(got, cert.Subject.CommonName)
+			}
+
+			want := test.want
+			if want == nil {
+				want = []string{}
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("Got certs %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseKeystoreNotKeystore(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"password123",
+		string(readTestFile(t, "certs.pem")),
+		string(readTestFile(t, "certs.p7b")),
+		base64.StdEncoding.EncodeToString([]byte("a base64 encoded value that is not a keystore at all")),
+	} {
+		certs, err := parseKeystore(value, []string{"secret"})
+		if certs != nil || err != nil {
+			t.Errorf("Got %d certs and error %v from %.20q, want neither", len(certs), err, value)
+		}
+	}
+}
+
+func TestCandidatePasswords(t *testing.T) {
+	secret := map[string]string{
+		"keystore_password": "from-default-key",
+		"p12_password":      "from-p12-key",
+		"jks_password":      "",
+	}
+
+	tests := []struct {
+		name   string
+		config KeystoreConfig
+		secret map[string]string
+		want   []string
+	}{
+		{name: "defaults", secret: secret, want: []string{"from-default-key", ""}},
+		{name: "defaults without the key", secret: map[string]string{}, want: []string{""}},
+		{
+			name:   "configured passwords",
+			config: KeystoreConfig{Passwords: []string{"changeit", "secret"}},
+			secret: secret,
+			want:   []string{"from-default-key", "changeit", "secret", ""},
+		},
+		{
+			name:   "configured keys replace the default",
+			config: KeystoreConfig{PasswordKeys: []string{"p12_password", "missing", "jks_password"}},
+			secret: secret,
+			want:   []string{"from-p12-key", "", ""},
+		},
+		{
+			name:   "no keys",
+			config: KeystoreConfig{PasswordKeys: []string{}, Passwords: []string{"changeit"}},
+			secret: secret,
+			want:   []string{"changeit", ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.config.candidatePasswords(test.secret)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/doomsday-project/doomsday/server/auth"
+)
+
+//metricsWriter writes metrics in the Prometheus text exposition format
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+type metricLabel struct {
+	name  string
+	value string
+}
+
+//family writes the HELP and TYPE lines that must come before a metric's samples
+func (m *metricsWriter) family(name, metricType, help string) {
+	fmt.Fprintf(&m.buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(&m.buf, "# TYPE %s %s\n", name, metricType)
+}
+
+func (m *metricsWriter) sample(name string, value float64, labels ...metricLabel) {
+	m.buf.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels))
+		for _, label := range labels {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", label.name, escapeLabelValue(label.value)))
+		}
+
+		m.buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	m.buf.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func timestampSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+//bearerTokenHandler only allows requests which give the token in an
+// `Authorization: Bearer' header
+func bearerTokenHandler(token string) auth.TokenFunc {
+	return func(fn http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				w.WriteHeader(401)
+				return
+			}
+
+			fn(w, r)
+		}
+	}
+}
+
+func getMetrics(manager *SourceManager) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := &metricsWriter{}
+		writeCertMetrics(m, manager)
+		writeSourceMetrics(m, manager)
+		writeSchedulerMetrics(m, manager)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(200)
+		writeBody(w, m.buf.Bytes())
+	}
+}
+
+func writeCertMetrics(m *metricsWriter, manager *SourceManager) {
+	const notAfter = "doomsday_cert_not_after_seconds"
+	const effectiveNotAfter = "doomsday_cert_effective_not_after_seconds"
+	items := manager.Data()
+
+	m.family(notAfter, "gauge", "The time at which the cert expires, in seconds since the epoch")
+	for _, item := range items {
+		for _, path := range item.Paths {
+			m.sample(notAfter, float64(item.NotAfter),
+				metricLabel{"common_name", item.Name()},
+				metricLabel{"backend", path.Backend},
+				metricLabel{"path", path.Location},
+				metricLabel{"type", item.Type},
+				metricLabel{"fingerprint", item.Fingerprint},
+			)
+		}
+	}
+
+	m.family(effectiveNotAfter, "gauge", "The time at which the cert or an issuer in its chain expires, in seconds since the epoch")
+	for _, item := range items {
+		for _, path := range item.Paths {
+			m.sample(effectiveNotAfter, float64(item.EffectiveNotAfter),
+				metricLabel{"common_name", item.Name()},
+				metricLabel{"backend", path.Backend},
+				metricLabel{"path", path.Location},
+				metricLabel{"type", item.Type},
+				metricLabel{"fingerprint", item.Fingerprint},
+			)
+		}
+	}
+}
+
+func writeSourceMetrics(m *metricsWriter, manager *SourceManager) {
+	type sourceStatus struct {
+		name    string
+		refresh RunInfo
+		auth    RunInfo
+		stale   bool
+	}
+
+	statuses := []sourceStatus{}
+	for i := range manager.sources {
+		source := &manager.sources[i]
+		statuses = append(statuses, sourceStatus{
+			name:    source.Core.Name,
+			refresh: source.RefreshStatus(),
+			auth:    source.AuthStatus(),
+			stale:   source.Stale(),
+		})
+	}
+
+	families := []struct {
+		name  string
+		help  string
+		value func(sourceStatus) float64
+	}{
+		{
+			"doomsday_source_refresh_duration_seconds",
+			"How long the last refresh of the backend took",
+			func(s sourceStatus) float64 {
+				if s.refresh.LastRun.FinishedAt.IsZero() {
+					return 0
+				}
+
+				return s.refresh.LastRun.FinishedAt.Sub(s.refresh.LastRun.StartedAt).Seconds()
+			},
+		},
+		{
+			"doomsday_source_last_refresh_timestamp_seconds",
+			"When the last refresh of the backend finished, in seconds since the epoch",
+			func(s sourceStatus) float64 { return timestampSeconds(s.refresh.LastRun.FinishedAt) },
+		},
+		{
+			"doomsday_source_last_refresh_success_timestamp_seconds",
+			"When the last successful refresh of the backend finished, in seconds since the epoch",
+			func(s sourceStatus) float64 { return timestampSeconds(s.refresh.LastSuccess.FinishedAt) },
+		},
+		{
+			"doomsday_source_refresh_success",
+			"1 if the last refresh of the backend succeeded, and 0 otherwise",
+			func(s sourceStatus) float64 {
+				return boolGauge(!s.refresh.LastRun.FinishedAt.IsZero() && s.refresh.LastErr == nil)
+			},
+		},
+		{
+			"doomsday_source_stale",
+			"1 if the backend's certs were loaded from the cache file and have not been refreshed since",
+			func(s sourceStatus) float64 { return boolGauge(s.stale) },
+		},
+		{
+			"doomsday_source_last_auth_success_timestamp_seconds",
+			"When the last successful authentication to the backend finished, in seconds since the epoch",
+			func(s sourceStatus) float64 { return timestampSeconds(s.auth.LastSuccess.FinishedAt) },
+		},
+		{
+			"doomsday_source_auth_success",
+			"1 if the last authentication to the backend succeeded, and 0 otherwise",
+			func(s sourceStatus) float64 {
+				return boolGauge(!s.auth.LastRun.FinishedAt.IsZero() && s.auth.LastErr == nil)
+			},
+		},
+	}
+
+	for _, family := range families {
+		m.family(family.name, "gauge", family.help)
+		for _, status := range statuses {
+			m.sample(family.name, family.value(status), metricLabel{"backend", status.name})
+		}
+	}
+}
+
+func writeSchedulerMetrics(m *metricsWriter, manager *SourceManager) {
+	state := manager.SchedulerState()
+
+	m.family("doomsday_scheduler_pending_tasks", "gauge", "The number of tasks waiting in the scheduler queue")
+	m.sample("doomsday_scheduler_pending_tasks", float64(len(state.Pending)))
+
+	m.family("doomsday_scheduler_running_tasks", "gauge", "The number of tasks currently being run by workers")
+	m.sample("doomsday_scheduler_running_tasks", float64(len(state.Running)))
+
+	workerStates := map[string]int{}
+	for _, s := range []WorkerState{WorkerStateIdle, WorkerStateRunning, WorkerStateScheduling} {
+		workerStates[s.String()] = 0
+	}
+
+	for _, worker := range state.Workers {
+		workerStates[worker.State]++
+	}
+
+	names := make([]string, 0, len(workerStates))
+	for name := range workerStates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m.family("doomsday_scheduler_workers", "gauge", "The number of scheduler workers in each state")
+	for _, name := range names {
+		m.sample("doomsday_scheduler_workers", float64(workerStates[name]), metricLabel{"state", name})
+	}
+}
@@ -39,6 +39,7 @@ func NotifyFrom(conf notify.Config, m *SourceManager, l *logger.Logger) error {
 
 	n.s.Start()
 	go func() {
+		lastCheck := time.Now()
 		for range n.s.Channel() {
 			l.WriteF("Triggering notification check")
 			const (
@@ -51,23 +52,60 @@ func NotifyFrom(conf notify.Config, m *SourceManager, l *logger.Logger) error {
 			state := StateOK
 			expiredThreshold := time.Duration(0)
 			expiringSoonThreshold := time.Hour * 24 * 7 * 4
+			crlSoonThreshold := time.Hour * time.Duration(conf.CRLSoonHours)
+			others, crls := splitCRLs(d)
 			if len(d.Filter(doomsday.CacheItemFilter{Within: &expiredThreshold})) > 0 {
 				state = StateExpired
-			} else if len(d.Filter(doomsday.CacheItemFilter{Within: &expiringSoonThreshold})) > 0 {
+			} else if len(others.Filter(doomsday.CacheItemFilter{Within: &expiringSoonThreshold})) > 0 ||
+				len(crls.Filter(doomsday.CacheItemFilter{Within: &crlSoonThreshold})) > 0 {
 				state = StateSoon
 			}
 
+			notes := []string{}
+			if numWarnings := len(m.Warnings()); numWarnings > 0 {
+				notes = append(notes, fmt.Sprintf("%d paths could not be checked for certs", numWarnings))
+			}
+
+			numMismatched, numViolating := 0, 0
+			for _, item := range d {
+				if item.KeyMismatch {
+					numMismatched++
+				}
+
+				if len(item.Findings) > 0 {
+					numViolating++
+				}
+			}
+			if numMismatched > 0 {
+				notes = append(notes, fmt.Sprintf("%d certs are stored with a private key that does not match", numMismatched))
+			}
+			if conf.Policy && numViolating > 0 {
+				notes = append(notes, fmt.Sprintf("%d certs violate the certificate policy", numViolating))
+			}
+
+			now := time.Now()
+			if conf.History {
+				numRotated, numVanished := countHistorySince(m.History(), lastCheck)
+				if numRotated > 0 {
+					notes = append(notes, fmt.Sprintf("%d certs were rotated since the last check", numRotated))
+				}
+				if numVanished > 0 {
+					notes = append(notes, fmt.Sprintf("%d certs vanished with no replacement since the last check", numVanished))
+				}
+			}
+			lastCheck = now
+
 			var sendErr error
 			switch state {
 			case StateOK:
 				l.WriteF("No expiring certs")
-				sendErr = n.b.OK()
+				sendErr = n.b.OK(notes)
 			case StateSoon:
 				l.WriteF("Certs expiring soon")
-				sendErr = n.b.Soon()
+				sendErr = n.b.Soon(notes)
 			case StateExpired:
 				l.WriteF("Certs expired")
-				sendErr = n.b.Expired()
+				sendErr = n.b.Expired(notes)
 			}
 			if sendErr != nil {
 				l.WriteF("Could not send notification: %s", sendErr)
@@ -77,3 +115,37 @@ func NotifyFrom(conf notify.Config, m *SourceManager, l *logger.Logger) error {
 
 	return nil
 }
+
+//countHistorySince returns the number of rotated and vanished events in the
+// history since the given time
+func countHistorySince(events []HistoryEvent, since time.Time) (rotated, vanished int) {
+	for _, event := range events {
+		if event.At.Before(since) {
+			continue
+		}
+
+		switch event.Kind {
+		case HistoryEventRotated:
+			rotated++
+		case HistoryEventVanished:
+			vanished++
+		}
+	}
+
+	return rotated, vanished
+}
+
+//splitCRLs separates the CRLs from the other items, as they have their own
+// threshold for expiring soon
+func splitCRLs(items doomsday.CacheItems) (others, crls doomsday.CacheItems) {
+	others, crls = doomsday.CacheItems{}, doomsday.CacheItems{}
+	for _, item := range items {
+		if item.Type == doomsday.CacheItemTypeCRL {
+			crls = append(crls, item)
+		} else {
+			others = append(others, item)
+		}
+	}
+
+	return others, crls
+}
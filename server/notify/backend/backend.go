@@ -8,10 +8,12 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
+//Backend sends notifications. notes are extra lines of information to be
+// sent along with the notification, and may be empty.
 type Backend interface {
-	OK() error
-	Soon() error
-	Expired() error
+	OK(notes []string) error
+	Soon(notes []string) error
+	Expired(notes []string) error
 }
 
 type Config struct {
@@ -83,3 +85,7 @@ const (
 	msgSoon    = "Warning! There are certs expiring soon"
 	msgExpired = "AHHH! There are expired certs!"
 )
+
+func withNotes(msg string, notes []string) string {
+	return strings.Join(append([]string{msg}, notes...), "\n")
+}
@@ -53,30 +53,30 @@ func newShoutBackend(c ShoutConfig, uni BackendUniversalConfig) (*Shout, error)
 	}, nil
 }
 
-func (s Shout) OK() error {
+func (s Shout) OK(notes []string) error {
 	return s.client.PostEvent(shout.EventIn{
 		Topic:      s.topic,
-		Message:    msgOK,
+		Message:    withNotes(msgOK, notes),
 		Link:       s.doomsdayDomain,
 		OccurredAt: time.Now(),
 		OK:         true,
 	})
 }
 
-func (s Shout) Soon() error {
+func (s Shout) Soon(notes []string) error {
 	return s.client.PostEvent(shout.EventIn{
 		Topic:      s.topic,
-		Message:    msgSoon,
+		Message:    withNotes(msgSoon, notes),
 		Link:       s.doomsdayDomain,
 		OccurredAt: time.Now(),
 		OK:         false,
 	})
 }
 
-func (s Shout) Expired() error {
+func (s Shout) Expired(notes []string) error {
 	return s.client.PostEvent(shout.EventIn{
 		Topic:      s.topic,
-		Message:    msgExpired,
+		Message:    withNotes(msgExpired, notes),
 		Link:       s.doomsdayDomain,
 		OccurredAt: time.Now(),
 		OK:         false,
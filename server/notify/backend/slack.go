@@ -29,25 +29,27 @@ func newSlackBackend(c SlackConfig, uni BackendUniversalConfig) (*Slack, error)
 		return nil, fmt.Errorf("Webhook not parsable as URL")
 	}
 	return &Slack{
-		webhook: c.Webhook,
-		topic:   fmt.Sprintf("%s<%s>%s", slackQuoteMeta("doomsday: ("), uni.DoomsdayURL, "): "),
+		webhook:  c.Webhook,
+		topic:    fmt.Sprintf("%s<%s>%s", slackQuoteMeta("doomsday: ("), uni.DoomsdayURL, "): "),
+		notifyOK: c.NotifyOK,
 	}, nil
 }
 
-func (s Slack) OK() error {
+//OK only sends if notify_ok is configured, even if there are notes
+func (s Slack) OK(notes []string) error {
 	var err error
 	if s.notifyOK {
-		err = s.send(msgOK)
+		err = s.send(withNotes(msgOK, notes))
 	}
 	return err
 }
 
-func (s Slack) Soon() error {
-	return s.send(msgSoon)
+func (s Slack) Soon(notes []string) error {
+	return s.send(withNotes(msgSoon, notes))
 }
 
-func (s Slack) Expired() error {
-	return s.send(msgExpired)
+func (s Slack) Expired(notes []string) error {
+	return s.send(withNotes(msgExpired, notes))
 }
 
 func (s Slack) send(msg string) error {
@@ -9,4 +9,19 @@ type Config struct {
 	Backend     backend.Config  `yaml:"backend"`
 	Schedule    schedule.Config `yaml:"schedule"`
 	DoomsdayURL string          `yaml:"doomsday_url"`
+	//Policy, if true, includes the number of certs violating the configured
+	// policy in notifications
+	Policy bool `yaml:"policy"`
+	//History, if true, includes the number of certs which were rotated or
+	// vanished since the previous notification
+	History bool `yaml:"history"`
+	//CRLSoonHours is how close to its next update a CRL has to be to count as
+	// expiring soon. CRLs are normally reissued well within the four weeks used
+	// for certs, so they have their own threshold. If 0, CRLs only count once
+	// they have expired.
+	CRLSoonHours int `yaml:"crl_soon_hours"`
 }
+
+//DefaultCRLSoonHours is short of the 12 hours before their next update that
+// Vault and Active Directory Certificate Services reissue CRLs by default
+const DefaultCRLSoonHours = 6
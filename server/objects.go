@@ -0,0 +1,37 @@
+package server
+
+const (
+	//CacheObjectTypeCertificate is an X.509 certificate. Objects with no type
+	// are certificates.
+	CacheObjectTypeCertificate = "certificate"
+	//CacheObjectTypeCRL is an X.509 certificate revocation list, which expires
+	// at its NextUpdate time
+	CacheObjectTypeCRL = "crl"
+	//CacheObjectTypeSSHCertificate is an OpenSSH certificate, which expires at
+	// its ValidBefore time
+	CacheObjectTypeSSHCertificate = "ssh_certificate"
+	//CacheObjectTypePGPKey is an OpenPGP primary key or subkey which has an
+	// expiration time set by its latest self-signature
+	CacheObjectTypePGPKey = "pgp_key"
+)
+
+//objectParser finds expiring objects other than X.509 certificates in a
+// secret value. The objects returned have no paths.
+type objectParser func(value string) []CacheObject
+
+var objectParsers = []objectParser{
+	parseCRLObjects,
+	parseSSHCertObjects,
+	parsePGPKeyObjects,
+}
+
+//parseObjects returns every object that any of the object parsers find in the
+// given secret value
+func parseObjects(value string) []CacheObject {
+	var ret []CacheObject
+	for _, parse := range objectParsers {
+		ret = append(ret, parse(value)...)
+	}
+
+	return ret
+}
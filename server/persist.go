@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//CacheFile snapshots the cache of each source to disk so that the server has
+// something to show when it restarts, before its first refreshes complete.
+type CacheFile struct {
+	path string
+	lock sync.Mutex
+}
+
+type cacheSnapshot struct {
+	SavedAt time.Time                 `json:"saved_at"`
+	Sources map[string]sourceSnapshot `json:"sources"`
+	History []HistoryEvent            `json:"history,omitempty"`
+}
+
+type sourceSnapshot struct {
+	RefreshStatus runInfoSnapshot        `json:"refresh_status"`
+	Cache         map[string]CacheObject `json:"cache"`
+}
+
+//runInfoSnapshot is a RunInfo with its error flattened to a string
+type runInfoSnapshot struct {
+	LastRun     RunTiming `json:"last_run"`
+	LastSuccess RunTiming `json:"last_success"`
+	LastErr     string    `json:"last_err,omitempty"`
+}
+
+func NewCacheFile(path string) *CacheFile {
+	return &CacheFile{path: path}
+}
+
+//Save writes the current cache and refresh status of each source, along with
+// the history, to the file, replacing whatever was there
+func (f *CacheFile) Save(sources []Source, history *History) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	snapshot := cacheSnapshot{
+		SavedAt: time.Now(),
+		Sources: make(map[string]sourceSnapshot, len(sources)),
+		History: history.Events(),
+	}
+
+	for i := range sources {
+		snapshot.Sources[sources[i].Core.Name] = sources[i].snapshot()
+	}
+
+	contents, err := json.Marshal(&snapshot)
+	if err != nil {
+		return fmt.Errorf("Could not encode cache: %s", err)
+	}
+
+	//Write to a temporary file first so that a crash partway through can't
+	// leave us with a truncated cache
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Could not create temporary cache file: %s", err)
+	}
+
+	_, err = tmp.Write(contents)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Could not write cache file: %s", err)
+	}
+
+	err = os.Rename(tmp.Name(), f.path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Could not replace cache file: %s", err)
+	}
+
+	return nil
+}
+
+//Load reads the snapshot from the file. If the file doesn't exist, an empty
+// snapshot is returned.
+func (f *CacheFile) Load() (*cacheSnapshot, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	contents, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &cacheSnapshot{}, nil
+		}
+
+		return nil, fmt.Errorf("Could not read cache file: %s", err)
+	}
+
+	ret := &cacheSnapshot{}
+	err = json.Unmarshal(contents, ret)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse cache file: %s", err)
+	}
+
+	return ret, nil
+}
+
+func (s *Source) snapshot() sourceSnapshot {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	//A failed refresh leaves the cache as it was, so a source which is still
+	// stale writes back what it loaded
+	ret := sourceSnapshot{
+		RefreshStatus: runInfoSnapshot{
+			LastRun:     s.refreshStatus.LastRun,
+			LastSuccess: s.refreshStatus.LastSuccess,
+		},
+		Cache: s.Core.Cache().Map(),
+	}
+
+	if s.refreshStatus.LastErr != nil {
+		ret.RefreshStatus.LastErr = s.refreshStatus.LastErr.Error()
+	}
+
+	return ret
+}
+
+//restore puts the snapshot into the source, marking it stale until its next
+// successful refresh
+func (s *Source) restore(snapshot sourceSnapshot) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.refreshStatus = RunInfo{
+		LastRun:     snapshot.RefreshStatus.LastRun,
+		LastSuccess: snapshot.RefreshStatus.LastSuccess,
+	}
+
+	if snapshot.RefreshStatus.LastErr != "" {
+		s.refreshStatus.LastErr = fmt.Errorf("%s", snapshot.RefreshStatus.LastErr)
+	}
+
+	cache := NewCache()
+	for key, obj := range snapshot.Cache {
+		cache.Store(key, obj)
+	}
+
+	s.Core.SetCache(cache)
+	s.stale = true
+}
+
+//Stale returns true if the source's cache was loaded from the cache file and
+// has not been successfully refreshed since
+func (s *Source) Stale() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.stale
+}
@@ -0,0 +1,767 @@
+package server
+
+//This reads OpenPGP keys itself, rather than with golang.org/x/crypto/openpgp,
+// because the version of that which we use can't parse EdDSA keys, which are
+// what GnuPG makes by default. It also stops reading a keyring at the first
+// key it can't parse, where we want to skip just that key.
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+//OpenPGP packet tags that we care about
+const (
+	pgpTagSignature       = 2
+	pgpTagSecretKey       = 5
+	pgpTagPublicKey       = 6
+	pgpTagSecretSubkey    = 7
+	pgpTagUserID          = 13
+	pgpTagPublicSubkey    = 14
+	pgpTagUserAttribute   = 17
+	pgpSubpacketCreated   = 2
+	pgpSubpacketExpires   = 9
+	pgpSubpacketIssuer    = 16
+	pgpSubpacketKeyFlags  = 27
+	pgpSubpacketIssuerFpr = 33
+)
+
+//OpenPGP signature types that we care about
+const (
+	pgpSigCertGeneric      = 0x10
+	pgpSigCertPositive     = 0x13
+	pgpSigSubkeyBinding    = 0x18
+	pgpSigDirectKey        = 0x1F
+	pgpSigKeyRevocation    = 0x20
+	pgpSigSubkeyRevocation = 0x28
+)
+
+var pgpAlgorithmNames = map[byte]string{
+	1:  "RSA",
+	2:  "RSA",
+	3:  "RSA",
+	16: "ElGamal",
+	17: "DSA",
+	18: "ECDH",
+	19: "ECDSA",
+	22: "EdDSA",
+	25: "X25519",
+	26: "X448",
+	27: "Ed25519",
+	28: "Ed448",
+}
+
+//pgpCurveSizes are the sizes of the curves that ECC keys can be on, keyed by
+// the hex of their OID
+var pgpCurveSizes = map[string]int{
+	"2a8648ce3d030107":     256, //NIST P-256
+	"2b81040022":           384, //NIST P-384
+	"2b81040023":           521, //NIST P-521
+	"2b8104000a":           256, //secp256k1
+	"2b2403030208010107":   256, //brainpoolP256r1
+	"2b240303020801010b":   384, //brainpoolP384r1
+	"2b240303020801010d":   512, //brainpoolP512r1
+	"2b06010401da470f01":   256, //Ed25519
+	"2b060104019755010501": 256, //Curve25519
+}
+
+type pgpPacket struct {
+	tag  byte
+	body []byte
+}
+
+type pgpSignature struct {
+	version       byte
+	sigType       byte
+	pubAlgorithm  byte
+	hashAlgorithm byte
+	created       time.Time
+	lifetime      uint32
+	keyFlags      []byte
+	issuerIDs     [][]byte
+	//hashedLen is how much of raw, from the start, is hashed
+	hashedLen int
+	//hashPrefix is the first two octets of the hash, given in the clear
+	hashPrefix []byte
+	//salt is hashed before anything else in v6 signatures
+	salt []byte
+	//material is the signature itself
+	material []byte
+	//userID is the user ID that a certification is on
+	userID []byte
+	//unverified is set if the signature uses an algorithm we can't check
+	unverified bool
+	raw        []byte
+}
+
+type pgpKey struct {
+	version     byte
+	created     time.Time
+	algorithm   byte
+	keySize     int
+	fingerprint []byte
+	//material is the public key material, after the packet's header
+	material []byte
+	raw      []byte
+	sigs     []pgpSignature
+}
+
+type pgpEntity struct {
+	primary *pgpKey
+	subkeys []*pgpKey
+}
+
+//parsePGPKeyObjects finds OpenPGP keys in the given value, which may be ASCII
+// armored, binary, or binary that has been base64 encoded. A CacheObject is
+// returned for each primary key and subkey that has an expiration time and has
+// not been revoked.
+func parsePGPKeyObjects(value string) []CacheObject {
+	var ret []CacheObject
+	for _, entity := range parsePGPEntities([]byte(value)) {
+		ret = append(ret, entity.cacheObjects()...)
+	}
+
+	return ret
+}
+
+func parsePGPEntities(b []byte) []pgpEntity {
+	if bytes.Contains(b, []byte("-----BEGIN PGP ")) {
+		block, err := armor.Decode(bytes.NewReader(b))
+		if err != nil {
+			return nil
+		}
+
+		b, err = ioutil.ReadAll(block.Body)
+		if err != nil {
+			return nil
+		}
+
+		return readPGPEntities(b)
+	}
+
+	if entities := readPGPEntities(b); len(entities) > 0 {
+		return entities
+	}
+
+	if decoded, ok := decodeBase64(b); ok {
+		return readPGPEntities(decoded)
+	}
+
+	return nil
+}
+
+//readPGPEntities groups a sequence of binary OpenPGP packets into keys. Any
+// packets we don't understand are skipped, but the input must start with a
+// key packet for it to be considered OpenPGP at all.
+func readPGPEntities(b []byte) []pgpEntity {
+	packets, err := readPGPPackets(b)
+	if err != nil || len(packets) == 0 ||
+		(packets[0].tag != pgpTagPublicKey && packets[0].tag != pgpTagSecretKey) {
+		return nil
+	}
+
+	var ret []pgpEntity
+	var entity *pgpEntity
+	//current is the key that following signatures apply to, and userID is the
+	// user ID they certify, if any
+	var current *pgpKey
+	var userID []byte
+	for _, packet := range packets {
+		switch packet.tag {
+		case pgpTagPublicKey, pgpTagSecretKey:
+			if entity != nil {
+				ret = append(ret, *entity)
+			}
+
+			entity, current, userID = nil, nil, nil
+			key, err := parsePGPKey(packet.body)
+			if err != nil {
+				continue
+			}
+
+			entity = &pgpEntity{primary: key}
+			current = key
+
+		case pgpTagPublicSubkey, pgpTagSecretSubkey:
+			current, userID = nil, nil
+			if entity == nil {
+				continue
+			}
+
+			key, err := parsePGPKey(packet.body)
+			if err != nil {
+				continue
+			}
+
+			entity.subkeys = append(entity.subkeys, key)
+			current = key
+
+		case pgpTagUserID:
+			if entity != nil {
+				//Certifications on a user ID are about the primary key
+				current, userID = entity.primary, packet.body
+			}
+
+		case pgpTagUserAttribute:
+			//Photo IDs say nothing about expiry
+			current, userID = nil, nil
+
+		case pgpTagSignature:
+			if current == nil {
+				continue
+			}
+
+			sig, err := parsePGPSignature(packet.body)
+			if err == nil {
+				sig.userID = userID
+				current.sigs = append(current.sigs, sig)
+			}
+		}
+	}
+
+	if entity != nil {
+		ret = append(ret, *entity)
+	}
+
+	return ret
+}
+
+func readPGPPackets(b []byte) ([]pgpPacket, error) {
+	var ret []pgpPacket
+	for len(b) > 0 {
+		header := b[0]
+		if header&0x80 == 0 {
+			return nil, fmt.Errorf("Invalid packet header")
+		}
+
+		var tag byte
+		var body []byte
+		var err error
+		if header&0x40 != 0 {
+			tag = header & 0x3F
+			body, b, err = readNewPGPPacketBody(b[1:])
+		} else {
+			tag = (header >> 2) & 0x0F
+			body, b, err = readOldPGPPacketBody(header&0x03, b[1:])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, pgpPacket{tag: tag, body: body})
+	}
+
+	return ret, nil
+}
+
+func readOldPGPPacketBody(lengthType byte, b []byte) (body, rest []byte, err error) {
+	var length int
+	switch lengthType {
+	case 0:
+		if len(b) < 1 {
+			return nil, nil, fmt.Errorf("Truncated packet length")
+		}
+		length, b = int(b[0]), b[1:]
+	case 1:
+		if len(b) < 2 {
+			return nil, nil, fmt.Errorf("Truncated packet length")
+		}
+		length, b = int(binary.BigEndian.Uint16(b)), b[2:]
+	case 2:
+		if len(b) < 4 {
+			return nil, nil, fmt.Errorf("Truncated packet length")
+		}
+		length, b = int(binary.BigEndian.Uint32(b)), b[4:]
+	default:
+		//The packet runs to the end of the input
+		length = len(b)
+	}
+
+	if length < 0 || length > len(b) {
+		return nil, nil, fmt.Errorf("Truncated packet")
+	}
+
+	return b[:length], b[length:], nil
+}
+
+//readNewPGPPacketBody reads a new format packet body, which may be split into
+// partial lengths
+func readNewPGPPacketBody(b []byte) (body, rest []byte, err error) {
+	for {
+		if len(b) < 1 {
+			return nil, nil, fmt.Errorf("Truncated packet length")
+		}
+
+		var length int
+		partial := false
+		switch first := int(b[0]); {
+		case first < 192:
+			length, b = first, b[1:]
+		case first < 224:
+			if len(b) < 2 {
+				return nil, nil, fmt.Errorf("Truncated packet length")
+			}
+			length, b = (first-192)<<8+int(b[1])+192, b[2:]
+		case first == 255:
+			if len(b) < 5 {
+				return nil, nil, fmt.Errorf("Truncated packet length")
+			}
+			length, b = int(binary.BigEndian.Uint32(b[1:])), b[5:]
+		default:
+			length, b = 1<<uint(first&0x1F), b[1:]
+			partial = true
+		}
+
+		if length < 0 || length > len(b) {
+			return nil, nil, fmt.Errorf("Truncated packet")
+		}
+
+		body, b = append(body, b[:length]...), b[length:]
+		if !partial {
+			return body, b, nil
+		}
+	}
+}
+
+//parsePGPKey parses the public part of a v4 or v6 public or secret key packet
+func parsePGPKey(body []byte) (*pgpKey, error) {
+	if len(body) < 6 {
+		return nil, fmt.Errorf("Truncated key packet")
+	}
+
+	ret := &pgpKey{
+		version:   body[0],
+		created:   time.Unix(int64(binary.BigEndian.Uint32(body[1:5])), 0),
+		algorithm: body[5],
+	}
+
+	//v6 keys also give the length of the key material, but we need to work it
+	// out from the algorithm for v4 keys anyway
+	headerLen := 6
+	switch ret.version {
+	case 4:
+	case 6:
+		headerLen = 10
+		if len(body) < headerLen {
+			return nil, fmt.Errorf("Truncated key packet")
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported key version %d", ret.version)
+	}
+
+	length, keySize, err := pgpPublicKeyMaterial(ret.algorithm, body[headerLen:])
+	if err != nil {
+		return nil, err
+	}
+
+	ret.keySize = keySize
+	ret.material = body[headerLen : headerLen+length]
+	//Anything after the public key material is the secret key
+	public := body[:headerLen+length]
+
+	//Secret key packets are fingerprinted as their public counterparts
+	if ret.version == 4 {
+		h := sha1.New()
+		h.Write([]byte{0x99, byte(len(public) >> 8), byte(len(public))})
+		h.Write(public)
+		ret.fingerprint = h.Sum(nil)
+	} else {
+		h := sha256.New()
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(public)))
+		h.Write([]byte{0x9B})
+		h.Write(length[:])
+		h.Write(public)
+		ret.fingerprint = h.Sum(nil)
+	}
+
+	ret.raw = public
+	return ret, nil
+}
+
+//pgpPublicKeyMaterial returns the length of the public key material at the
+// start of b for the given algorithm, and the size of the key in bits
+func pgpPublicKeyMaterial(algorithm byte, b []byte) (length, keySize int, err error) {
+	//readMPIs skips over count MPIs, returning the bit length of the first
+	readMPIs := func(count int) (int, error) {
+		firstBits := 0
+		for i := 0; i < count; i++ {
+			if len(b)-length < 2 {
+				return 0, fmt.Errorf("Truncated key material")
+			}
+
+			bits := int(binary.BigEndian.Uint16(b[length:]))
+			if i == 0 {
+				firstBits = bits
+			}
+
+			length += 2 + (bits+7)/8
+			if length > len(b) {
+				return 0, fmt.Errorf("Truncated key material")
+			}
+		}
+
+		return firstBits, nil
+	}
+
+	//readCurve skips over a curve OID, returning the size of the curve
+	readCurve := func() (int, error) {
+		if len(b) < 1 || b[0] == 0 || b[0] == 0xFF || int(b[0]) >= len(b) {
+			return 0, fmt.Errorf("Invalid curve OID")
+		}
+
+		length = 1 + int(b[0])
+		return pgpCurveSizes[hex.EncodeToString(b[1:length])], nil
+	}
+
+	switch algorithm {
+	case 1, 2, 3:
+		keySize, err = readMPIs(2)
+	case 16:
+		keySize, err = readMPIs(3)
+	case 17:
+		keySize, err = readMPIs(4)
+	case 19, 22:
+		if keySize, err = readCurve(); err == nil {
+			_, err = readMPIs(1)
+		}
+	case 18:
+		if keySize, err = readCurve(); err == nil {
+			_, err = readMPIs(1)
+		}
+
+		//Followed by the KDF parameters
+		if err == nil {
+			if length >= len(b) || length+1+int(b[length]) > len(b) {
+				err = fmt.Errorf("Truncated key material")
+			} else {
+				length += 1 + int(b[length])
+			}
+		}
+	case 25, 27:
+		length, keySize = 32, 256
+	case 26:
+		length, keySize = 56, 448
+	case 28:
+		length, keySize = 57, 448
+	default:
+		err = fmt.Errorf("Unknown key algorithm %d", algorithm)
+	}
+
+	if err == nil && length > len(b) {
+		err = fmt.Errorf("Truncated key material")
+	}
+
+	return length, keySize, err
+}
+
+//parsePGPSignature parses the subpackets we care about out of a v4 or v6
+// signature packet. The signature itself is checked by verify.
+func parsePGPSignature(body []byte) (pgpSignature, error) {
+	ret := pgpSignature{raw: body}
+	if len(body) < 4 || (body[0] != 4 && body[0] != 6) {
+		return ret, fmt.Errorf("Unsupported signature version")
+	}
+
+	ret.version = body[0]
+	ret.sigType = body[1]
+	ret.pubAlgorithm = body[2]
+	ret.hashAlgorithm = body[3]
+	//The area lengths are two octets in v4 and four in v6
+	lengthSize := 2
+	if body[0] == 6 {
+		lengthSize = 4
+	}
+
+	rest := body[4:]
+	for area := 0; area < 2; area++ {
+		if len(rest) < lengthSize {
+			return ret, fmt.Errorf("Truncated signature")
+		}
+
+		var length int
+		if lengthSize == 2 {
+			length = int(binary.BigEndian.Uint16(rest))
+		} else {
+			length = int(binary.BigEndian.Uint32(rest))
+		}
+
+		rest = rest[lengthSize:]
+		if length < 0 || length > len(rest) {
+			return ret, fmt.Errorf("Truncated signature")
+		}
+
+		//Only the hashed area can be trusted for anything but the issuer
+		hashed := area == 0
+		err := ret.readSubpackets(rest[:length], hashed)
+		if err != nil {
+			return ret, err
+		}
+
+		rest = rest[length:]
+		if hashed {
+			ret.hashedLen = len(body) - len(rest)
+		}
+	}
+
+	if len(rest) < 2 {
+		return ret, fmt.Errorf("Truncated signature")
+	}
+
+	ret.hashPrefix, rest = rest[:2], rest[2:]
+	if ret.version == 6 {
+		if len(rest) < 1 || 1+int(rest[0]) > len(rest) {
+			return ret, fmt.Errorf("Truncated signature")
+		}
+
+		ret.salt, rest = rest[1:1+int(rest[0])], rest[1+int(rest[0]):]
+	}
+
+	ret.material = rest
+	return ret, nil
+}
+
+func (s *pgpSignature) readSubpackets(b []byte, hashed bool) error {
+	for len(b) > 0 {
+		var length int
+		switch first := int(b[0]); {
+		case first < 192:
+			length, b = first, b[1:]
+		case first < 255:
+			if len(b) < 2 {
+				return fmt.Errorf("Truncated subpacket")
+			}
+			length, b = (first-192)<<8+int(b[1])+192, b[2:]
+		default:
+			if len(b) < 5 {
+				return fmt.Errorf("Truncated subpacket")
+			}
+			length, b = int(binary.BigEndian.Uint32(b[1:])), b[5:]
+		}
+
+		if length < 1 || length > len(b) {
+			return fmt.Errorf("Truncated subpacket")
+		}
+
+		subpacketType, data := b[0]&0x7F, b[1:length]
+		b = b[length:]
+
+		switch subpacketType {
+		case pgpSubpacketIssuer:
+			s.issuerIDs = append(s.issuerIDs, data)
+		case pgpSubpacketIssuerFpr:
+			//Prefixed with the key version
+			if len(data) > 1 {
+				s.issuerIDs = append(s.issuerIDs, data[1:])
+			}
+		}
+
+		if !hashed {
+			continue
+		}
+
+		switch subpacketType {
+		case pgpSubpacketCreated:
+			if len(data) == 4 {
+				s.created = time.Unix(int64(binary.BigEndian.Uint32(data)), 0)
+			}
+		case pgpSubpacketExpires:
+			if len(data) == 4 {
+				s.lifetime = binary.BigEndian.Uint32(data)
+			}
+		case pgpSubpacketKeyFlags:
+			s.keyFlags = data
+		}
+	}
+
+	return nil
+}
+
+//issuedBy returns true if the signature names the given key as its issuer, or
+// names no issuer at all
+func (s pgpSignature) issuedBy(key *pgpKey) bool {
+	if len(s.issuerIDs) == 0 {
+		return true
+	}
+
+	for _, id := range s.issuerIDs {
+		if bytes.HasSuffix(key.fingerprint, id) || bytes.HasPrefix(key.fingerprint, id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//selfSignature returns the most recent signature on the key by the primary
+// key of the given types, and whether the key has been revoked. Signatures
+// which don't verify are ignored.
+func (k *pgpKey) selfSignature(primary *pgpKey, types func(byte) bool, revocation byte) (*pgpSignature, bool) {
+	var ret *pgpSignature
+	for i := range k.sigs {
+		sig := &k.sigs[i]
+		if !sig.issuedBy(primary) || !sig.verifiedOn(primary, k) {
+			continue
+		}
+
+		if sig.sigType == revocation {
+			return nil, true
+		}
+
+		if types(sig.sigType) && (ret == nil || !sig.created.Before(ret.created)) {
+			ret = sig
+		}
+	}
+
+	return ret, false
+}
+
+//verifiedOn returns false if the signature doesn't verify as being made by
+// signer over key. Signatures which use an algorithm that can't be checked
+// are marked unverified, but still count.
+func (s *pgpSignature) verifiedOn(signer, key *pgpKey) bool {
+	switch s.verify(signer, key) {
+	case pgpSigUnverifiable:
+		s.unverified = true
+	case pgpSigBad:
+		return false
+	}
+
+	return true
+}
+
+func isPGPCertification(t byte) bool {
+	return t >= pgpSigCertGeneric && t <= pgpSigCertPositive
+}
+
+//certifiedUserID returns the first of the primary key's user IDs with a
+// self-certification. Anyone can add a user ID to a key, so those without
+// one are ignored.
+func (e pgpEntity) certifiedUserID() *pgpSignature {
+	for i := range e.primary.sigs {
+		sig := &e.primary.sigs[i]
+		if isPGPCertification(sig.sigType) && sig.userID != nil &&
+			sig.issuedBy(e.primary) && sig.verifiedOn(e.primary, e.primary) {
+			return sig
+		}
+	}
+
+	return nil
+}
+
+func (e pgpEntity) cacheObjects() []CacheObject {
+	primarySig, revoked := e.primary.selfSignature(e.primary, func(t byte) bool {
+		return isPGPCertification(t) || t == pgpSigDirectKey
+	}, pgpSigKeyRevocation)
+	if revoked || primarySig == nil {
+		return nil
+	}
+
+	name := e.primary.keyIDString()
+	var emails []string
+	unverifiedName := false
+	if certification := e.certifiedUserID(); certification != nil {
+		name = string(certification.userID)
+		unverifiedName = certification.unverified
+		if addr, err := mail.ParseAddress(name); err == nil {
+			emails = []string{addr.Address}
+		}
+	}
+
+	var ret []CacheObject
+	if primarySig.lifetime != 0 {
+		obj := e.primary.cacheObject(*primarySig, pkix.Name{CommonName: name}, pkix.Name{CommonName: name})
+		obj.EmailAddresses = emails
+		obj.Unverified = obj.Unverified || unverifiedName
+		//Lets subkeys be linked to their primary key
+		obj.RawSubject = append([]byte("pgp:"), e.primary.fingerprint...)
+		ret = append(ret, obj)
+	}
+
+	for _, subkey := range e.subkeys {
+		sig, revoked := subkey.selfSignature(e.primary, func(t byte) bool {
+			return t == pgpSigSubkeyBinding
+		}, pgpSigSubkeyRevocation)
+		if revoked || sig == nil || sig.lifetime == 0 {
+			continue
+		}
+
+		obj := subkey.cacheObject(*sig, pkix.Name{CommonName: name + " (subkey)"}, pkix.Name{CommonName: name})
+		obj.EmailAddresses = emails
+		obj.Unverified = obj.Unverified || unverifiedName
+		obj.RawIssuer = append([]byte("pgp:"), e.primary.fingerprint...)
+		ret = append(ret, obj)
+	}
+
+	return ret
+}
+
+//cacheObject makes a CacheObject for the key, which expires as of the given
+// self-signature
+func (k *pgpKey) cacheObject(sig pgpSignature, subject, issuer pkix.Name) CacheObject {
+	//Each self-signature can change the expiry, so the one we used is part of
+	// what makes the object unique
+	raw := append(append([]byte{}, k.raw...), sig.raw...)
+	fingerprint := sha256.Sum256(raw)
+
+	algorithm := pgpAlgorithmNames[k.algorithm]
+	if algorithm == "" {
+		algorithm = fmt.Sprintf("unknown(%d)", k.algorithm)
+	}
+
+	return CacheObject{
+		Type:             CacheObjectTypePGPKey,
+		Subject:          subject,
+		NotAfter:         k.created.Add(time.Duration(sig.lifetime) * time.Second),
+		Issuer:           issuer,
+		NotBefore:        k.created,
+		KeyAlgorithmName: algorithm,
+		KeySize:          k.keySize,
+		Fingerprint:      hex.EncodeToString(fingerprint[:]),
+		Usages:           pgpKeyFlagStrings(sig.keyFlags),
+		KeyID:            k.keyIDString(),
+		Unverified:       sig.unverified,
+		Raw:              raw,
+	}
+}
+
+func (k *pgpKey) keyIDString() string {
+	return strings.ToUpper(hex.EncodeToString(k.fingerprint))
+}
+
+var pgpKeyFlagNames = []struct {
+	flag byte
+	name string
+}{
+	{0x01, "certify"},
+	{0x02, "sign"},
+	{0x04, "encrypt_communications"},
+	{0x08, "encrypt_storage"},
+	{0x20, "authenticate"},
+}
+
+func pgpKeyFlagStrings(flags []byte) []string {
+	ret := []string{}
+	if len(flags) == 0 {
+		return ret
+	}
+
+	for _, f := range pgpKeyFlagNames {
+		if flags[0]&f.flag != 0 {
+			ret = append(ret, f.name)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,513 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+//The keys in testdata were made with GnuPG 2.2 with the system time faked to
+// 2024-01-01T00:00:00Z, which is when all of the keys were created.
+// revoked.asc has a key revocation, and subkey.asc has its signing subkey
+// revoked. brainpool.asc is on a curve which the standard library doesn't
+// have, so its self-signature can't be checked.
+var pgpTestCreated = time.Unix(1704067200, 0)
+
+type pgpTestObject struct {
+	commonName string
+	notAfter   time.Time
+	algorithm  string
+	keySize    int
+	keyID      string
+	usages     []string
+	unverified bool
+}
+
+var pgpTestKeys = []struct {
+	file string
+	want []pgpTestObject
+}{
+	{
+		file: "expiring.asc",
+		//The signing subkey doesn't expire, so isn't included
+		want: []pgpTestObject{
+			{
+				commonName: "Expiring Example <expiring@example.com>",
+				notAfter:   time.Unix(1735603200, 0),
+				algorithm:  "EdDSA",
+				keySize:    256,
+				keyID:      "D8C30B88CA1421323285D84BC5A7EDFA26188002",
+				usages:     []string{"certify"},
+			},
+			{
+				commonName: "Expiring Example <expiring@example.com> (subkey)",
+				notAfter:   time.Unix(1706659200, 0),
+				algorithm:  "ECDH",
+				keySize:    256,
+				keyID:      "F2364ECB210CA60172A9FDD3A27978EF2E67B6BC",
+				usages:     []string{"encrypt_communications", "encrypt_storage"},
+			},
+		},
+	},
+	{
+		file: "forever.asc",
+		want: nil,
+	},
+	{
+		file: "revoked.asc",
+		want: nil,
+	},
+	{
+		file: "subkey.asc",
+		want: []pgpTestObject{
+			{
+				commonName: "Subkey Example <subkey@example.com>",
+				notAfter:   time.Unix(1767139200, 0),
+				algorithm:  "EdDSA",
+				keySize:    256,
+				keyID:      "6D0425133824A5E89437E4A7F3C52BE43D450AD3",
+				usages:     []string{"certify"},
+			},
+			{
+				commonName: "Subkey Example <subkey@example.com> (subkey)",
+				notAfter:   time.Unix(1711843200, 0),
+				algorithm:  "ECDH",
+				keySize:    256,
+				keyID:      "24751C55226F0B1D1B5C0B11D1D8B6BC8831EAAA",
+				usages:     []string{"encrypt_communications", "encrypt_storage"},
+			},
+		},
+	},
+	{
+		file: "rsa.asc",
+		want: []pgpTestObject{
+			{
+				commonName: "RSA Example <rsa@example.com>",
+				notAfter:   time.Unix(1719619200, 0),
+				algorithm:  "RSA",
+				keySize:    2048,
+				keyID:      "01983B9EC8ABDC4FCF5FAE28F5EAAAD1AB7049BB",
+				usages:     []string{"certify", "sign"},
+			},
+		},
+	},
+	{
+		file: "nistp256.asc",
+		want: []pgpTestObject{
+			{
+				commonName: "NIST Example <nist@example.com>",
+				notAfter:   time.Unix(1735732800, 0),
+				algorithm:  "ECDSA",
+				keySize:    256,
+				keyID:      "8AF9C668E80A574312D07D0F61C58FBF24E6EB9E",
+				usages:     []string{"certify", "sign"},
+			},
+		},
+	},
+	{
+		file: "dsa.asc",
+		want: []pgpTestObject{
+			{
+				commonName: "DSA Example <dsa@example.com>",
+				notAfter:   time.Unix(1735732800, 0),
+				algorithm:  "DSA",
+				keySize:    2048,
+				keyID:      "4417636ED0EEAD8F01F37524808FC4C999AC43B6",
+				usages:     []string{"certify", "sign"},
+			},
+		},
+	},
+	{
+		file: "brainpool.asc",
+		want: []pgpTestObject{
+			{
+				commonName: "Brainpool Example <brainpool@example.com>",
+				notAfter:   time.Unix(1735732800, 0),
+				algorithm:  "ECDSA",
+				keySize:    256,
+				keyID:      "6A1F9A26D522340E1DDA322C3F91F67C7A006614",
+				usages:     []string{"certify", "sign"},
+				unverified: true,
+			},
+		},
+	},
+}
+
+func readPGPTestKey(t *testing.T, file string) (armored string, binary []byte) {
+	t.Helper()
+	contents, err := ioutil.ReadFile("testdata/" + file)
+	if err != nil {
+		t.Fatalf("Could not read test key: %s", err)
+	}
+
+	block, err := armor.Decode(bytes.NewReader(contents))
+	if err != nil {
+		t.Fatalf("Could not decode test key: %s", err)
+	}
+
+	binary, err = ioutil.ReadAll(block.Body)
+	if err != nil {
+		t.Fatalf("Could not decode test key: %s", err)
+	}
+
+	return string(contents), binary
+}
+
+//pgpPacketFormats re-encode packets with each of the ways that packet lengths
+// can be given
+var pgpPacketFormats = []struct {
+	name   string
+	encode func(tag byte, body []byte) []byte
+}{
+	{"old 1 octet", func(tag byte, body []byte) []byte {
+		if len(body) > 0xFF {
+			return encodeOldPGPPacket(tag, 1, body)
+		}
+		return encodeOldPGPPacket(tag, 0, body)
+	}},
+	{"old 2 octet", func(tag byte, body []byte) []byte { return encodeOldPGPPacket(tag, 1, body) }},
+	{"old 4 octet", func(tag byte, body []byte) []byte { return encodeOldPGPPacket(tag, 2, body) }},
+	{"old indeterminate", func(tag byte, body []byte) []byte { return encodeOldPGPPacket(tag, 3, body) }},
+	{"new 1 or 2 octet", func(tag byte, body []byte) []byte {
+		//Lengths of 192 and over don't fit in one octet
+		if len(body) >= 192 {
+			return append([]byte{0xC0 | tag}, append(newPGPLength(len(body), 2), body...)...)
+		}
+		return append([]byte{0xC0 | tag}, append(newPGPLength(len(body), 1), body...)...)
+	}},
+	{"new 5 octet", func(tag byte, body []byte) []byte {
+		return append([]byte{0xC0 | tag}, append(newPGPLength(len(body), 5), body...)...)
+	}},
+	{"new partial", func(tag byte, body []byte) []byte {
+		//Chunks of 16 octets, then whatever is left
+		ret := []byte{0xC0 | tag}
+		for len(body) > 16 {
+			ret = append(append(ret, 0xE0|4), body[:16]...)
+			body = body[16:]
+		}
+		return append(append(ret, newPGPLength(len(body), 1)...), body...)
+	}},
+}
+
+func encodeOldPGPPacket(tag, lengthType byte, body []byte) []byte {
+	ret := []byte{0x80 | tag<<2 | lengthType}
+	switch lengthType {
+	case 0:
+		ret = append(ret, byte(len(body)))
+	case 1:
+		ret = append(ret, byte(len(body)>>8), byte(len(body)))
+	case 2:
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(body)))
+		ret = append(ret, length[:]...)
+	}
+
+	return append(ret, body...)
+}
+
+//newPGPLength encodes a new format length in the given number of octets
+func newPGPLength(length, octets int) []byte {
+	switch octets {
+	case 1:
+		return []byte{byte(length)}
+	case 2:
+		length -= 192
+		return []byte{byte(length>>8) + 192, byte(length)}
+	default:
+		ret := []byte{0xFF, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(ret[1:], uint32(length))
+		return ret
+	}
+}
+
+func checkPGPObjects(t *testing.T, got []CacheObject, want []pgpTestObject) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d objects, got %d", len(want), len(got))
+	}
+
+	for i, w := range want {
+		g := got[i]
+		if g.Type != CacheObjectTypePGPKey {
+			t.Errorf("Object %d: expected type %v, got %v", i, CacheObjectTypePGPKey, g.Type)
+		}
+		if g.Subject.CommonName != w.commonName {
+			t.Errorf("Object %d: expected name `%s', got `%s'", i, w.commonName, g.Subject.CommonName)
+		}
+		if !g.NotBefore.Equal(pgpTestCreated) {
+			t.Errorf("Object %d: expected not before %s, got %s", i, pgpTestCreated, g.NotBefore)
+		}
+		if !g.NotAfter.Equal(w.notAfter) {
+			t.Errorf("Object %d: expected not after %s, got %s", i, w.notAfter, g.NotAfter)
+		}
+		if g.KeyAlgorithmName != w.algorithm || g.KeySize != w.keySize {
+			t.Errorf("Object %d: expected %s %d, got %s %d", i, w.algorithm, w.keySize, g.KeyAlgorithmName, g.KeySize)
+		}
+		if g.KeyID != w.keyID {
+			t.Errorf("Object %d: expected key ID %s, got %s", i, w.keyID, g.KeyID)
+		}
+		if !reflect.DeepEqual(g.Usages, w.usages) {
+			t.Errorf("Object %d: expected usages %v, got %v", i, w.usages, g.Usages)
+		}
+		if g.Unverified != w.unverified {
+			t.Errorf("Object %d: expected unverified to be %t", i, w.unverified)
+		}
+	}
+}
+
+func TestParsePGPKeyObjects(t *testing.T) {
+	for _, key := range pgpTestKeys {
+		armored, bin := readPGPTestKey(t, key.file)
+		packets, err := readPGPPackets(bin)
+		if err != nil {
+			t.Fatalf("%s: could not read packets: %s", key.file, err)
+		}
+
+		inputs := []struct {
+			name  string
+			value string
+		}{
+			{"armored", armored},
+			{"base64", base64.StdEncoding.EncodeToString(bin)},
+		}
+
+		for _, format := range pgpPacketFormats {
+			var encoded []byte
+			for i, packet := range packets {
+				//An indeterminate length runs to the end of the input, so can
+				// only be used for the last packet
+				if format.name == "old indeterminate" && i < len(packets)-1 {
+					encoded = append(encoded, encodeOldPGPPacket(packet.tag, 2, packet.body)...)
+					continue
+				}
+
+				encoded = append(encoded, format.encode(packet.tag, packet.body)...)
+			}
+
+			inputs = append(inputs, struct {
+				name  string
+				value string
+			}{format.name, string(encoded)})
+		}
+
+		for _, input := range inputs {
+			t.Run(key.file+"/"+input.name, func(t *testing.T) {
+				checkPGPObjects(t, parsePGPKeyObjects(input.value), key.want)
+			})
+		}
+	}
+}
+
+func TestParsePGPKeyObjectsMultipleKeys(t *testing.T) {
+	var value []byte
+	var want []pgpTestObject
+	for _, key := range pgpTestKeys {
+		_, bin := readPGPTestKey(t, key.file)
+		value = append(value, bin...)
+		want = append(want, key.want...)
+	}
+
+	checkPGPObjects(t, parsePGPKeyObjects(string(value)), want)
+}
+
+func TestParsePGPKeyObjectsTruncated(t *testing.T) {
+	_, bin := readPGPTestKey(t, "expiring.asc")
+	packets, err := readPGPPackets(bin)
+	if err != nil {
+		t.Fatalf("Could not read packets: %s", err)
+	}
+
+	primary := packets[0].body
+	tests := []struct {
+		name  string
+		value []byte
+	}{
+		{"no packet length", []byte{0x98}},
+		{"short old 2 octet length", []byte{0x99, 0x00}},
+		{"short old 4 octet length", []byte{0x9A, 0x00, 0x00}},
+		{"short new 2 octet length", []byte{0xC6, 0xC0}},
+		{"short new 5 octet length", []byte{0xC6, 0xFF, 0x00, 0x00}},
+		{"new partial length with no more", []byte{0xC6, 0xE1, 0x04, 0x04}},
+		{"packet shorter than its length", bin[:len(primary)]},
+		{"truncated key header", encodeOldPGPPacket(pgpTagPublicKey, 0, primary[:5])},
+		{"truncated key material", encodeOldPGPPacket(pgpTagPublicKey, 0, primary[:len(primary)-1])},
+		{"truncated curve OID", encodeOldPGPPacket(pgpTagPublicKey, 0, primary[:8])},
+		{"packet with no tag bit", append([]byte{0x18}, bin[1:]...)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parsePGPKeyObjects(string(test.value)); len(got) != 0 {
+				t.Errorf("Expected no objects, got %d", len(got))
+			}
+		})
+	}
+
+	//Cutting the key off anywhere must not panic, and anywhere other than the
+	// end of a packet must give nothing
+	boundaries := map[int]bool{}
+	offset := 0
+	for _, packet := range packets {
+		offset += 2 + len(packet.body)
+		boundaries[offset] = true
+	}
+
+	for i := 0; i < len(bin); i++ {
+		got := parsePGPKeyObjects(string(bin[:i]))
+		if !boundaries[i] && len(got) != 0 {
+			t.Errorf("Cut at %d of %d: expected no objects, got %d", i, len(bin), len(got))
+		}
+	}
+}
+
+func TestParsePGPSignatureTruncated(t *testing.T) {
+	_, bin := readPGPTestKey(t, "expiring.asc")
+	packets, err := readPGPPackets(bin)
+	if err != nil {
+		t.Fatalf("Could not read packets: %s", err)
+	}
+
+	sig := packets[2].body
+	if _, err := parsePGPSignature(sig); err != nil {
+		t.Fatalf("Could not parse untruncated signature: %s", err)
+	}
+
+	hashedLen := int(binary.BigEndian.Uint16(sig[4:]))
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"empty", nil},
+		{"unsupported version", append([]byte{3}, sig[1:]...)},
+		{"no hashed area length", sig[:5]},
+		{"short hashed area", sig[:6+hashedLen-1]},
+		{"no unhashed area length", sig[:6+hashedLen]},
+		{"bad subpacket length", append(append([]byte{}, sig[:4]...), 0x00, 0x02, 0x05, 0x02, 0x00, 0x00)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parsePGPSignature(test.body); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+//setPGPSubpacket returns a copy of the signature with the value of the given
+// hashed subpacket replaced. The subpacket must already be there, with a one
+// octet length.
+func setPGPSubpacket(t *testing.T, sig []byte, subpacketType byte, value []byte) []byte {
+	t.Helper()
+	ret := append([]byte{}, sig...)
+	hashed := ret[6 : 6+int(binary.BigEndian.Uint16(ret[4:]))]
+	for len(hashed) > 0 {
+		length := int(hashed[0])
+		if hashed[1]&0x7F == subpacketType && length == 1+len(value) {
+			copy(hashed[2:], value)
+			return ret
+		}
+
+		hashed = hashed[1+length:]
+	}
+
+	t.Fatalf("Signature has no subpacket of type %d", subpacketType)
+	return nil
+}
+
+func TestParsePGPKeyObjectsForged(t *testing.T) {
+	_, bin := readPGPTestKey(t, "expiring.asc")
+	packets, err := readPGPPackets(bin)
+	if err != nil {
+		t.Fatalf("Could not read packets: %s", err)
+	}
+
+	//The packets are the primary key, its user ID and the certification of
+	// that, and then the subkey and its binding signature
+	primary, subkey := pgpTestKeys[0].want[0], pgpTestKeys[0].want[1]
+	later := make([]byte, 4)
+	binary.BigEndian.PutUint32(later, 10*365*24*60*60)
+	forgeLifetime := func(sig []byte) []byte {
+		return setPGPSubpacket(t, sig, pgpSubpacketExpires, later)
+	}
+
+	newer := make([]byte, 4)
+	binary.BigEndian.PutUint32(newer, uint32(pgpTestCreated.Unix()+60))
+	forgeNewer := func(sig []byte) []byte {
+		return setPGPSubpacket(t, forgeLifetime(sig), pgpSubpacketCreated, newer)
+	}
+
+	tests := []struct {
+		name    string
+		packets []pgpPacket
+		want    []pgpTestObject
+	}{
+		{
+			name: "changed expiry",
+			packets: []pgpPacket{
+				packets[0], packets[1], {pgpTagSignature, forgeLifetime(packets[2].body)}, packets[3], packets[4],
+			},
+			//With no valid self-signature, the subkey can't be trusted either
+			want: nil,
+		},
+		{
+			name: "newer certification",
+			packets: []pgpPacket{
+				packets[0], packets[1], packets[2], {pgpTagSignature, forgeNewer(packets[2].body)}, packets[3], packets[4],
+			},
+			want: []pgpTestObject{primary, subkey},
+		},
+		{
+			name: "newer subkey binding",
+			packets: []pgpPacket{
+				packets[0], packets[1], packets[2], packets[3], packets[4], {pgpTagSignature, forgeNewer(packets[4].body)},
+			},
+			want: []pgpTestObject{primary, subkey},
+		},
+		{
+			name: "changed subkey binding",
+			packets: []pgpPacket{
+				packets[0], packets[1], packets[2], packets[3], {pgpTagSignature, forgeLifetime(packets[4].body)},
+			},
+			want: []pgpTestObject{primary},
+		},
+		{
+			name: "unsigned user ID first",
+			packets: []pgpPacket{
+				packets[0], {pgpTagUserID, []byte("Mallory <mallory@example.com>")}, packets[1], packets[2], packets[3], packets[4],
+			},
+			want: []pgpTestObject{primary, subkey},
+		},
+		{
+			name: "certification moved to another user ID",
+			packets: []pgpPacket{
+				packets[0], {pgpTagUserID, []byte("Mallory <mallory@example.com>")}, packets[2], packets[3], packets[4],
+			},
+			want: nil,
+		},
+		{
+			name: "subkey binding moved to another subkey",
+			packets: []pgpPacket{
+				packets[0], packets[1], packets[2], {pgpTagPublicSubkey, packets[0].body}, packets[4],
+			},
+			want: []pgpTestObject{primary},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var value []byte
+			for _, packet := range test.packets {
+				value = append(value, encodeOldPGPPacket(packet.tag, 2, packet.body)...)
+			}
+
+			checkPGPObjects(t, parsePGPKeyObjects(string(value)), test.want)
+		})
+	}
+}
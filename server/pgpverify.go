@@ -0,0 +1,268 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math/big"
+)
+
+//The outcomes of checking an OpenPGP signature
+const (
+	pgpSigVerified = iota
+	//pgpSigUnverifiable means the signature uses a hash, key algorithm or
+	// curve which we have no implementation of
+	pgpSigUnverifiable
+	pgpSigBad
+)
+
+var pgpHashes = map[byte]crypto.Hash{
+	2:  crypto.SHA1,
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+}
+
+//pgpECDSACurves are the curves that ECDSA keys can be on which the standard
+// library implements, keyed by the hex of their OID
+var pgpECDSACurves = map[string]elliptic.Curve{
+	"2a8648ce3d030107": elliptic.P256(),
+	"2b81040022":       elliptic.P384(),
+	"2b81040023":       elliptic.P521(),
+}
+
+const pgpEd25519OID = "2b06010401da470f01"
+
+var errTruncatedPGPMaterial = fmt.Errorf("Truncated key or signature material")
+
+//verify checks that the signature was made by signer over key, which for
+// certifications includes the user ID that the signature was found after.
+// Only the signature types which say something about a key's expiry or
+// revocation are understood.
+func (s *pgpSignature) verify(signer, key *pgpKey) int {
+	hashAlgorithm, found := pgpHashes[s.hashAlgorithm]
+	if !found || !hashAlgorithm.Available() {
+		return pgpSigUnverifiable
+	}
+
+	if s.version != signer.version || s.version != key.version {
+		return pgpSigBad
+	}
+
+	h := hashAlgorithm.New()
+	h.Write(s.salt)
+	switch {
+	case isPGPCertification(s.sigType):
+		if s.userID == nil {
+			return pgpSigBad
+		}
+
+		writePGPKeyForHash(h, signer)
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(s.userID)))
+		h.Write([]byte{0xB4})
+		h.Write(length[:])
+		h.Write(s.userID)
+	case s.sigType == pgpSigDirectKey, s.sigType == pgpSigKeyRevocation:
+		writePGPKeyForHash(h, key)
+	case s.sigType == pgpSigSubkeyBinding, s.sigType == pgpSigSubkeyRevocation:
+		writePGPKeyForHash(h, signer)
+		writePGPKeyForHash(h, key)
+	default:
+		return pgpSigBad
+	}
+
+	h.Write(s.raw[:s.hashedLen])
+	var trailer [6]byte
+	trailer[0], trailer[1] = s.version, 0xFF
+	binary.BigEndian.PutUint32(trailer[2:], uint32(s.hashedLen))
+	h.Write(trailer[:])
+	digest := h.Sum(nil)
+
+	if !bytes.Equal(digest[:2], s.hashPrefix) {
+		return pgpSigBad
+	}
+
+	if s.pubAlgorithm != signer.algorithm {
+		return pgpSigBad
+	}
+
+	return verifyPGPSignatureMaterial(signer, hashAlgorithm, digest, s.material)
+}
+
+//writePGPKeyForHash writes the public key packet as it is hashed for a
+// signature, which is with an old format packet header for v4 keys
+func writePGPKeyForHash(h hash.Hash, k *pgpKey) {
+	if k.version == 4 {
+		h.Write([]byte{0x99, byte(len(k.raw) >> 8), byte(len(k.raw))})
+	} else {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(k.raw)))
+		h.Write([]byte{0x9B})
+		h.Write(length[:])
+	}
+
+	h.Write(k.raw)
+}
+
+func verifyPGPSignatureMaterial(signer *pgpKey, hashAlgorithm crypto.Hash, digest, sig []byte) int {
+	keyMPIs := &pgpMPIReader{b: signer.material}
+	sigMPIs := &pgpMPIReader{b: sig}
+	switch signer.algorithm {
+	case 1, 3:
+		n, e := keyMPIs.bigInt(), keyMPIs.bigInt()
+		s := sigMPIs.bytes()
+		if keyMPIs.err != nil || sigMPIs.err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return pgpSigBad
+		}
+
+		pub := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		//Leading zeros are dropped from MPIs, but not from PKCS #1 signatures
+		size := (n.BitLen() + 7) / 8
+		if len(s) > size {
+			return pgpSigBad
+		}
+
+		s = append(make([]byte, size-len(s)), s...)
+		if rsa.VerifyPKCS1v15(pub, hashAlgorithm, digest, s) != nil {
+			return pgpSigBad
+		}
+
+	case 17:
+		pub := &dsa.PublicKey{}
+		pub.P, pub.Q, pub.G, pub.Y = keyMPIs.bigInt(), keyMPIs.bigInt(), keyMPIs.bigInt(), keyMPIs.bigInt()
+		r, s := sigMPIs.bigInt(), sigMPIs.bigInt()
+		if keyMPIs.err != nil || sigMPIs.err != nil {
+			return pgpSigBad
+		}
+
+		//The hash is cut down to the size of q
+		if size := pub.Q.BitLen() / 8; len(digest) > size {
+			digest = digest[:size]
+		}
+
+		if !dsa.Verify(pub, digest, r, s) {
+			return pgpSigBad
+		}
+
+	case 19:
+		curve, found := pgpECDSACurves[keyMPIs.curve()]
+		if keyMPIs.err != nil {
+			return pgpSigBad
+		}
+
+		if !found {
+			return pgpSigUnverifiable
+		}
+
+		x, y := elliptic.Unmarshal(curve, keyMPIs.bytes())
+		r, s := sigMPIs.bigInt(), sigMPIs.bigInt()
+		if keyMPIs.err != nil || sigMPIs.err != nil || x == nil {
+			return pgpSigBad
+		}
+
+		if !ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, digest, r, s) {
+			return pgpSigBad
+		}
+
+	case 22:
+		curve := keyMPIs.curve()
+		point := keyMPIs.bytes()
+		if keyMPIs.err != nil {
+			return pgpSigBad
+		}
+
+		if curve != pgpEd25519OID {
+			return pgpSigUnverifiable
+		}
+
+		//The point is prefixed with 0x40 to say that it's in native format
+		if len(point) != 1+ed25519.PublicKeySize || point[0] != 0x40 {
+			return pgpSigBad
+		}
+
+		r, s := sigMPIs.bytes(), sigMPIs.bytes()
+		if sigMPIs.err != nil || len(r) > 32 || len(s) > 32 {
+			return pgpSigBad
+		}
+
+		native := make([]byte, ed25519.SignatureSize)
+		copy(native[32-len(r):32], r)
+		copy(native[64-len(s):], s)
+		if !ed25519.Verify(ed25519.PublicKey(point[1:]), digest, native) {
+			return pgpSigBad
+		}
+
+	case 27:
+		if len(signer.material) != ed25519.PublicKeySize || len(sig) != ed25519.SignatureSize {
+			return pgpSigBad
+		}
+
+		if !ed25519.Verify(ed25519.PublicKey(signer.material), digest, sig) {
+			return pgpSigBad
+		}
+
+	default:
+		return pgpSigUnverifiable
+	}
+
+	return pgpSigVerified
+}
+
+//pgpMPIReader reads the multiprecision integers and curve OIDs that make up
+// OpenPGP key and signature material. Once there is an error, everything
+// after it reads as empty.
+type pgpMPIReader struct {
+	b   []byte
+	err error
+}
+
+func (r *pgpMPIReader) bytes() []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if len(r.b) < 2 {
+		r.err = errTruncatedPGPMaterial
+		return nil
+	}
+
+	length := (int(binary.BigEndian.Uint16(r.b)) + 7) / 8
+	if 2+length > len(r.b) {
+		r.err = errTruncatedPGPMaterial
+		return nil
+	}
+
+	ret := r.b[2 : 2+length]
+	r.b = r.b[2+length:]
+	return ret
+}
+
+func (r *pgpMPIReader) bigInt() *big.Int {
+	return new(big.Int).SetBytes(r.bytes())
+}
+
+//curve returns the hex of a curve OID
+func (r *pgpMPIReader) curve() string {
+	if r.err != nil {
+		return ""
+	}
+
+	if len(r.b) < 1 || 1+int(r.b[0]) > len(r.b) {
+		r.err = errTruncatedPGPMaterial
+		return ""
+	}
+
+	ret := hex.EncodeToString(r.b[1 : 1+int(r.b[0])])
+	r.b = r.b[1+int(r.b[0]):]
+	return ret
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/doomsday-project/doomsday/client/doomsday"
+)
+
+//PolicyConfig turns on the policy rules which each cert is checked against.
+// Rules which are left at their zero value are not checked.
+type PolicyConfig struct {
+	MinRSAKeySize            int      `yaml:"min_rsa_key_size"`
+	DisallowSHA1             bool     `yaml:"disallow_sha1"`
+	MaxValidityDays          int      `yaml:"max_validity_days"`
+	RequireSANs              bool     `yaml:"require_sans"`
+	DisallowWildcards        bool     `yaml:"disallow_wildcards"`
+	DisallowSelfSignedLeaves bool     `yaml:"disallow_self_signed_leaves"`
+	AllowedIssuers           []string `yaml:"allowed_issuers"`
+}
+
+const (
+	PolicyRuleWeakRSAKey       = "weak_rsa_key"
+	PolicyRuleSHA1Signature    = "sha1_signature"
+	PolicyRuleLongValidity     = "long_validity"
+	PolicyRuleMissingSANs      = "missing_sans"
+	PolicyRuleWildcard         = "wildcard"
+	PolicyRuleSelfSignedLeaf   = "self_signed_leaf"
+	PolicyRuleDisallowedIssuer = "disallowed_issuer"
+)
+
+//PolicyRules are the names of all of the rules that a finding can be for
+var PolicyRules = []string{
+	PolicyRuleWeakRSAKey,
+	PolicyRuleSHA1Signature,
+	PolicyRuleLongValidity,
+	PolicyRuleMissingSANs,
+	PolicyRuleWildcard,
+	PolicyRuleSelfSignedLeaf,
+	PolicyRuleDisallowedIssuer,
+}
+
+//PolicyFinding is a violation of a policy rule by a cert
+type PolicyFinding struct {
+	Rule    string
+	Message string
+}
+
+type policyCheck func(cert *x509.Certificate) (string, bool)
+
+type policyRule struct {
+	name  string
+	check policyCheck
+}
+
+//Policy checks certs against a set of rules. A nil Policy has no rules.
+type Policy struct {
+	rules []policyRule
+}
+
+func NewPolicy(conf PolicyConfig) (*Policy, error) {
+	ret := &Policy{}
+	add := func(name string, check policyCheck) {
+		ret.rules = append(ret.rules, policyRule{name: name, check: check})
+	}
+
+	if conf.MinRSAKeySize < 0 {
+		return nil, fmt.Errorf("min_rsa_key_size cannot be negative")
+	}
+
+	if conf.MaxValidityDays < 0 {
+		return nil, fmt.Errorf("max_validity_days cannot be negative")
+	}
+
+	if conf.MinRSAKeySize > 0 {
+		add(PolicyRuleWeakRSAKey, func(cert *x509.Certificate) (string, bool) {
+			key, isRSA := cert.PublicKey.(*rsa.PublicKey)
+			if !isRSA || key.N.BitLen() >= conf.MinRSAKeySize {
+				return "", false
+			}
+
+			return fmt.Sprintf("RSA key is %d bits, but must be at least %d", key.N.BitLen(), conf.MinRSAKeySize), true
+		})
+	}
+
+	if conf.DisallowSHA1 {
+		add(PolicyRuleSHA1Signature, func(cert *x509.Certificate) (string, bool) {
+			//Self-signed certs are trusted directly, so their signature doesn't matter
+			if isSelfSigned(cert) {
+				return "", false
+			}
+
+			switch cert.SignatureAlgorithm {
+			case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1,
+				x509.MD5WithRSA, x509.MD2WithRSA:
+				return fmt.Sprintf("Signed with %s", cert.SignatureAlgorithm), true
+			}
+
+			return "", false
+		})
+	}
+
+	if conf.MaxValidityDays > 0 {
+		maxValidity := time.Duration(conf.MaxValidityDays) * 24 * time.Hour
+		add(PolicyRuleLongValidity, func(cert *x509.Certificate) (string, bool) {
+			validity := cert.NotAfter.Sub(cert.NotBefore)
+			if cert.IsCA || validity <= maxValidity {
+				return "", false
+			}
+
+			return fmt.Sprintf("Valid for %d days, but may be valid for at most %d",
+				int(validity.Hours()/24), conf.MaxValidityDays), true
+		})
+	}
+
+	if conf.RequireSANs {
+		add(PolicyRuleMissingSANs, func(cert *x509.Certificate) (string, bool) {
+			if cert.IsCA || len(cert.DNSNames)+len(cert.IPAddresses)+len(cert.URIs)+len(cert.EmailAddresses) > 0 {
+				return "", false
+			}
+
+			return "Has no subject alternative names", true
+		})
+	}
+
+	if conf.DisallowWildcards {
+		add(PolicyRuleWildcard, func(cert *x509.Certificate) (string, bool) {
+			wildcards := []string{}
+			for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+				if strings.Contains(name, "*") {
+					wildcards = append(wildcards, name)
+				}
+			}
+
+			if len(wildcards) == 0 {
+				return "", false
+			}
+
+			return fmt.Sprintf("Uses wildcard names: %s", strings.Join(wildcards, ", ")), true
+		})
+	}
+
+	if conf.DisallowSelfSignedLeaves {
+		add(PolicyRuleSelfSignedLeaf, func(cert *x509.Certificate) (string, bool) {
+			if cert.IsCA || !isSelfSigned(cert) {
+				return "", false
+			}
+
+			return "Is a self-signed leaf certificate", true
+		})
+	}
+
+	if len(conf.AllowedIssuers) > 0 {
+		add(PolicyRuleDisallowedIssuer, func(cert *x509.Certificate) (string, bool) {
+			for _, allowed := range conf.AllowedIssuers {
+				if allowed == cert.Issuer.String() || allowed == cert.Issuer.CommonName {
+					return "", false
+				}
+			}
+
+			return fmt.Sprintf("Issuer `%s' is not in the allowed list", cert.Issuer), true
+		})
+	}
+
+	return ret, nil
+}
+
+//Evaluate returns the findings for each rule that the cert violates
+func (p *Policy) Evaluate(cert *x509.Certificate) []PolicyFinding {
+	if p == nil {
+		return nil
+	}
+
+	var ret []PolicyFinding
+	for _, rule := range p.rules {
+		if message, violated := rule.check(cert); violated {
+			ret = append(ret, PolicyFinding{Rule: rule.name, Message: message})
+		}
+	}
+
+	return ret
+}
+
+//isSelfSigned returns true if the cert names itself as its issuer. The
+// signature isn't checked, as x509 refuses to check signatures from non-CA
+// certs, which are exactly the ones we're interested in.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return string(cert.RawSubject) == string(cert.RawIssuer) &&
+		(len(cert.AuthorityKeyId) == 0 || string(cert.AuthorityKeyId) == string(cert.SubjectKeyId))
+}
+
+//isPolicyRule returns true if the name is that of a policy rule
+func isPolicyRule(name string) bool {
+	for _, rule := range PolicyRules {
+		if rule == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+//hasFinding returns true if the item has a finding for any of the given rules.
+// The rule "any" matches any finding.
+func hasFinding(item doomsday.CacheItem, rules []string) bool {
+	for _, finding := range item.Findings {
+		for _, rule := range rules {
+			if rule == "any" || rule == finding.Rule {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/doomsday-project/doomsday/client/doomsday"
 	"github.com/doomsday-project/doomsday/server/logger"
 )
 
@@ -79,20 +80,28 @@ type managerTask struct {
 	reason         runReason
 	state          taskState
 	assignedWorker *taskWorker
+	startedAt      time.Time
+	//foldedInto is the ID of the identical task which was about to run when
+	// this one became ready, if this was skipped because of it
+	foldedInto *uint
 }
 
 func (m *managerTask) durationUntil() time.Duration {
 	return m.runTime.Sub(time.Now())
 }
 
-func (m *managerTask) run(cache *Cache, log *logger.Logger) {
+//run performs the task. If it is a refresh, the results of the populate are
+// returned.
+func (m *managerTask) run(cache *Cache, history *History, log *logger.Logger) (*PopulateStats, error) {
 	switch m.kind {
 	case queueTaskKindAuth:
-		m.source.Auth(log)
+		return nil, m.source.Auth(log)
 
 	case queueTaskKindRefresh:
-		m.source.Refresh(cache, log)
+		return m.source.Refresh(cache, history, log)
 	}
+
+	return nil, nil
 }
 
 type managerTasks []managerTask
@@ -104,26 +113,34 @@ type taskQueue struct {
 	cond        *sync.Cond
 	log         *logger.Logger
 	globalCache *Cache
+	history     *History
 	numWorkers  uint
 	workers     []*taskWorker
 	nextTaskID  uint
+	//afterRefresh, if set, is called by a worker each time it finishes a
+	// refresh task
+	afterRefresh func()
+	//finished holds the outcomes of the most recently finished tasks, oldest
+	// first
+	finished []TaskStatus
 }
 
-func newTaskQueue(cache *Cache, numWorkers uint, log *logger.Logger) *taskQueue {
+func newTaskQueue(cache *Cache, history *History, numWorkers uint, log *logger.Logger) *taskQueue {
 	lock := &sync.Mutex{}
 	return &taskQueue{
 		lock:        lock,
 		log:         log,
 		cond:        sync.NewCond(lock),
 		globalCache: cache,
+		history:     history,
 		numWorkers:  numWorkers,
 	}
 }
 
 //enqueue puts a task into the queue, unique by the tuple source, taskType. If
 //there already exists a task for this source/taskType, it will be removed and
-//replaced with this new one atomically.
-func (t *taskQueue) enqueue(task managerTask) {
+//replaced with this new one atomically. The ID given to the task is returned.
+func (t *taskQueue) enqueue(task managerTask) uint {
 	t.lock.Lock()
 	task.id = t.nextTaskID
 	t.nextTaskID++
@@ -143,10 +160,14 @@ func (t *taskQueue) enqueue(task managerTask) {
 			return
 		}
 
-		if t.data.sameTaskExistsAndReady(foundTask) || t.running.sameTaskExistsAndReady(foundTask) {
-			t.log.WriteF("Marking %s %s task for backend `%s' as to skip (id %d)",
-				foundTask.reason, foundTask.kind, foundTask.source.Core.Name, task.id)
+		//A running task may have started before this one was requested, so only
+		// an identical task which has yet to start can stand in for this one
+		if other := t.data.findSameReadyTask(foundTask); other != nil {
+			t.log.WriteF("Marking %s %s task for backend `%s' as to skip in favour of task %d (id %d)",
+				foundTask.reason, foundTask.kind, foundTask.source.Core.Name, other.id, task.id)
 			foundTask.state = queueTaskStateSkip
+			otherID := other.id
+			foundTask.foldedInto = &otherID
 		} else {
 			t.log.WriteF("Marking %s %s task for backend `%s' as ready (id %d)",
 				foundTask.reason, foundTask.kind, foundTask.source.Core.Name, task.id)
@@ -156,6 +177,8 @@ func (t *taskQueue) enqueue(task managerTask) {
 		t.data.sort()
 		t.cond.Signal()
 	})
+
+	return task.id
 }
 
 func (t managerTasks) idxWithID(id uint) int {
@@ -208,17 +231,18 @@ func (t managerTasks) findTaskWithID(id uint) *managerTask {
 //no lock
 //considered the same task if the associated source core has the same name, and
 // the kind of task is the same. Considered ready if the ready member of the task
-// is true.
-func (t managerTasks) sameTaskExistsAndReady(task *managerTask) bool {
+// is true. Returns nil if there is no such task. The same caveats apply to the
+// returned pointer as to findTaskWithID.
+func (t managerTasks) findSameReadyTask(task *managerTask) *managerTask {
 	for i := range t {
 		if t[i].source.Core.Name == task.source.Core.Name &&
 			t[i].kind == task.kind &&
 			t[i].state == queueTaskStateReady {
-			return true
+			return &t[i]
 		}
 	}
 
-	return false
+	return nil
 }
 
 func (t *managerTasks) deleteTaskWithID(id uint) {
@@ -229,9 +253,31 @@ func (t *managerTasks) deleteTaskWithID(id uint) {
 	}
 }
 
-func (t *taskQueue) dequeueNoLock() managerTask {
-	ret := t.data[0]
-	t.data[0] = t.data[len(t.data)-1]
+//nextRunnableIdxNoLock returns the index in the queue of the next task for a
+// worker to take, or -1 if there is none. A ready task is held back while the
+// same task is running, so that a backend is never refreshed or authenticated
+// by two workers at once.
+func (t *taskQueue) nextRunnableIdxNoLock() int {
+	for i := range t.data {
+		switch t.data[i].state {
+		case queueTaskStateSkip:
+			return i
+		case queueTaskStateReady:
+			if t.running.findSameReadyTask(&t.data[i]) == nil {
+				return i
+			}
+		default:
+			//Pending tasks sort after all of the others
+			return -1
+		}
+	}
+
+	return -1
+}
+
+func (t *taskQueue) dequeueNoLock(idx int) managerTask {
+	ret := t.data[idx]
+	t.data[idx] = t.data[len(t.data)-1]
 	t.data = t.data[:len(t.data)-1]
 	t.data.sort()
 
@@ -243,7 +289,7 @@ func (t *taskQueue) empty() bool {
 }
 
 func (t *taskQueue) start() {
-	workerFactory := newTaskWorkerFactory(t, t.globalCache, t.log)
+	workerFactory := newTaskWorkerFactory(t, t.globalCache, t.history, t.log)
 	for i := uint(0); i < t.numWorkers; i++ {
 		t.workers = append(t.workers, workerFactory.newWorker())
 		t.workers[i].consumeScheduler()
@@ -326,3 +372,144 @@ func (t *taskQueue) dumpStateNoLock() SchedulerState {
 
 	return ret
 }
+
+//maxFinishedTasks is how many finished tasks are remembered so that their
+// outcomes can be looked up
+const maxFinishedTasks = 1000
+
+const (
+	TaskStatePending = "pending"
+	TaskStateRunning = "running"
+	TaskStateDone    = "done"
+	TaskStateFailed  = "failed"
+	//TaskStateSkipped means the task was not run because the same kind of task
+	// for the same backend was about to run, and the outcome of that task is
+	// no longer known
+	TaskStateSkipped = "skipped"
+)
+
+//TaskStatus is where a task is in its life, and its outcome if it has finished
+type TaskStatus struct {
+	ID         uint
+	Backend    string
+	Kind       string
+	Reason     string
+	State      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Err        error
+	//Stats are the results of the populate, if this was a refresh which got
+	// that far
+	Stats *PopulateStats
+	//FoldedInto is the ID of the task which was run in place of this one, if
+	// any. The outcome of that task is reported as the outcome of this one.
+	FoldedInto *uint
+}
+
+func newTaskStatus(task *managerTask, state string) TaskStatus {
+	return TaskStatus{
+		ID:         task.id,
+		Backend:    task.source.Core.Name,
+		Kind:       task.kind.String(),
+		Reason:     task.reason.String(),
+		State:      state,
+		StartedAt:  task.startedAt,
+		FoldedInto: task.foldedInto,
+	}
+}
+
+//finishNoLock records the outcome of a task which is no longer running or
+// waiting to run
+func (t *taskQueue) finishNoLock(status TaskStatus) {
+	t.finished = append(t.finished, status)
+	if overflow := len(t.finished) - maxFinishedTasks; overflow > 0 {
+		t.finished = append([]TaskStatus{}, t.finished[overflow:]...)
+	}
+}
+
+//taskStatus returns the status of the task with the given ID. The second
+// return value is false if no task with that ID is known, either because it
+// never existed or because it finished too long ago to be remembered.
+func (t *taskQueue) taskStatus(id uint) (TaskStatus, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	status, found := t.taskStatusNoLock(id)
+	if found && status.FoldedInto != nil {
+		//The task this was folded into was yet to start when this one became
+		// ready, so its outcome reflects everything from before this request
+		if into, found := t.taskStatusNoLock(*status.FoldedInto); found {
+			into.ID, into.Reason, into.FoldedInto = status.ID, status.Reason, status.FoldedInto
+			return into, true
+		}
+	}
+
+	return status, found
+}
+
+func (t *taskQueue) taskStatusNoLock(id uint) (TaskStatus, bool) {
+	if task := t.running.findTaskWithID(id); task != nil {
+		return newTaskStatus(task, TaskStateRunning), true
+	}
+
+	if task := t.data.findTaskWithID(id); task != nil {
+		return newTaskStatus(task, TaskStatePending), true
+	}
+
+	for i := len(t.finished) - 1; i >= 0; i-- {
+		if t.finished[i].ID == id {
+			return t.finished[i], true
+		}
+	}
+
+	return TaskStatus{}, false
+}
+
+//newTaskItem converts a TaskStatus into its API representation
+func newTaskItem(status TaskStatus) doomsday.Task {
+	unix := func(t time.Time) int64 {
+		if t.IsZero() {
+			return 0
+		}
+
+		return t.Unix()
+	}
+
+	ret := doomsday.Task{
+		ID:         status.ID,
+		Backend:    status.Backend,
+		Kind:       status.Kind,
+		Reason:     status.Reason,
+		State:      status.State,
+		StartedAt:  unix(status.StartedAt),
+		FinishedAt: unix(status.FinishedAt),
+		FoldedInto: status.FoldedInto,
+	}
+
+	if status.Err != nil {
+		ret.Error = status.Err.Error()
+	}
+
+	if status.Stats != nil {
+		convert := func(errs []PathError) []doomsday.BackendPathError {
+			ret := []doomsday.BackendPathError{}
+			for _, pathErr := range errs {
+				ret = append(ret, doomsday.BackendPathError{Path: pathErr.Path, Message: pathErr.Err.Error()})
+			}
+
+			return ret
+		}
+
+		ret.Stats = &doomsday.TaskStats{
+			NumPaths:    status.Stats.NumPaths,
+			NumFiltered: status.Stats.NumFiltered,
+			NumSuccess:  status.Stats.NumSuccess,
+			NumCerts:    status.Stats.NumCerts,
+			NumOther:    status.Stats.NumOther,
+			Warnings:    convert(status.Stats.Warnings),
+			Errors:      convert(status.Stats.Errors),
+		}
+	}
+
+	return ret
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -39,6 +40,11 @@ func Start(conf Config) error {
 	log.WriteF("Initializing server")
 	log.WriteF("Configuring targeted storage backends")
 
+	policy, err := NewPolicy(conf.Policy)
+	if err != nil {
+		return fmt.Errorf("Error configuring policy: %s", err)
+	}
+
 	sources := make([]Source, 0, len(conf.Backends))
 	for _, b := range conf.Backends {
 		backendName := b.Name
@@ -52,19 +58,45 @@ func Start(conf Config) error {
 			return fmt.Errorf("Error configuring backend `%s': %s", b.Name, err)
 		}
 
-		thisCore := Core{Backend: thisBackend, Name: backendName}
+		interval := time.Duration(b.RefreshInterval) * time.Minute
+		if slow, isSlow := thisBackend.(storage.SlowLister); isSlow && slow.MaxListDuration() > interval {
+			return fmt.Errorf("Error configuring backend `%s': Listing its paths could take up to %s, which is longer than its refresh interval of %s",
+				b.Name, slow.MaxListDuration(), interval)
+		}
+
+		if b.Include != nil && !b.Include.Empty() {
+			log.WriteF("Backend `%s' including only paths under %v or matching %v", backendName, b.Include.Under, b.Include.Matching)
+		}
+
+		if b.Exclude != nil && !b.Exclude.Empty() {
+			log.WriteF("Backend `%s' excluding paths under %v or matching %v", backendName, b.Exclude.Under, b.Exclude.Matching)
+		}
+
+		thisCore := Core{
+			Backend:  thisBackend,
+			Name:     backendName,
+			Type:     b.Type,
+			Include:  b.Include,
+			Exclude:  b.Exclude,
+			Keystore: b.Keystore,
+			Policy:   policy,
+		}
 		thisCore.SetCache(NewCache())
 
 		sources = append(sources,
 			Source{
 				Core:         &thisCore,
-				Interval:     time.Duration(b.RefreshInterval) * time.Minute,
+				Interval:     interval,
 				authMetadata: authState,
 			},
 		)
 	}
 
-	manager := NewSourceManager(sources, log)
+	manager := NewSourceManager(sources, NewHistory(conf.Server.HistorySize), log)
+	if conf.Server.CacheFile != "" {
+		log.WriteF("Using cache file at `%s'", conf.Server.CacheFile)
+		manager.UseCacheFile(NewCacheFile(conf.Server.CacheFile))
+	}
 
 	log.WriteF("Starting background scheduler")
 
@@ -96,7 +128,23 @@ func Start(conf Config) error {
 	router.HandleFunc("/v1/auth", authorizer.LoginHandler()).Methods("POST")
 	router.HandleFunc("/v1/cache", auth(getCache(manager))).Methods("GET")
 	router.HandleFunc("/v1/cache/refresh", auth(refreshCache(manager))).Methods("POST")
+	router.HandleFunc("/v1/cache/{fingerprint}", auth(getCacheItem(manager))).Methods("GET")
+	router.HandleFunc("/v1/cache/{fingerprint}/chain", auth(getCacheItemChain(manager))).Methods("GET")
+	router.HandleFunc("/v1/history", auth(getHistory(manager))).Methods("GET")
+	if conf.Server.Metrics {
+		metricsAuth := auth
+		if conf.Server.MetricsToken != "" {
+			metricsAuth = bearerTokenHandler(conf.Server.MetricsToken)
+		}
+
+		router.HandleFunc("/metrics", metricsAuth(getMetrics(manager))).Methods("GET")
+	}
+	router.HandleFunc("/v1/tasks/{id}", auth(getTask(manager))).Methods("GET")
 	router.HandleFunc("/v1/scheduler", auth(getScheduler(manager))).Methods("GET")
+	router.HandleFunc("/v1/backends", auth(getBackends(manager))).Methods("GET")
+	router.HandleFunc("/v1/backends/{name}/refresh", auth(refreshBackend(manager))).Methods("POST")
+	router.HandleFunc("/v1/backends/{name}/auth", auth(authBackend(manager))).Methods("POST")
+	router.HandleFunc("/v1/backends/{name}/errors", auth(getBackendErrors(manager))).Methods("GET")
 
 	if len(conf.Server.Dev.Mappings) > 0 {
 		for file, servePath := range conf.Server.Dev.Mappings {
@@ -162,10 +210,20 @@ func getInfo(authType auth.AuthType) func(w http.ResponseWriter, r *http.Request
 
 func getCache(manager *SourceManager) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		items := manager.Data()
-		sort.Slice(items, func(i, j int) bool { return items[i].NotAfter < items[j].NotAfter })
+		query, err := parseCacheQuery(r.URL.Query(), time.Now())
+		if err != nil {
+			w.WriteHeader(400)
+			writeBody(w, []byte(err.Error()))
+			return
+		}
 
-		resp, err := json.Marshal(&doomsday.GetCacheResponse{Content: items})
+		items, total, next := query.apply(manager.Data())
+		resp, err := json.Marshal(&doomsday.GetCacheResponse{
+			Content:    items,
+			Warnings:   manager.Warnings(),
+			Total:      total,
+			NextCursor: next,
+		})
 		if err != nil {
 			w.WriteHeader(500)
 		} else {
@@ -176,10 +234,229 @@ func getCache(manager *SourceManager) func(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+func getHistory(manager *SourceManager) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		search := r.URL.Query().Get("search")
+		backend := r.URL.Query().Get("backend")
+
+		events := manager.History()
+		respRaw := doomsday.GetHistoryResponse{Events: []doomsday.HistoryEvent{}}
+		//Newest first
+		for i := len(events) - 1; i >= 0; i-- {
+			if (search != "" && !events[i].matches(search)) || (backend != "" && events[i].Backend != backend) {
+				continue
+			}
+
+			respRaw.Events = append(respRaw.Events, newHistoryEventItem(events[i]))
+		}
+
+		resp, err := json.Marshal(&respRaw)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		writeBody(w, resp)
+	}
+}
+
+func getCacheItem(manager *SourceManager) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		obj, found := manager.Lookup(mux.Vars(r)["fingerprint"])
+		if !found {
+			w.WriteHeader(404)
+			return
+		}
+
+		withPEM, _ := strconv.ParseBool(r.URL.Query().Get("pem"))
+		item, err := newCacheItemDetail(obj, withPEM)
+		if err != nil {
+			log.WriteF("Error getting details of cert: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		manager.ChainGraph().annotate(&item.CacheItem)
+		markStale(&item.CacheItem, manager.staleSources())
+		item.Replaces = manager.rotations()[item.Fingerprint]
+
+		resp, err := json.Marshal(item)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		writeBody(w, resp)
+	}
+}
+
+func getCacheItemChain(manager *SourceManager) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		obj, found := manager.Lookup(mux.Vars(r)["fingerprint"])
+		if !found {
+			w.WriteHeader(404)
+			return
+		}
+
+		graph := manager.ChainGraph()
+		respRaw := doomsday.GetCacheItemChainResponse{
+			Chain:      graph.items(graph.chain(obj.Fingerprint)),
+			Dependents: graph.items(graph.descendants(obj.Fingerprint)),
+		}
+
+		sort.Slice(respRaw.Dependents, func(i, j int) bool {
+			return respRaw.Dependents[i].NotAfter < respRaw.Dependents[j].NotAfter
+		})
+
+		resp, err := json.Marshal(&respRaw)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		writeBody(w, resp)
+	}
+}
+
 func refreshCache(manager *SourceManager) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		go manager.RefreshAll()
-		w.WriteHeader(204)
+		respRaw := doomsday.RefreshResponse{Tasks: []doomsday.Task{}}
+		for _, id := range manager.RefreshAll() {
+			if task, found := manager.Task(id); found {
+				respRaw.Tasks = append(respRaw.Tasks, newTaskItem(task))
+			}
+		}
+
+		resp, err := json.Marshal(&respRaw)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		writeBody(w, resp)
+	}
+}
+
+func refreshBackend(manager *SourceManager) func(w http.ResponseWriter, r *http.Request) {
+	return runBackendTask(manager, (*SourceManager).Refresh)
+}
+
+func authBackend(manager *SourceManager) func(w http.ResponseWriter, r *http.Request) {
+	return runBackendTask(manager, (*SourceManager).Auth)
+}
+
+//runBackendTask returns a handler which queues a task for the backend named in
+// the request, using the given function, and responds with the task
+func runBackendTask(manager *SourceManager, run func(*SourceManager, *Source) uint) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source := manager.Source(mux.Vars(r)["name"])
+		if source == nil {
+			w.WriteHeader(404)
+			return
+		}
+
+		task, found := manager.Task(run(manager, source))
+		if !found {
+			w.WriteHeader(500)
+			return
+		}
+
+		resp, err := json.Marshal(newTaskItem(task))
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		writeBody(w, resp)
+	}
+}
+
+func getTask(manager *SourceManager) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 0)
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+
+		task, found := manager.Task(uint(id))
+		if !found {
+			w.WriteHeader(404)
+			return
+		}
+
+		resp, err := json.Marshal(newTaskItem(task))
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		writeBody(w, resp)
+	}
+}
+
+func getBackends(manager *SourceManager) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respRaw := doomsday.GetBackendsResponse{Backends: manager.Backends()}
+		resp, err := json.Marshal(&respRaw)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		writeBody(w, resp)
+	}
+}
+
+func getBackendErrors(manager *SourceManager) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		source := manager.Source(name)
+		if source == nil {
+			w.WriteHeader(404)
+			return
+		}
+
+		pathErrs, lastErr := source.RefreshErrors()
+		respRaw := doomsday.GetBackendErrorsResponse{
+			Backend: name,
+			Errors:  []doomsday.BackendPathError{},
+		}
+
+		if lastErr != nil {
+			respRaw.LastError = lastErr.Error()
+		}
+
+		for _, pathErr := range pathErrs {
+			respRaw.Errors = append(respRaw.Errors, doomsday.BackendPathError{
+				Path:    pathErr.Path,
+				Message: pathErr.Err.Error(),
+			})
+		}
+
+		resp, err := json.Marshal(&respRaw)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		writeBody(w, resp)
 	}
 }
 
@@ -17,6 +17,13 @@ type Source struct {
 	refreshStatus RunInfo
 	authStatus    RunInfo
 	authMetadata  interface{}
+	//refreshStats are the results of the last successful refresh
+	refreshStats PopulateStats
+	//refreshErrors are the paths which failed during the last refresh
+	refreshErrors []PathError
+	//stale is true if the cache was loaded from the cache file and hasn't
+	// been refreshed since
+	stale bool
 }
 
 type RunInfo struct {
@@ -30,7 +37,10 @@ type RunTiming struct {
 	FinishedAt time.Time
 }
 
-func (s *Source) Refresh(global *Cache, log *logger.Logger) {
+//Refresh populates the source's cache from its backend, and applies the
+// changes to the global cache. The results of the populate are returned,
+// though they may be nil if it failed.
+func (s *Source) Refresh(global *Cache, history *History, log *logger.Logger) (*PopulateStats, error) {
 	log.WriteF("Running populate of `%s'", s.Core.Name)
 
 	old := s.Core.Cache()
@@ -49,21 +59,93 @@ func (s *Source) Refresh(global *Cache, log *logger.Logger) {
 
 	s.refreshStatus.LastRun.FinishedAt = time.Now()
 
+	s.refreshErrors = nil
+	if results != nil {
+		s.refreshErrors = results.Errors
+	}
+
 	if err != nil {
 		log.WriteF("Error populating info from backend `%s': %s", s.Core.Name, err)
+		for _, pathErr := range s.refreshErrors {
+			log.WriteF("Error from `%s' at `%s': %s", s.Core.Name, pathErr.Path, pathErr.Err)
+		}
 		s.refreshStatus.LastErr = err
-		return
+		return results, err
 	}
 
+	//Without a previous successful refresh to compare against, everything
+	// would look like it had just been added
+	hadBaseline := !s.refreshStatus.LastSuccess.FinishedAt.IsZero()
+
 	s.refreshStatus.LastErr = nil
 	s.refreshStatus.LastSuccess = s.refreshStatus.LastRun
+	s.stale = false
+	s.refreshStats = *results
+
+	events := global.ApplyDiff(old, s.Core.Cache())
+	if hadBaseline && history != nil {
+		history.Add(events...)
+	}
+
+	log.WriteF("Finished populate of `%s' after %s. %d/%d paths searched (%d filtered out, %d warnings). %d certs and %d other items found", s.Core.Name, time.Since(s.refreshStatus.LastRun.StartedAt), results.NumSuccess, results.NumPaths, results.NumFiltered, len(results.Warnings), results.NumCerts, results.NumOther)
+	for _, warning := range results.Warnings {
+		log.WriteF("Warning from `%s' at `%s': %s", s.Core.Name, warning.Path, warning.Err)
+	}
+
+	return results, nil
+}
+
+//RefreshErrors returns the paths that could not be fetched during the last
+// refresh, and the error from the last refresh, if any
+func (s *Source) RefreshErrors() (paths []PathError, lastErr error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.refreshErrors, s.refreshStatus.LastErr
+}
+
+//RefreshStatus returns the timings and outcome of the last refresh
+func (s *Source) RefreshStatus() RunInfo {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.refreshStatus
+}
+
+//AuthStatus returns the timings and outcome of the last authentication
+func (s *Source) AuthStatus() RunInfo {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.authStatus
+}
+
+//RefreshStats returns the results of the last successful refresh
+func (s *Source) RefreshStats() PopulateStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.refreshStats
+}
+
+//AuthExpiry returns when the auth from the last successful authentication
+// runs out. The second return value is false if it never does, or if there
+// has been no successful authentication.
+func (s *Source) AuthExpiry() (time.Time, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.authTTL == storage.TTLInfinite || s.authStatus.LastSuccess.StartedAt.IsZero() {
+		return time.Time{}, false
+	}
 
-	global.ApplyDiff(old, s.Core.Cache())
+	return s.authStatus.LastSuccess.StartedAt.Add(s.authTTL), true
+}
 
-	log.WriteF("Finished populate of `%s' after %s. %d/%d paths searched. %d certs found", s.Core.Name, time.Since(s.refreshStatus.LastRun.StartedAt), results.NumSuccess, results.NumPaths, results.NumCerts)
+//Warnings returns the warnings from the last successful refresh. If the
+// refresh after it failed, stale is true, as the warnings may no longer apply.
+func (s *Source) Warnings() (warnings []PathError, stale bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.refreshStats.Warnings, s.refreshStatus.LastErr != nil
 }
 
-func (s *Source) Auth(log *logger.Logger) {
+func (s *Source) Auth(log *logger.Logger) error {
 	log.WriteF("Starting authentication for `%s'", s.Core.Name)
 
 	s.lock.Lock()
@@ -80,7 +162,7 @@ func (s *Source) Auth(log *logger.Logger) {
 	if err != nil {
 		log.WriteF("Failed auth for `%s' after %s: %s", s.Core.Name, time.Since(s.authStatus.LastRun.StartedAt), err)
 		s.authStatus.LastErr = err
-		return
+		return err
 	}
 
 	s.authStatus.LastErr = nil
@@ -90,6 +172,7 @@ func (s *Source) Auth(log *logger.Logger) {
 	s.authMetadata = metadata
 
 	log.WriteF("Finished auth for `%s' after %s", s.Core.Name, time.Since(s.authStatus.LastRun.StartedAt))
+	return nil
 }
 
 //CalcNextAuth returns the time of the next authentication to attempt.
@@ -3,10 +3,13 @@ package server
 import (
 	"fmt"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/doomsday-project/doomsday/client/doomsday"
 	"github.com/doomsday-project/doomsday/server/logger"
+	"github.com/doomsday-project/doomsday/storage"
 )
 
 const (
@@ -19,22 +22,61 @@ type SourceManager struct {
 	queue   *taskQueue
 	log     *logger.Logger
 	global  *Cache
+	history *History
+
+	//graph is the chain graph of the global cache as of graphGeneration
+	graph           *chainGraph
+	graphGeneration uint64
+	graphLock       sync.Mutex
 }
 
-func NewSourceManager(sources []Source, log *logger.Logger) *SourceManager {
+func NewSourceManager(sources []Source, history *History, log *logger.Logger) *SourceManager {
 	if log == nil {
 		panic("No logger was given")
 	}
 
 	globalCache := NewCache()
 	//TODO: Make the number of workers configurable
-	queue := newTaskQueue(globalCache, 4, log)
+	queue := newTaskQueue(globalCache, history, 4, log)
 
 	return &SourceManager{
 		sources: sources,
 		queue:   queue,
 		log:     log,
 		global:  globalCache,
+		history: history,
+	}
+}
+
+//UseCacheFile loads the snapshot in the given file into the sources, which
+// are then marked stale until they next refresh successfully. The file is
+// saved to whenever a refresh finishes. This must be called before the
+// background scheduler is started.
+func (s *SourceManager) UseCacheFile(file *CacheFile) {
+	snapshot, err := file.Load()
+	if err != nil {
+		s.log.WriteF("Could not load cache file. Starting with an empty cache: %s", err)
+		snapshot = &cacheSnapshot{}
+	}
+
+	for i := range s.sources {
+		loaded, found := snapshot.Sources[s.sources[i].Core.Name]
+		if !found {
+			continue
+		}
+
+		s.sources[i].restore(loaded)
+		s.global.ApplyDiff(NewCache(), s.sources[i].Core.Cache())
+		s.log.WriteF("Loaded %d items for backend `%s' from cache file saved at %s",
+			len(loaded.Cache), s.sources[i].Core.Name, snapshot.SavedAt.Format(time.RFC3339))
+	}
+
+	s.history.Add(snapshot.History...)
+	s.queue.afterRefresh = func() {
+		err := file.Save(s.sources, s.history)
+		if err != nil {
+			s.log.WriteF("Could not save cache file: %s", err)
+		}
 	}
 }
 
@@ -75,35 +117,239 @@ func (s *SourceManager) BackgroundScheduler() error {
 
 func (s *SourceManager) Data() doomsday.CacheItems {
 	items := []doomsday.CacheItem{}
+	graph := s.ChainGraph()
+	stale := s.staleSources()
+	rotations := s.rotations()
+	for _, v := range graph.objs {
+		item := newCacheItem(v)
+		graph.annotate(&item)
+		markStale(&item, stale)
+		item.Replaces = rotations[item.Fingerprint]
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].NotAfter < items[j].NotAfter })
+	return items
+}
+
+//Lookup returns the cache entry for the cert with the given SHA-256 fingerprint
+func (s *SourceManager) Lookup(fingerprint string) (CacheObject, bool) {
+	fingerprint = strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
 	for _, v := range s.global.Map() {
-		paths := []doomsday.CacheItemPath{}
-		for _, path := range v.Paths {
-			paths = append(paths, doomsday.CacheItemPath{
-				Backend:  path.Source,
-				Location: path.Location,
+		if v.Fingerprint == fingerprint {
+			return v, true
+		}
+	}
+
+	return CacheObject{}, false
+}
+
+//ChainGraph links every cert in the cache to its issuers in the cache. The
+// graph is only rebuilt when the cache has changed since it was last built, so
+// it must not be modified.
+func (s *SourceManager) ChainGraph() *chainGraph {
+	s.graphLock.Lock()
+	defer s.graphLock.Unlock()
+	if s.graph != nil && s.graphGeneration == s.global.Generation() {
+		return s.graph
+	}
+
+	objs, generation := s.global.Snapshot()
+	s.graph = newChainGraph(objs)
+	s.graphGeneration = generation
+	return s.graph
+}
+
+//Source returns the source with the given name, or nil if there is none
+func (s *SourceManager) Source(name string) *Source {
+	for i := range s.sources {
+		if s.sources[i].Core.Name == name {
+			return &s.sources[i]
+		}
+	}
+
+	return nil
+}
+
+//staleSources returns the names of the sources whose caches were loaded from
+// the cache file and haven't been refreshed since
+func (s *SourceManager) staleSources() map[string]bool {
+	ret := map[string]bool{}
+	for i := range s.sources {
+		if s.sources[i].Stale() {
+			ret[s.sources[i].Core.Name] = true
+		}
+	}
+
+	return ret
+}
+
+func markStale(item *doomsday.CacheItem, staleSources map[string]bool) {
+	for i := range item.Paths {
+		item.Paths[i].Stale = staleSources[item.Paths[i].Backend]
+	}
+}
+
+//History returns the recorded history events, oldest first
+func (s *SourceManager) History() []HistoryEvent {
+	return s.history.Events()
+}
+
+//rotations returns the rotated events in the history, keyed by the
+// fingerprint of the cert which was rotated in
+func (s *SourceManager) rotations() map[string][]doomsday.HistoryEvent {
+	return rotationsByNew(s.history.Events())
+}
+
+//Warnings returns the warnings from the last successful refresh of each source
+func (s *SourceManager) Warnings() []doomsday.CacheWarning {
+	ret := []doomsday.CacheWarning{}
+	for i := range s.sources {
+		warnings, stale := s.sources[i].Warnings()
+		for _, warning := range warnings {
+			ret = append(ret, doomsday.CacheWarning{
+				Backend: s.sources[i].Core.Name,
+				Path:    warning.Path,
+				Message: warning.Err.Error(),
+				Stale:   stale,
 			})
 		}
-		items = append(items, doomsday.CacheItem{
-			Paths:      paths,
-			CommonName: v.Subject.CommonName,
-			NotAfter:   v.NotAfter.Unix(),
-		})
 	}
 
-	sort.Slice(items, func(i, j int) bool { return items[i].NotAfter < items[j].NotAfter })
-	return items
+	return ret
 }
 
-func (s *SourceManager) RefreshAll() {
-	now := time.Now()
+//Backends returns the status of each source, in the order they were
+// configured
+func (s *SourceManager) Backends() []doomsday.Backend {
+	//The next time each kind of task is scheduled for each source
+	type taskKey struct {
+		backend string
+		kind    string
+	}
+	next := map[taskKey]time.Time{}
+	running := map[taskKey]bool{}
+	state := s.SchedulerState()
+	for _, task := range state.Pending {
+		k := taskKey{backend: task.Backend, kind: task.Kind}
+		if at, found := next[k]; !found || task.At.Before(at) {
+			next[k] = task.At
+		}
+	}
+
+	for _, task := range state.Running {
+		running[taskKey{backend: task.Backend, kind: task.Kind}] = true
+	}
+
+	ret := make([]doomsday.Backend, 0, len(s.sources))
 	for i := range s.sources {
-		s.queue.enqueue(managerTask{
-			source:  &s.sources[i],
-			kind:    queueTaskKindRefresh,
-			runTime: now,
-			reason:  runReasonAdhoc,
-		})
+		source := &s.sources[i]
+		name := source.Core.Name
+		refreshKey := taskKey{backend: name, kind: queueTaskKindRefresh.String()}
+		authKey := taskKey{backend: name, kind: queueTaskKindAuth.String()}
+		stats := source.RefreshStats()
+
+		backend := doomsday.Backend{
+			Name:            name,
+			Type:            source.Core.Type,
+			RefreshInterval: int64(source.Interval / time.Second),
+			Refresh:         newBackendRunInfo(source.RefreshStatus(), running[refreshKey], next[refreshKey]),
+			Auth:            newBackendRunInfo(source.AuthStatus(), running[authKey], next[authKey]),
+			Stale:           source.Stale(),
+			NumPaths:        stats.NumPaths,
+			NumFiltered:     stats.NumFiltered,
+			NumSuccess:      stats.NumSuccess,
+			NumCerts:        stats.NumCerts,
+			NumOther:        stats.NumOther,
+			NumCached:       len(source.Core.Cache().Keys()),
+		}
+
+		if expiry, expires := source.AuthExpiry(); expires {
+			backend.AuthExpiresAt = expiry.Unix()
+		}
+
+		backend.Include = newBackendPathFilter(source.Core.Include)
+		backend.Exclude = newBackendPathFilter(source.Core.Exclude)
+
+		ret = append(ret, backend)
+	}
+
+	return ret
+}
+
+func newBackendRunInfo(info RunInfo, running bool, next time.Time) doomsday.BackendRunInfo {
+	ret := doomsday.BackendRunInfo{
+		Running: running,
 	}
+
+	unix := func(t time.Time) int64 {
+		if t.IsZero() {
+			return 0
+		}
+
+		return t.Unix()
+	}
+
+	ret.LastStarted = unix(info.LastRun.StartedAt)
+	ret.LastFinished = unix(info.LastRun.FinishedAt)
+	ret.LastSuccessStarted = unix(info.LastSuccess.StartedAt)
+	ret.LastSuccessFinished = unix(info.LastSuccess.FinishedAt)
+	ret.Next = unix(next)
+	if info.LastErr != nil {
+		ret.LastError = info.LastErr.Error()
+	}
+
+	return ret
+}
+
+//newBackendPathFilter returns nil if the filter isn't configured
+func newBackendPathFilter(filter *storage.PathFilter) *doomsday.BackendPathFilter {
+	if filter == nil || filter.Empty() {
+		return nil
+	}
+
+	return &doomsday.BackendPathFilter{
+		Under:    filter.Under,
+		Matching: filter.Matching,
+	}
+}
+
+//RefreshAll queues an adhoc refresh of every source to run now, and returns
+// the IDs of the tasks
+func (s *SourceManager) RefreshAll() []uint {
+	ret := make([]uint, 0, len(s.sources))
+	for i := range s.sources {
+		ret = append(ret, s.Refresh(&s.sources[i]))
+	}
+
+	return ret
+}
+
+//Refresh queues an adhoc refresh of the given source to run now, and returns
+// the ID of the task
+func (s *SourceManager) Refresh(source *Source) uint {
+	return s.runAdhoc(source, queueTaskKindRefresh)
+}
+
+//Auth queues an adhoc authentication of the given source to run now, and
+// returns the ID of the task
+func (s *SourceManager) Auth(source *Source) uint {
+	return s.runAdhoc(source, queueTaskKindAuth)
+}
+
+func (s *SourceManager) runAdhoc(source *Source, kind taskKind) uint {
+	return s.queue.enqueue(managerTask{
+		source:  source,
+		kind:    kind,
+		runTime: time.Now(),
+		reason:  runReasonAdhoc,
+	})
+}
+
+//Task returns the status of the task with the given ID. The second return
+// value is false if the task is not known.
+func (s *SourceManager) Task(id uint) (TaskStatus, bool) {
+	return s.queue.taskStatus(id)
 }
 
 func (s *SourceManager) SchedulerState() SchedulerState {
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+//parseSSHCertObjects finds OpenSSH certificates in the given value, which may
+// be in authorized_keys format (as in a -cert.pub file), or that base64
+// encoded. Certs which never expire are skipped.
+func parseSSHCertObjects(value string) []CacheObject {
+	certs := parseSSHCerts([]byte(value))
+	if len(certs) == 0 {
+		if decoded, ok := decodeBase64([]byte(value)); ok {
+			certs = parseSSHCerts(decoded)
+		}
+	}
+
+	var ret []CacheObject
+	for _, cert := range certs {
+		if cert.ValidBefore == ssh.CertTimeInfinity {
+			continue
+		}
+
+		ret = append(ret, newSSHCertCacheObject(cert))
+	}
+
+	return ret
+}
+
+func parseSSHCerts(b []byte) []*ssh.Certificate {
+	//Cheap check so we don't scan every value line by line
+	if !strings.Contains(string(b), "-cert-v01@openssh.com") {
+		return nil
+	}
+
+	var ret []*ssh.Certificate
+	for rest := b; len(rest) > 0; {
+		key, _, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			break
+		}
+
+		if cert, isCert := key.(*ssh.Certificate); isCert {
+			ret = append(ret, cert)
+		}
+
+		rest = next
+	}
+
+	return ret
+}
+
+//newSSHCertCacheObject extracts the information we keep about an SSH cert
+// into a CacheObject. The cert's key ID is used as its common name, and the
+// fingerprint of the CA key that signed it as its issuer.
+func newSSHCertCacheObject(cert *ssh.Certificate, paths ...PathObject) CacheObject {
+	raw := cert.Marshal()
+	fingerprint := sha256.Sum256(raw)
+	caFingerprint := ssh.FingerprintSHA256(cert.SignatureKey)
+
+	ret := CacheObject{
+		Type:             CacheObjectTypeSSHCertificate,
+		Subject:          pkix.Name{CommonName: cert.KeyId},
+		NotAfter:         time.Unix(int64(cert.ValidBefore), 0),
+		Paths:            paths,
+		Issuer:           pkix.Name{CommonName: caFingerprint},
+		SerialNumber:     new(big.Int).SetUint64(cert.Serial),
+		NotBefore:        time.Unix(int64(cert.ValidAfter), 0),
+		KeyAlgorithmName: cert.Key.Type(),
+		Fingerprint:      hex.EncodeToString(fingerprint[:]),
+		Principals:       cert.ValidPrincipals,
+		Usages:           []string{"user"},
+		Raw:              raw,
+	}
+
+	if cert.Signature != nil {
+		ret.SignatureAlgorithmName = cert.Signature.Format
+	}
+
+	if cert.CertType == ssh.HostCert {
+		ret.Usages = []string{"host"}
+	}
+
+	if key, isCryptoKey := cert.Key.(ssh.CryptoPublicKey); isCryptoKey {
+		ret.KeySize = publicKeySize(key.CryptoPublicKey())
+	}
+
+	return ret
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+var sshTestExpiry = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+//sshTestCert makes an OpenSSH cert signed by a new CA, in authorized_keys
+// format
+func sshTestCert(t *testing.T, keyID string, certType uint32, validBefore uint64) string {
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate CA key: %s", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatalf("Could not make CA signer: %s", err)
+	}
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %s", err)
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Could not make public key: %s", err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          42,
+		CertType:        certType,
+		KeyId:           keyID,
+		ValidPrincipals: []string{"alice", "bob"},
+		ValidAfter:      uint64(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Unix()),
+		ValidBefore:     validBefore,
+	}
+
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		t.Fatalf("Could not sign cert: %s", err)
+	}
+
+	return string(ssh.MarshalAuthorizedKey(cert))
+}
+
+func TestParseSSHCertObjects(t *testing.T) {
+	expiry := uint64(sshTestExpiry.Unix())
+	user := sshTestCert(t, "user-cert", ssh.UserCert, expiry)
+	host := sshTestCert(t, "host-cert", ssh.HostCert, expiry)
+	forever := sshTestCert(t, "forever", ssh.UserCert, ssh.CertTimeInfinity)
+
+	tests := []struct {
+		name  string
+		value string
+		//want is the key ID and usage of each cert expected
+		want [][2]string
+	}{
+		{name: "user cert", value: user, want: [][2]string{{"user-cert", "user"}}},
+		{name: "host cert", value: host, want: [][2]string{{"host-cert", "host"}}},
+		{
+			name:  "several certs",
+			value: user + host,
+			want:  [][2]string{{"user-cert", "user"}, {"host-cert", "host"}},
+		},
+		{
+			name:  "base64 encoded",
+			value: base64.StdEncoding.EncodeToString([]byte(host)),
+			want:  [][2]string{{"host-cert", "host"}},
+		},
+		{name: "never expires", value: forever},
+		{name: "never expires skipped", value: forever + user, want: [][2]string{{"user-cert", "user"}}},
+		{name: "plain public key", value: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBvEwXkT8Qdzuf8Y5gSPFpQZ6HhKRBGkfjETFFuVv+yN"},
+		{name: "mentions a cert type", value: "ssh-ed25519-cert-v01@openssh.com not-base64"},
+		{name: "empty", value: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objs := parseSSHCertObjects(test.value)
+			if len(objs) != len(test.want) {
+				t.Fatalf("Got %d certs, want %d", len(objs), len(test.want))
+			}
+
+			for i, obj := range objs {
+				keyID, usage := test.want[i][0], test.want[i][1]
+				if obj.Subject.CommonName != keyID {
+					t.Errorf("Cert %d: got key ID %q, want %q", i, obj.Subject.CommonName, keyID)
+				}
+
+				if len(obj.Usages) != 1 || obj.Usages[0] != usage {
+					t.Errorf("Cert %d: got usages %v, want [%s]", i, obj.Usages, usage)
+				}
+
+				if obj.Type != CacheObjectTypeSSHCertificate {
+					t.Errorf("Cert %d: got type %q", i, obj.Type)
+				}
+
+				if !obj.NotAfter.Equal(sshTestExpiry) {
+					t.Errorf("Cert %d: got expiry %s, want %s", i, obj.NotAfter, sshTestExpiry)
+				}
+
+				if obj.SerialNumber.Int64() != 42 || strings.Join(obj.Principals, ",") != "alice,bob" {
+					t.Errorf("Cert %d: got serial %s and principals %v", i, obj.SerialNumber, obj.Principals)
+				}
+
+				if !strings.HasPrefix(obj.Issuer.CommonName, "SHA256:") {
+					t.Errorf("Cert %d: got issuer %q, want a CA key fingerprint", i, obj.Issuer.CommonName)
+				}
+
+				if obj.KeyAlgorithmName != ssh.KeyAlgoED25519 || obj.SignatureAlgorithmName != ssh.KeyAlgoED25519 {
+					t.Errorf("Cert %d: got key %q signed with %q", i, obj.KeyAlgorithmName, obj.SignatureAlgorithmName)
+				}
+			}
+		})
+	}
+}
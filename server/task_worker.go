@@ -8,17 +8,19 @@ import (
 )
 
 type taskWorkerFactory struct {
-	sched *taskQueue
-	cache *Cache
-	log   *logger.Logger
-	curID uint
+	sched   *taskQueue
+	cache   *Cache
+	history *History
+	log     *logger.Logger
+	curID   uint
 }
 
-func newTaskWorkerFactory(sched *taskQueue, cache *Cache, log *logger.Logger) *taskWorkerFactory {
+func newTaskWorkerFactory(sched *taskQueue, cache *Cache, history *History, log *logger.Logger) *taskWorkerFactory {
 	return &taskWorkerFactory{
-		sched: sched,
-		cache: cache,
-		log:   log,
+		sched:   sched,
+		cache:   cache,
+		history: history,
+		log:     log,
 	}
 }
 
@@ -26,6 +28,7 @@ func (f *taskWorkerFactory) newWorker() *taskWorker {
 	ret := &taskWorker{
 		sched:   f.sched,
 		cache:   f.cache,
+		history: f.history,
 		log:     f.log,
 		id:      f.curID,
 		state:   WorkerStateIdle,
@@ -37,10 +40,11 @@ func (f *taskWorkerFactory) newWorker() *taskWorker {
 }
 
 type taskWorker struct {
-	sched *taskQueue
-	cache *Cache
-	log   *logger.Logger
-	id    uint
+	sched   *taskQueue
+	cache   *Cache
+	history *History
+	log     *logger.Logger
+	id      uint
 	//Never try to grab the scheduler lock while holding this lock.
 	// You can try to grab this lock while holding the scheduler lock, but
 	// not in the reverse order.
@@ -93,30 +97,46 @@ func (w *taskWorker) consumeScheduler() {
 func (w *taskWorker) runNext() managerTask {
 	w.sched.lock.Lock()
 
-	for w.sched.empty() || w.sched.data[0].state == queueTaskStatePending {
+	idx := w.sched.nextRunnableIdxNoLock()
+	for idx < 0 {
 		w.sched.cond.Wait()
+		idx = w.sched.nextRunnableIdxNoLock()
 	}
 
-	ret := w.sched.dequeueNoLock()
+	ret := w.sched.dequeueNoLock(idx)
 
 	if ret.state == queueTaskStateSkip {
+		w.sched.finishNoLock(newTaskStatus(&ret, TaskStateSkipped))
 		w.sched.lock.Unlock()
 		w.log.WriteF("Worker %d skipping %s %s of `%s'", w.id, ret.reason, ret.kind, ret.source.Core.Name)
 		return ret
 	}
 
 	ret.assignedWorker = w
+	ret.startedAt = time.Now()
 	w.sched.running = append(w.sched.running, ret)
 	w.SetState(WorkerStateRunning)
 	w.sched.lock.Unlock()
 
 	w.log.WriteF("Worker %d running %s %s of `%s'", w.id, ret.reason, ret.kind, ret.source.Core.Name)
 
-	ret.run(w.cache, w.log)
+	stats, err := ret.run(w.cache, w.history, w.log)
+	status := newTaskStatus(&ret, TaskStateDone)
+	status.FinishedAt, status.Err, status.Stats = time.Now(), err, stats
+	if err != nil {
+		status.State = TaskStateFailed
+	}
+
+	if ret.kind == queueTaskKindRefresh && w.sched.afterRefresh != nil {
+		w.sched.afterRefresh()
+	}
 
 	w.SetState(WorkerStateScheduling)
 	w.sched.lock.Lock()
 	w.sched.running.deleteTaskWithID(ret.id)
+	w.sched.finishNoLock(status)
+	//A task held back while this one ran may be able to go now
+	w.sched.cond.Broadcast()
 	w.sched.lock.Unlock()
 	w.SetState(WorkerStateIdle)
 
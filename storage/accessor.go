@@ -19,12 +19,36 @@ type Accessor interface {
 	Authenticate(last interface{}) (TTL time.Duration, nextMetadata interface{}, err error)
 }
 
+//SlowLister can be implemented by an Accessor whose List could take long
+// enough that it must be checked against how often the backend is refreshed.
+type SlowLister interface {
+	MaxListDuration() time.Duration
+}
+
+//Warning can be returned as the error from Accessor.Get when the path could
+// not be fetched, but the failure should be reported instead of causing the
+// population of the whole backend to fail.
+type Warning struct {
+	Err error
+}
+
+func (w *Warning) Error() string {
+	return w.Err.Error()
+}
+
+//Warnf returns a *Warning with an error message formatted as with fmt.Errorf
+func Warnf(format string, a ...interface{}) error {
+	return &Warning{Err: fmt.Errorf(format, a...)}
+}
+
 const (
 	typeUnknown int = iota
 	typeVault
 	typeOpsman
 	typeCredhub
 	typeTLS
+	typeKubernetes
+	typeFile
 )
 
 const (
@@ -67,6 +91,12 @@ func NewAccessor(accessorType string, conf map[string]interface{}) (
 	case typeTLS:
 		c = &TLSClientConfig{}
 		err = yaml.Unmarshal(properties, c.(*TLSClientConfig))
+	case typeKubernetes:
+		c = &KubernetesConfig{}
+		err = yaml.Unmarshal(properties, c.(*KubernetesConfig))
+	case typeFile:
+		c = &FileConfig{}
+		err = yaml.Unmarshal(properties, c.(*FileConfig))
 	}
 
 	if err != nil {
@@ -84,6 +114,10 @@ func NewAccessor(accessorType string, conf map[string]interface{}) (
 		backend, firstAuth, err = newConfigServerAccessor(*c.(*ConfigServerConfig))
 	case typeTLS:
 		backend, firstAuth, err = newTLSClientAccessor(*c.(*TLSClientConfig))
+	case typeKubernetes:
+		backend, firstAuth, err = newKubernetesAccessor(*c.(*KubernetesConfig))
+	case typeFile:
+		backend, firstAuth, err = newFileAccessor(*c.(*FileConfig))
 	}
 
 	return backend, firstAuth, err
@@ -99,6 +133,10 @@ func resolveType(t string) int {
 		return typeCredhub
 	case "tls", "tlsclient":
 		return typeTLS
+	case "kubernetes", "k8s":
+		return typeKubernetes
+	case "file", "files", "filesystem":
+		return typeFile
 	default:
 		return typeUnknown
 	}
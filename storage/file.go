@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FileAccessor struct {
+	paths   []string
+	include []string
+	exclude []string
+	//unreadable holds the entries under the configured paths which could not
+	// be read during the last List. They are listed like any other path so
+	// that Get can report them as warnings.
+	unreadable map[string]error
+	lock       sync.RWMutex
+}
+
+//FileConfig's globs are named so as not to be confused with the include and
+// exclude path filters that every backend has
+type FileConfig struct {
+	Paths   []string `yaml:"paths"`
+	Include []string `yaml:"include_globs"`
+	Exclude []string `yaml:"exclude_globs"`
+}
+
+func newFileAccessor(conf FileConfig) (*FileAccessor, interface{}, error) {
+	if len(conf.Paths) == 0 {
+		return nil, nil, fmt.Errorf("No paths list was specified in the configuration")
+	}
+
+	for _, pattern := range append(conf.Include, conf.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, nil, fmt.Errorf("Invalid glob pattern `%s': %s", pattern, err)
+		}
+	}
+
+	ret := &FileAccessor{
+		include: conf.Include,
+		exclude: conf.Exclude,
+	}
+
+	for _, path := range conf.Paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not resolve path `%s': %s", path, err)
+		}
+
+		ret.paths = append(ret.paths, abs)
+	}
+
+	return ret, nil, nil
+}
+
+//List walks each of the configured paths and returns every regular file (or
+// symlink to a regular file) which passes the include and exclude globs.
+// Directories which are excluded are not walked. Files and directories beneath
+// the configured paths which can't be read, and which would not have been
+// excluded, are returned as paths for which Get gives a warning.
+func (f *FileAccessor) List() (PathList, error) {
+	var ret PathList
+	seen := map[string]bool{}
+	unreadable := map[string]error{}
+	for _, root := range f.paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				//If the configured path itself is missing or unreadable, the
+				// config is probably wrong
+				if path == root {
+					return err
+				}
+
+				record := func() {
+					if !seen[path] {
+						seen[path] = true
+						unreadable[path] = err
+						ret = append(ret, path)
+					}
+				}
+
+				if info != nil && info.IsDir() {
+					if !f.excludesDir(path) {
+						record()
+					}
+
+					return filepath.SkipDir
+				}
+
+				if f.shouldInclude(path) {
+					record()
+				}
+
+				return nil
+			}
+
+			if info.IsDir() && path != root && f.excludesDir(path) {
+				return filepath.SkipDir
+			}
+
+			if info.Mode()&os.ModeSymlink != 0 {
+				info, err = os.Stat(path)
+				if err != nil {
+					//Dangling symlinks are not worth failing the whole listing over
+					return nil
+				}
+			}
+
+			if !info.Mode().IsRegular() || seen[path] || !f.shouldInclude(path) {
+				return nil
+			}
+
+			seen[path] = true
+			ret = append(ret, path)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Could not walk `%s': %s", root, err)
+		}
+	}
+
+	f.lock.Lock()
+	f.unreadable = unreadable
+	f.lock.Unlock()
+
+	return ret, nil
+}
+
+//shouldInclude returns true if the path matches at least one include glob (or
+// there are none), and no exclude globs
+func (f *FileAccessor) shouldInclude(path string) bool {
+	if len(f.include) > 0 && !globsMatch(f.include, path) {
+		return false
+	}
+
+	return !globsMatch(f.exclude, path)
+}
+
+//excludesDir returns true if the directory matches an exclude glob, or an
+// exclude glob matches everything in it, such as `/etc/ssl/private/*'. Include
+// globs aren't checked, as they are meant for the files in directories.
+func (f *FileAccessor) excludesDir(path string) bool {
+	if globsMatch(f.exclude, path) {
+		return true
+	}
+
+	for _, pattern := range f.exclude {
+		if !strings.HasSuffix(pattern, "/*") {
+			continue
+		}
+
+		if m, _ := filepath.Match(strings.TrimSuffix(pattern, "/*"), path); m {
+			return true
+		}
+	}
+
+	return false
+}
+
+//globsMatch returns true if any of the globs match either the full path or
+// its base name
+func globsMatch(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if m, _ := filepath.Match(pattern, path); m {
+			return true
+		}
+
+		if m, _ := filepath.Match(pattern, filepath.Base(path)); m {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Get returns the contents of the file at the given path under the key
+// "contents"
+func (f *FileAccessor) Get(path string) (map[string]string, error) {
+	f.lock.RLock()
+	listErr, found := f.unreadable[path]
+	f.lock.RUnlock()
+	if found {
+		return nil, Warnf("Skipped unreadable path: %s", listErr)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		switch {
+		//The file was probably removed since we listed it
+		case os.IsNotExist(err):
+			err = nil
+		case os.IsPermission(err):
+			err = Warnf("Could not read file: %s", err)
+		}
+		return nil, err
+	}
+
+	return map[string]string{"contents": string(contents)}, nil
+}
+
+func (f *FileAccessor) Authenticate(_ interface{}) (time.Duration, interface{}, error) {
+	return TTLInfinite, nil, nil
+}
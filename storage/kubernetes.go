@@ -0,0 +1,456 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	//Projected service account tokens are rotated by the kubelet, so we need
+	// to go back to the file every so often to pick up the new one
+	k8sTokenFileTTL = 5 * time.Minute
+	k8sListPageSize = 500
+)
+
+type KubernetesAccessor struct {
+	client     *http.Client
+	url        *url.URL
+	namespaces []string
+	lock       sync.RWMutex
+	token      string
+	tokenFile  string
+}
+
+type KubernetesConfig struct {
+	Address            string   `yaml:"address"`
+	InsecureSkipVerify bool     `yaml:"insecure_skip_verify"`
+	CACerts            string   `yaml:"ca_certs"`
+	Namespaces         []string `yaml:"namespaces"`
+	Auth               struct {
+		InCluster  bool   `yaml:"in_cluster"`
+		Kubeconfig string `yaml:"kubeconfig"`
+		Context    string `yaml:"context"`
+		Token      string `yaml:"token"`
+		TokenFile  string `yaml:"token_file"`
+	} `yaml:"auth"`
+}
+
+type kubernetesAuthMetadata struct{}
+
+//kubeconfig is the subset of the kubectl configuration file that we know how
+// to make use of
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			//Exec and AuthProvider are only noted so that we can refuse them
+			Exec         interface{} `yaml:"exec"`
+			AuthProvider interface{} `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+func newKubernetesAccessor(conf KubernetesConfig) (*KubernetesAccessor, kubernetesAuthMetadata, error) {
+	metadata := kubernetesAuthMetadata{}
+	var clientCerts []tls.Certificate
+
+	switch {
+	case conf.Auth.InCluster:
+		if conf.Auth.Kubeconfig != "" {
+			return nil, metadata, fmt.Errorf("Cannot provide both in_cluster and kubeconfig authentication methods")
+		}
+
+		if conf.Address == "" {
+			host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+			if host == "" || port == "" {
+				return nil, metadata, fmt.Errorf("in_cluster auth was requested, but KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+			}
+
+			conf.Address = "https://" + net.JoinHostPort(host, port)
+		}
+
+		if conf.CACerts == "" {
+			caCerts, err := ioutil.ReadFile(k8sServiceAccountDir + "/ca.crt")
+			if err != nil {
+				return nil, metadata, fmt.Errorf("Could not read service account CA certificate: %s", err)
+			}
+
+			conf.CACerts = string(caCerts)
+		}
+
+		if conf.Auth.Token == "" && conf.Auth.TokenFile == "" {
+			conf.Auth.TokenFile = k8sServiceAccountDir + "/token"
+		}
+
+	case conf.Auth.Kubeconfig != "":
+		var err error
+		clientCerts, err = applyKubeconfig(&conf)
+		if err != nil {
+			return nil, metadata, err
+		}
+	}
+
+	if conf.Address == "" {
+		return nil, metadata, fmt.Errorf("No address was specified in the configuration")
+	}
+
+	if conf.Auth.Token != "" && conf.Auth.TokenFile != "" {
+		return nil, metadata, fmt.Errorf("Cannot provide both token and token_file")
+	}
+
+	u, err := url.Parse(conf.Address)
+	if err != nil {
+		return nil, metadata, fmt.Errorf("Could not parse url (%s) in config: %s", conf.Address, err)
+	}
+
+	if u.Scheme == "" {
+		u.Scheme = "https"
+	}
+
+	certPool, _ := x509.SystemCertPool()
+	if conf.CACerts != "" {
+		certPool = x509.NewCertPool()
+		ok := certPool.AppendCertsFromPEM([]byte(conf.CACerts))
+		if !ok {
+			return nil, metadata, fmt.Errorf("Could not parse provided CA certificates")
+		}
+	}
+
+	return &KubernetesAccessor{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: conf.InsecureSkipVerify,
+					RootCAs:            certPool,
+					Certificates:       clientCerts,
+				},
+				Dial: (&net.Dialer{
+					Timeout:   5 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).Dial,
+			},
+		},
+		url:        u,
+		namespaces: conf.Namespaces,
+		token:      conf.Auth.Token,
+		tokenFile:  conf.Auth.TokenFile,
+	}, metadata, nil
+}
+
+//applyKubeconfig fills in any unset connection and auth properties of conf from
+// the kubeconfig file it references. Client certificates, if any, are returned.
+func applyKubeconfig(conf *KubernetesConfig) ([]tls.Certificate, error) {
+	contents, err := ioutil.ReadFile(conf.Auth.Kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read kubeconfig (%s): %s", conf.Auth.Kubeconfig, err)
+	}
+
+	kc := kubeconfig{}
+	err = yaml.Unmarshal(contents, &kc)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse kubeconfig (%s) as YAML: %s", conf.Auth.Kubeconfig, err)
+	}
+
+	contextName := conf.Auth.Context
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+
+	var clusterName, userName string
+	var foundContext bool
+	for _, c := range kc.Contexts {
+		if c.Name == contextName {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+			foundContext = true
+			break
+		}
+	}
+	if !foundContext {
+		return nil, fmt.Errorf("Context `%s' not found in kubeconfig", contextName)
+	}
+
+	var foundCluster bool
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+
+		foundCluster = true
+		if conf.Address == "" {
+			conf.Address = c.Cluster.Server
+		}
+
+		if c.Cluster.InsecureSkipTLSVerify {
+			conf.InsecureSkipVerify = true
+		}
+
+		if conf.CACerts == "" {
+			caCerts, err := kubeconfigData(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority)
+			if err != nil {
+				return nil, fmt.Errorf("Could not load certificate authority of cluster `%s': %s", clusterName, err)
+			}
+
+			conf.CACerts = string(caCerts)
+		}
+	}
+	if !foundCluster {
+		return nil, fmt.Errorf("Cluster `%s' not found in kubeconfig", clusterName)
+	}
+
+	//A context may leave out the user to connect anonymously
+	var foundUser bool
+	var clientCerts []tls.Certificate
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+
+		foundUser = true
+		if conf.Auth.Token == "" && conf.Auth.TokenFile == "" {
+			if u.User.Exec != nil || u.User.AuthProvider != nil {
+				return nil, fmt.Errorf("User `%s' in kubeconfig authenticates with exec or auth-provider, which are not supported. "+
+					"Give a token or token_file for the backend instead", userName)
+			}
+
+			conf.Auth.Token, conf.Auth.TokenFile = u.User.Token, u.User.TokenFile
+		}
+
+		certPEM, err := kubeconfigData(u.User.ClientCertificateData, u.User.ClientCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load client certificate of user `%s': %s", userName, err)
+		}
+
+		keyPEM, err := kubeconfigData(u.User.ClientKeyData, u.User.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load client key of user `%s': %s", userName, err)
+		}
+
+		if len(certPEM) != 0 || len(keyPEM) != 0 {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("Could not parse client certificate of user `%s': %s", userName, err)
+			}
+
+			clientCerts = append(clientCerts, cert)
+		}
+	}
+	if userName != "" && !foundUser {
+		return nil, fmt.Errorf("User `%s' not found in kubeconfig", userName)
+	}
+
+	return clientCerts, nil
+}
+
+//kubeconfigData returns the base64 decoded data if given, and otherwise the
+// contents of the file at path, if given.
+func kubeconfigData(data, path string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	if path != "" {
+		return ioutil.ReadFile(path)
+	}
+
+	return nil, nil
+}
+
+//List returns a path of the form <namespace>/<name> for each TLS or Opaque
+// secret in the configured namespaces, or in every namespace if none were
+// configured.
+func (k *KubernetesAccessor) List() (PathList, error) {
+	if len(k.namespaces) == 0 {
+		return k.list("/api/v1/secrets")
+	}
+
+	var ret PathList
+	for _, ns := range k.namespaces {
+		paths, err := k.list(fmt.Sprintf("/api/v1/namespaces/%s/secrets", url.PathEscape(ns)))
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, paths...)
+	}
+
+	return ret, nil
+}
+
+func (k *KubernetesAccessor) list(path string) (PathList, error) {
+	var ret PathList
+	var continueToken string
+	for {
+		query := url.Values{"limit": []string{fmt.Sprintf("%d", k8sListPageSize)}}
+		if continueToken != "" {
+			query.Set("continue", continueToken)
+		}
+
+		var secretList struct {
+			Metadata struct {
+				Continue string `json:"continue"`
+			} `json:"metadata"`
+			Items []struct {
+				Metadata struct {
+					Name      string `json:"name"`
+					Namespace string `json:"namespace"`
+				} `json:"metadata"`
+				Type string `json:"type"`
+			} `json:"items"`
+		}
+
+		respBody, err := k.kubernetesAPI(path, query)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(respBody, &secretList)
+		if err != nil {
+			return nil, fmt.Errorf("could not unmarshal secret list response: %s", err)
+		}
+
+		for _, secret := range secretList.Items {
+			if secret.Type != "kubernetes.io/tls" && secret.Type != "Opaque" {
+				continue
+			}
+
+			ret = append(ret, secret.Metadata.Namespace+"/"+secret.Metadata.Name)
+		}
+
+		continueToken = secretList.Metadata.Continue
+		if continueToken == "" {
+			break
+		}
+	}
+
+	return ret, nil
+}
+
+//Get fetches the secret at the given <namespace>/<name> path and returns its
+// data keys with their values base64 decoded.
+func (k *KubernetesAccessor) Get(path string) (map[string]string, error) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Malformed kubernetes secret path `%s'", path)
+	}
+
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+
+	respBody, err := k.kubernetesAPI(
+		fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", url.PathEscape(parts[0]), url.PathEscape(parts[1])),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(respBody, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal secret response: %s", err)
+	}
+
+	ret := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode key `%s' of secret `%s': %s", key, path, err)
+		}
+
+		ret[key] = string(decoded)
+	}
+
+	return ret, nil
+}
+
+//Authenticate re-reads the bearer token from the configured token file, if
+// any. Static tokens and client certificates never need to be renewed.
+func (k *KubernetesAccessor) Authenticate(last interface{}) (time.Duration, interface{}, error) {
+	if k.tokenFile == "" {
+		return TTLInfinite, last, nil
+	}
+
+	token, err := ioutil.ReadFile(k.tokenFile)
+	if err != nil {
+		return TTLUnknown, last, fmt.Errorf("Could not read token file (%s): %s", k.tokenFile, err)
+	}
+
+	k.lock.Lock()
+	k.token = strings.TrimSpace(string(token))
+	k.lock.Unlock()
+
+	return k8sTokenFileTTL, last, nil
+}
+
+func (k *KubernetesAccessor) kubernetesAPI(path string, query url.Values) ([]byte, error) {
+	u := *k.url
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	k.lock.RLock()
+	if k.token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", k.token))
+	}
+	k.lock.RUnlock()
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not make request to kubernetes api: %s", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubernetes api returned %d for %s: %s", resp.StatusCode, path, respBody)
+	}
+
+	return respBody, nil
+}
@@ -0,0 +1,396 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//fakeKubernetes is a Kubernetes API server with just enough of the secrets API
+// for the accessor. It remembers the bearer tokens it was given.
+type fakeKubernetes struct {
+	*httptest.Server
+	lock   sync.Mutex
+	tokens []string
+}
+
+type fakeSecret struct {
+	namespace  string
+	name       string
+	secretType string
+}
+
+//fakeSecretPages are the pages that listing secrets across all namespaces
+// gives, each page continuing from the one before
+var fakeSecretPages = [][]fakeSecret{
+	{
+		{namespace: "default", name: "web-tls", secretType: "kubernetes.io/tls"},
+		{namespace: "default", name: "sa-token", secretType: "kubernetes.io/service-account-token"},
+	},
+	{
+		{namespace: "certs", name: "ca", secretType: "Opaque"},
+	},
+	{
+		{namespace: "certs", name: "dockercfg", secretType: "kubernetes.io/dockercfg"},
+		{namespace: "certs", name: "bad", secretType: "Opaque"},
+	},
+}
+
+var fakeSecretData = map[string]map[string]string{
+	"default/web-tls": {
+		"tls.crt": base64.StdEncoding.EncodeToString([]byte("a certificate")),
+		"tls.key": base64.StdEncoding.EncodeToString([]byte("a key")),
+	},
+	"certs/ca": {
+		"ca.crt": base64.StdEncoding.EncodeToString([]byte("")),
+	},
+	"certs/bad": {
+		"ca.crt": "not base64!",
+	},
+}
+
+func newFakeKubernetes(t *testing.T) *fakeKubernetes {
+	ret := &fakeKubernetes{}
+	ret.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ret.lock.Lock()
+		ret.tokens = append(ret.tokens, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		ret.lock.Unlock()
+
+		writeJSON := func(v interface{}) {
+			b, err := json.Marshal(v)
+			if err != nil {
+				t.Errorf("Could not marshal response: %s", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(b)
+		}
+
+		type metadata struct {
+			Name      string `json:"name,omitempty"`
+			Namespace string `json:"namespace,omitempty"`
+			Continue  string `json:"continue,omitempty"`
+		}
+		type item struct {
+			Metadata metadata `json:"metadata"`
+			Type     string   `json:"type"`
+		}
+		listPages := func(pages [][]fakeSecret) {
+			if r.URL.Query().Get("limit") != "500" {
+				t.Errorf("Expected a limit of 500, got `%s'", r.URL.Query().Get("limit"))
+			}
+
+			page := 0
+			if cont := r.URL.Query().Get("continue"); cont != "" {
+				page = int(cont[len(cont)-1] - '0')
+			}
+
+			resp := struct {
+				Metadata metadata `json:"metadata"`
+				Items    []item   `json:"items"`
+			}{Items: []item{}}
+			for _, secret := range pages[page] {
+				resp.Items = append(resp.Items, item{
+					Metadata: metadata{Name: secret.name, Namespace: secret.namespace},
+					Type:     secret.secretType,
+				})
+			}
+			if page+1 < len(pages) {
+				resp.Metadata.Continue = "page" + string(rune('0'+page+1))
+			}
+
+			writeJSON(resp)
+		}
+
+		switch parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/"); {
+		case r.URL.Path == "/api/v1/secrets":
+			listPages(fakeSecretPages)
+		case len(parts) == 5 && parts[2] == "namespaces" && parts[4] == "secrets":
+			var secrets []fakeSecret
+			for _, page := range fakeSecretPages {
+				for _, secret := range page {
+					if secret.namespace == parts[3] {
+						secrets = append(secrets, secret)
+					}
+				}
+			}
+			listPages([][]fakeSecret{secrets})
+		case len(parts) == 6 && parts[2] == "namespaces" && parts[4] == "secrets":
+			data, found := fakeSecretData[parts[3]+"/"+parts[5]]
+			if !found {
+				w.WriteHeader(404)
+				w.Write([]byte(`{"kind":"Status","reason":"NotFound"}`))
+				return
+			}
+			writeJSON(map[string]interface{}{"data": data})
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+
+	return ret
+}
+
+func (f *fakeKubernetes) caPEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.Certificate().Raw}))
+}
+
+func (f *fakeKubernetes) lastToken() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.tokens) == 0 {
+		return ""
+	}
+
+	return f.tokens[len(f.tokens)-1]
+}
+
+func newTestKubernetesAccessor(t *testing.T, f *fakeKubernetes, namespaces []string) *KubernetesAccessor {
+	t.Helper()
+	conf := KubernetesConfig{Address: f.URL, CACerts: f.caPEM(), Namespaces: namespaces}
+	conf.Auth.Token = "static-token"
+	k, _, err := newKubernetesAccessor(conf)
+	if err != nil {
+		t.Fatalf("Could not make accessor: %s", err)
+	}
+
+	return k
+}
+
+func TestKubernetesList(t *testing.T) {
+	f := newFakeKubernetes(t)
+	defer f.Close()
+
+	tests := []struct {
+		name       string
+		namespaces []string
+		want       PathList
+	}{
+		{"all namespaces over several pages", nil, PathList{"default/web-tls", "certs/ca", "certs/bad"}},
+		{"one namespace", []string{"certs"}, PathList{"certs/ca", "certs/bad"}},
+		{"several namespaces", []string{"default", "certs"}, PathList{"default/web-tls", "certs/ca", "certs/bad"}},
+		{"empty namespace", []string{"nothing"}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := newTestKubernetesAccessor(t, f, test.namespaces).List()
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Expected %v, got %v", test.want, got)
+			}
+
+			if f.lastToken() != "static-token" {
+				t.Errorf("Expected the static token to be sent, got `%s'", f.lastToken())
+			}
+		})
+	}
+}
+
+func TestKubernetesGet(t *testing.T) {
+	f := newFakeKubernetes(t)
+	defer f.Close()
+	k := newTestKubernetesAccessor(t, f, nil)
+
+	tests := []struct {
+		name    string
+		path    string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "decodes every key",
+			path: "default/web-tls",
+			want: map[string]string{"tls.crt": "a certificate", "tls.key": "a key"},
+		},
+		{
+			name: "empty value",
+			path: "certs/ca",
+			want: map[string]string{"ca.crt": ""},
+		},
+		{
+			name:    "value which isn't base64",
+			path:    "certs/bad",
+			wantErr: "could not decode key `ca.crt' of secret `certs/bad'",
+		},
+		{
+			name:    "missing secret",
+			path:    "certs/missing",
+			wantErr: "kubernetes api returned 404",
+		},
+		{
+			name:    "path with no namespace",
+			path:    "web-tls",
+			wantErr: "Malformed kubernetes secret path `web-tls'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := k.Get(test.path)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Expected an error containing `%s', got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestKubernetesAuthenticateRereadsTokenFile(t *testing.T) {
+	f := newFakeKubernetes(t)
+	defer f.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	conf := KubernetesConfig{Address: f.URL, CACerts: f.caPEM()}
+	conf.Auth.TokenFile = tokenFile
+	k, _, err := newKubernetesAccessor(conf)
+	if err != nil {
+		t.Fatalf("Could not make accessor: %s", err)
+	}
+
+	_, _, err = k.Authenticate(nil)
+	if err == nil {
+		t.Fatalf("Expected an error when the token file doesn't exist")
+	}
+
+	for _, token := range []string{"first-token", "rotated-token"} {
+		err = ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600)
+		if err != nil {
+			t.Fatalf("Could not write token file: %s", err)
+		}
+
+		ttl, _, err := k.Authenticate(nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if ttl != k8sTokenFileTTL {
+			t.Errorf("Expected a TTL of %s, got %s", k8sTokenFileTTL, ttl)
+		}
+
+		_, err = k.List()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if f.lastToken() != token {
+			t.Errorf("Expected token `%s' to be sent, got `%s'", token, f.lastToken())
+		}
+	}
+}
+
+func TestKubernetesKubeconfig(t *testing.T) {
+	f := newFakeKubernetes(t)
+	defer f.Close()
+
+	const kubeconfigTemplate = `
+current-context: good
+clusters:
+- name: fake
+  cluster:
+    server: SERVER
+    certificate-authority-data: CA
+users:
+- name: token-user
+  user:
+    token: kubeconfig-token
+- name: exec-user
+  user:
+    exec:
+      command: get-a-token
+- name: provider-user
+  user:
+    auth-provider:
+      name: oidc
+contexts:
+- name: good
+  context: {cluster: fake, user: token-user}
+- name: anonymous
+  context: {cluster: fake}
+- name: missing-cluster
+  context: {cluster: nope, user: token-user}
+- name: missing-user
+  context: {cluster: fake, user: ghost}
+- name: exec
+  context: {cluster: fake, user: exec-user}
+- name: auth-provider
+  context: {cluster: fake, user: provider-user}
+`
+	kubeconfig := strings.NewReplacer(
+		"SERVER", f.URL,
+		"CA", base64.StdEncoding.EncodeToString([]byte(f.caPEM())),
+	).Replace(kubeconfigTemplate)
+	kubeconfigFile := filepath.Join(t.TempDir(), "config")
+	err := ioutil.WriteFile(kubeconfigFile, []byte(kubeconfig), 0600)
+	if err != nil {
+		t.Fatalf("Could not write kubeconfig: %s", err)
+	}
+
+	tests := []struct {
+		name      string
+		context   string
+		token     string
+		wantToken string
+		wantErr   string
+	}{
+		{name: "current context", wantToken: "kubeconfig-token"},
+		{name: "user with no credentials", context: "anonymous", wantToken: ""},
+		{name: "token overrides kubeconfig user", context: "good", token: "backend-token", wantToken: "backend-token"},
+		{name: "missing context", context: "nope", wantErr: "Context `nope' not found in kubeconfig"},
+		{name: "missing cluster", context: "missing-cluster", wantErr: "Cluster `nope' not found in kubeconfig"},
+		{name: "missing user", context: "missing-user", wantErr: "User `ghost' not found in kubeconfig"},
+		{name: "exec user", context: "exec", wantErr: "exec or auth-provider, which are not supported"},
+		{name: "auth-provider user", context: "auth-provider", wantErr: "exec or auth-provider, which are not supported"},
+		{name: "exec user with a token", context: "exec", token: "backend-token", wantToken: "backend-token"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := KubernetesConfig{}
+			conf.Auth.Kubeconfig = kubeconfigFile
+			conf.Auth.Context = test.context
+			conf.Auth.Token = test.token
+			k, _, err := newKubernetesAccessor(conf)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Expected an error containing `%s', got %v", test.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			//The address and CA come from the kubeconfig
+			_, err = k.List()
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if f.lastToken() != test.wantToken {
+				t.Errorf("Expected token `%s' to be sent, got `%s'", test.wantToken, f.lastToken())
+			}
+		})
+	}
+}
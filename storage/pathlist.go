@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 )
@@ -9,30 +10,101 @@ type PathList []string
 
 //Multiple filters are "or"d together
 type PathFilter struct {
-	Under    []string
-	Matching []string
+	Under    []string `yaml:"under"`
+	Matching []string `yaml:"matching"`
+	//matchers are the compiled Matching patterns
+	matchers []*regexp.Regexp
 }
 
-func pathMatches(path, pattern string) bool {
-	patternParts := strings.Split(pattern, "*")
-	for i, p := range patternParts {
-		patternParts[i] = regexp.QuoteMeta(p)
+//Validate returns an error if the filter contains entries which could never
+// have been intended, such as empty strings which would match everything. The
+// Matching patterns are compiled so that they needn't be for every path.
+func (f *PathFilter) Validate() error {
+	for _, dir := range f.Under {
+		if strings.Trim(dir, "/") == "" {
+			return fmt.Errorf("`under' entries must not be empty or only slashes")
+		}
+	}
+
+	for _, match := range f.Matching {
+		if match == "" {
+			return fmt.Errorf("`matching' entries must not be empty")
+		}
+	}
+
+	f.matchers = f.compile()
+	return nil
+}
+
+//compiled returns the compiled Matching patterns, compiling them if Validate
+// hasn't already
+func (f PathFilter) compiled() []*regexp.Regexp {
+	if len(f.matchers) == len(f.Matching) {
+		return f.matchers
+	}
+
+	return f.compile()
+}
+
+//compile turns the Matching patterns into regexes, where a "*" matches
+// anything but a path separator
+func (f PathFilter) compile() []*regexp.Regexp {
+	ret := make([]*regexp.Regexp, 0, len(f.Matching))
+	for _, pattern := range f.Matching {
+		patternParts := strings.Split(normalizePath(pattern), "*")
+		for i, p := range patternParts {
+			patternParts[i] = regexp.QuoteMeta(p)
+		}
+
+		ret = append(ret, regexp.MustCompile(`\A`+strings.Join(patternParts, `[^/:]*`)+`\z`))
 	}
 
-	re := regexp.MustCompile(strings.Join(patternParts, `\A[^/:]*\Z`))
-	return re.Match([]byte(path))
+	return ret
+}
+
+//Empty returns true if the filter has no entries
+func (f PathFilter) Empty() bool {
+	return len(f.Under) == 0 && len(f.Matching) == 0
+}
+
+//normalizePath trims the slashes from the ends of paths, so that paths with and
+// without a leading slash (such as those from CredHub) are matched alike
+func normalizePath(path string) string {
+	return strings.Trim(path, "/")
+}
+
+func pathMatches(path string, matcher *regexp.Regexp) bool {
+	return matcher.MatchString(normalizePath(path))
 }
 
 func pathIsUnder(path, dir string) bool {
-	return strings.HasPrefix(strings.Trim(path, "/"), strings.TrimPrefix(dir, "/"))
+	path = normalizePath(path)
+	dir = normalizePath(dir)
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+//Filter returns only the paths matched by include (if include is non-nil and
+// non-empty), less those matched by exclude. Doesn't modify reciever list
+func (k PathList) Filter(include, exclude *PathFilter) PathList {
+	ret := k
+	if include != nil && !include.Empty() {
+		ret = ret.Only(*include)
+	}
+
+	if exclude != nil && !exclude.Empty() {
+		ret = ret.Except(*exclude)
+	}
+
+	return ret
 }
 
 //Doesn't modify reciever list
 func (k PathList) Only(filter PathFilter) (ret PathList) {
+	matchers := filter.compiled()
 OuterLoop:
 	for _, key := range k {
-		for _, match := range filter.Matching {
-			if pathMatches(key, match) {
+		for _, matcher := range matchers {
+			if pathMatches(key, matcher) {
 				ret = append(ret, key)
 				continue OuterLoop
 			}
@@ -51,10 +123,11 @@ OuterLoop:
 
 //Doesn't modify reciever list
 func (k PathList) Except(filter PathFilter) (ret PathList) {
+	matchers := filter.compiled()
 	for _, key := range k {
 		var shouldNotAdd bool
-		for _, match := range filter.Matching {
-			if pathMatches(key, match) {
+		for _, matcher := range matchers {
+			if pathMatches(key, matcher) {
 				shouldNotAdd = true
 				goto DoneWithChecks
 			}
@@ -0,0 +1,386 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+//starttlsFunc performs the plaintext portion of a protocol's negotiation so
+// that, once it returns without error, a TLS handshake can begin on conn.
+type starttlsFunc func(conn net.Conn, hostname string) error
+
+type starttlsProtocol struct {
+	defaultPort string
+	negotiate   starttlsFunc
+}
+
+var starttlsProtocols = map[string]starttlsProtocol{
+	"smtp":     {defaultPort: "25", negotiate: starttlsSMTP},
+	"imap":     {defaultPort: "143", negotiate: starttlsIMAP},
+	"pop3":     {defaultPort: "110", negotiate: starttlsPOP3},
+	"ftp":      {defaultPort: "21", negotiate: starttlsFTP},
+	"ldap":     {defaultPort: "389", negotiate: starttlsLDAP},
+	"xmpp":     {defaultPort: "5222", negotiate: starttlsXMPP},
+	"postgres": {defaultPort: "5432", negotiate: starttlsPostgres},
+}
+
+func isStarttlsProtocol(protocol string) bool {
+	_, found := starttlsProtocols[protocol]
+	return found
+}
+
+func starttls(conn net.Conn, protocol, hostname string) error {
+	p, found := starttlsProtocols[protocol]
+	if !found {
+		return fmt.Errorf("Unsupported STARTTLS protocol `%s'", protocol)
+	}
+
+	return p.negotiate(conn, hostname)
+}
+
+//readReplyCode reads a (potentially multiline) SMTP or FTP style reply, and
+// returns the three digit reply code.
+func readReplyCode(r *bufio.Reader) (string, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		if len(line) < 4 {
+			return "", fmt.Errorf("Malformed reply `%s'", strings.TrimSpace(line))
+		}
+
+		//A dash after the code means that there are more lines to come
+		if line[3] != '-' {
+			return line[:3], nil
+		}
+	}
+}
+
+func expectReplyCode(r *bufio.Reader, expected string) error {
+	code, err := readReplyCode(r)
+	if err != nil {
+		return err
+	}
+
+	if code != expected {
+		return fmt.Errorf("Expected reply code %s but got %s", expected, code)
+	}
+
+	return nil
+}
+
+func starttlsSMTP(conn net.Conn, _ string) error {
+	r := bufio.NewReader(conn)
+	err := expectReplyCode(r, "220")
+	if err != nil {
+		return fmt.Errorf("Error reading greeting: %s", err)
+	}
+
+	_, err = io.WriteString(conn, "EHLO doomsday\r\n")
+	if err != nil {
+		return err
+	}
+
+	err = expectReplyCode(r, "250")
+	if err != nil {
+		return fmt.Errorf("Error reading EHLO response: %s", err)
+	}
+
+	_, err = io.WriteString(conn, "STARTTLS\r\n")
+	if err != nil {
+		return err
+	}
+
+	err = expectReplyCode(r, "220")
+	if err != nil {
+		return fmt.Errorf("Server refused STARTTLS: %s", err)
+	}
+
+	return nil
+}
+
+func starttlsFTP(conn net.Conn, _ string) error {
+	r := bufio.NewReader(conn)
+	err := expectReplyCode(r, "220")
+	if err != nil {
+		return fmt.Errorf("Error reading greeting: %s", err)
+	}
+
+	_, err = io.WriteString(conn, "AUTH TLS\r\n")
+	if err != nil {
+		return err
+	}
+
+	err = expectReplyCode(r, "234")
+	if err != nil {
+		return fmt.Errorf("Server refused AUTH TLS: %s", err)
+	}
+
+	return nil
+}
+
+func starttlsIMAP(conn net.Conn, _ string) error {
+	const tag = "dday1"
+	r := bufio.NewReader(conn)
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("Error reading greeting: %s", err)
+	}
+
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("Unexpected greeting `%s'", strings.TrimSpace(greeting))
+	}
+
+	_, err = io.WriteString(conn, tag+" STARTTLS\r\n")
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		//Skip over any untagged responses
+		if !strings.HasPrefix(line, tag+" ") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, tag+" OK") {
+			return fmt.Errorf("Server refused STARTTLS: %s", strings.TrimSpace(line))
+		}
+
+		return nil
+	}
+}
+
+func starttlsPOP3(conn net.Conn, _ string) error {
+	r := bufio.NewReader(conn)
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("Error reading greeting: %s", err)
+	}
+
+	if !strings.HasPrefix(greeting, "+OK") {
+		return fmt.Errorf("Unexpected greeting `%s'", strings.TrimSpace(greeting))
+	}
+
+	_, err = io.WriteString(conn, "STLS\r\n")
+	if err != nil {
+		return err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("Server refused STLS: %s", strings.TrimSpace(line))
+	}
+
+	return nil
+}
+
+//starttlsXMPP opens a client stream, waits for the server's stream features,
+// and then requests TLS. Rather than bring in a full XML parser, this just
+// looks for the elements that we care about in the stream.
+func starttlsXMPP(conn net.Conn, hostname string) error {
+	_, err := fmt.Fprintf(conn,
+		"<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' "+
+			"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>",
+		hostname,
+	)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	features, err := readUntil(r, "</stream:features>")
+	if err != nil {
+		return fmt.Errorf("Error reading stream features: %s", err)
+	}
+
+	if !strings.Contains(features, "urn:ietf:params:xml:ns:xmpp-tls") {
+		return fmt.Errorf("Server does not offer STARTTLS")
+	}
+
+	_, err = io.WriteString(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+	if err != nil {
+		return err
+	}
+
+	response, err := readUntil(r, ">")
+	if err != nil {
+		return fmt.Errorf("Error reading STARTTLS response: %s", err)
+	}
+
+	if !strings.Contains(response, "<proceed") {
+		return fmt.Errorf("Server refused STARTTLS")
+	}
+
+	return nil
+}
+
+//readUntil reads from r until the read data contains marker, returning
+// everything read. It will give up after 64KiB.
+func readUntil(r *bufio.Reader, marker string) (string, error) {
+	const maxLen = 64 * 1024
+	buf := strings.Builder{}
+	for buf.Len() < maxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return buf.String(), err
+		}
+
+		buf.WriteByte(b)
+		if strings.HasSuffix(buf.String(), marker) {
+			return buf.String(), nil
+		}
+	}
+
+	return buf.String(), fmt.Errorf("Did not find `%s' in the first %d bytes", marker, maxLen)
+}
+
+//starttlsPostgres sends an SSLRequest message, to which the server responds
+// with a single byte.
+func starttlsPostgres(conn net.Conn, _ string) error {
+	const sslRequestCode = 80877103
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint32(msg[0:4], 8)
+	binary.BigEndian.PutUint32(msg[4:8], sslRequestCode)
+	_, err := conn.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	resp := make([]byte, 1)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return err
+	}
+
+	if resp[0] != 'S' {
+		return fmt.Errorf("Server does not support SSL")
+	}
+
+	return nil
+}
+
+//ldapStartTLSRequest is a BER encoded LDAPMessage with messageID 1 containing an
+// ExtendedRequest for the StartTLS OID (1.3.6.1.4.1.1466.20037)
+var ldapStartTLSRequest = append([]byte{
+	0x30, 0x1d, //LDAPMessage SEQUENCE
+	0x02, 0x01, 0x01, //messageID INTEGER 1
+	0x77, 0x18, //[APPLICATION 23] ExtendedRequest
+	0x80, 0x16, //[0] requestName
+}, []byte("1.3.6.1.4.1.1466.20037")...)
+
+func starttlsLDAP(conn net.Conn, _ string) error {
+	_, err := conn.Write(ldapStartTLSRequest)
+	if err != nil {
+		return err
+	}
+
+	tag, msg, err := readBER(bufio.NewReader(conn))
+	if err != nil {
+		return fmt.Errorf("Error reading ExtendedResponse: %s", err)
+	}
+
+	if tag != 0x30 {
+		return fmt.Errorf("Response was not an LDAPMessage")
+	}
+
+	//Skip the messageID
+	_, _, msg, err = splitBER(msg)
+	if err != nil {
+		return err
+	}
+
+	tag, op, _, err := splitBER(msg)
+	if err != nil {
+		return err
+	}
+
+	//[APPLICATION 24] ExtendedResponse
+	if tag != 0x78 {
+		return fmt.Errorf("Response was not an ExtendedResponse")
+	}
+
+	tag, resultCode, _, err := splitBER(op)
+	if err != nil {
+		return err
+	}
+
+	if tag != 0x0a || len(resultCode) == 0 {
+		return fmt.Errorf("ExtendedResponse did not contain a resultCode")
+	}
+
+	if !bytes.Equal(resultCode, []byte{0}) {
+		return fmt.Errorf("Server refused StartTLS with resultCode %d", resultCode[len(resultCode)-1])
+	}
+
+	return nil
+}
+
+//readBER reads a single BER encoded element from r and returns its tag and
+// content. Only single byte tags are supported, which is all LDAP needs.
+func readBER(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := int(header[1])
+	if header[1]&0x80 != 0 {
+		numBytes := int(header[1] & 0x7f)
+		if numBytes == 0 || numBytes > 4 {
+			return 0, nil, fmt.Errorf("Unsupported BER length encoding")
+		}
+
+		lenBytes := make([]byte, numBytes)
+		_, err = io.ReadFull(r, lenBytes)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		length = 0
+		for _, b := range lenBytes {
+			length = length<<8 | int(b)
+		}
+	}
+
+	if length > 64*1024 {
+		return 0, nil, fmt.Errorf("BER element too long")
+	}
+
+	content := make([]byte, length)
+	_, err = io.ReadFull(r, content)
+	return header[0], content, err
+}
+
+//splitBER splits the first BER encoded element off of b, returning its tag,
+// its content, and the remaining bytes.
+func splitBER(b []byte) (tag byte, content, rest []byte, err error) {
+	tag, content, err = readBER(bufio.NewReader(bytes.NewReader(b)))
+	if err != nil {
+		return
+	}
+
+	headerLen := 2
+	if b[1]&0x80 != 0 {
+		headerLen += int(b[1] & 0x7f)
+	}
+
+	rest = b[headerLen+len(content):]
+	return
+}
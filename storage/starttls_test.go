@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+//starttlsStep is one exchange in a scripted server conversation. The server
+// first reads what it expects the client to send, if anything, and then
+// sends its reply.
+type starttlsStep struct {
+	recv string
+	send string
+}
+
+const xmppStreamHeader = "<?xml version='1.0'?><stream:stream to='example.com' xmlns='jabber:client' " +
+	"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>"
+
+const postgresSSLRequest = "\x00\x00\x00\x08\x04\xd2\x16\x2f"
+
+//ldapExtendedResponse is an LDAPMessage for messageID 1 holding an
+// ExtendedResponse with the given resultCode and empty matchedDN and
+// diagnosticMessage
+func ldapExtendedResponse(resultCode byte) string {
+	return string([]byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, resultCode, 0x04, 0x00, 0x04, 0x00})
+}
+
+//runStarttlsServer plays the steps on conn, and reports the first way the
+// client strayed from them
+func runStarttlsServer(conn net.Conn, steps []starttlsStep, result chan<- error) {
+	for _, step := range steps {
+		if step.recv != "" {
+			got := make([]byte, len(step.recv))
+			if _, err := io.ReadFull(conn, got); err != nil {
+				result <- fmt.Errorf("Expected client to send %q, but got error: %s", step.recv, err)
+				return
+			}
+
+			if string(got) != step.recv {
+				result <- fmt.Errorf("Expected client to send %q, but got %q", step.recv, got)
+				return
+			}
+		}
+
+		if _, err := io.WriteString(conn, step.send); err != nil {
+			result <- fmt.Errorf("Could not send %q: %s", step.send, err)
+			return
+		}
+	}
+
+	result <- nil
+}
+
+func TestStarttls(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		steps    []starttlsStep
+		//wantErr is a substring of the error expected, if any
+		wantErr string
+	}{
+		{
+			name:     "smtp",
+			protocol: "smtp",
+			steps: []starttlsStep{
+				{send: "220 mail.example.com ESMTP\r\n"},
+				{recv: "EHLO doomsday\r\n", send: "250-mail.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n"},
+				{recv: "STARTTLS\r\n", send: "220 Go ahead\r\n"},
+			},
+		},
+		{
+			name:     "smtp refused",
+			protocol: "smtp",
+			steps: []starttlsStep{
+				{send: "220 mail.example.com ESMTP\r\n"},
+				{recv: "EHLO doomsday\r\n", send: "250 mail.example.com\r\n"},
+				{recv: "STARTTLS\r\n", send: "454 TLS not available\r\n"},
+			},
+			wantErr: "Server refused STARTTLS",
+		},
+		{
+			name:     "smtp bad greeting",
+			protocol: "smtp",
+			steps:    []starttlsStep{{send: "554 No service\r\n"}},
+			wantErr:  "Expected reply code 220 but got 554",
+		},
+		{
+			name:     "smtp malformed reply",
+			protocol: "smtp",
+			steps:    []starttlsStep{{send: "22\n"}},
+			wantErr:  "Malformed reply",
+		},
+		{
+			name:     "ftp",
+			protocol: "ftp",
+			steps: []starttlsStep{
+				{send: "220-Welcome\r\n220 FTP ready\r\n"},
+				{recv: "AUTH TLS\r\n", send: "234 AUTH TLS OK\r\n"},
+			},
+		},
+		{
+			name:     "ftp refused",
+			protocol: "ftp",
+			steps: []starttlsStep{
+				{send: "220 FTP ready\r\n"},
+				{recv: "AUTH TLS\r\n", send: "502 Command not implemented\r\n"},
+			},
+			wantErr: "Server refused AUTH TLS",
+		},
+		{
+			name:     "imap",
+			protocol: "imap",
+			steps: []starttlsStep{
+				{send: "* OK IMAP4rev1 ready\r\n"},
+				{recv: "dday1 STARTTLS\r\n", send: "* CAPABILITY IMAP4rev1\r\ndday1 OK Begin TLS\r\n"},
+			},
+		},
+		{
+			name:     "imap refused",
+			protocol: "imap",
+			steps: []starttlsStep{
+				{send: "* OK IMAP4rev1 ready\r\n"},
+				{recv: "dday1 STARTTLS\r\n", send: "dday1 BAD Unknown command\r\n"},
+			},
+			wantErr: "Server refused STARTTLS",
+		},
+		{
+			name:     "imap bad greeting",
+			protocol: "imap",
+			steps:    []starttlsStep{{send: "* BYE Go away\r\n"}},
+			wantErr:  "Unexpected greeting",
+		},
+		{
+			name:     "pop3",
+			protocol: "pop3",
+			steps: []starttlsStep{
+				{send: "+OK POP3 ready\r\n"},
+				{recv: "STLS\r\n", send: "+OK Begin TLS\r\n"},
+			},
+		},
+		{
+			name:     "pop3 refused",
+			protocol: "pop3",
+			steps: []starttlsStep{
+				{send: "+OK POP3 ready\r\n"},
+				{recv: "STLS\r\n", send: "-ERR Not supported\r\n"},
+			},
+			wantErr: "Server refused STLS",
+		},
+		{
+			name:     "xmpp",
+			protocol: "xmpp",
+			steps: []starttlsStep{
+				{
+					recv: xmppStreamHeader,
+					send: "<?xml version='1.0'?><stream:stream from='example.com' id='1' version='1.0'>" +
+						"<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls>" +
+						"</stream:features>",
+				},
+				{
+					recv: "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>",
+					send: "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>",
+				},
+			},
+		},
+		{
+			name:     "xmpp not offered",
+			protocol: "xmpp",
+			steps: []starttlsStep{
+				{
+					recv: xmppStreamHeader,
+					send: "<stream:stream><stream:features><mechanisms/></stream:features>",
+				},
+			},
+			wantErr: "Server does not offer STARTTLS",
+		},
+		{
+			name:     "xmpp refused",
+			protocol: "xmpp",
+			steps: []starttlsStep{
+				{
+					recv: xmppStreamHeader,
+					send: "<stream:stream><stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/></stream:features>",
+				},
+				{
+					recv: "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>",
+					send: "<failure xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>",
+				},
+			},
+			wantErr: "Server refused STARTTLS",
+		},
+		{
+			name:     "postgres",
+			protocol: "postgres",
+			steps:    []starttlsStep{{recv: postgresSSLRequest, send: "S"}},
+		},
+		{
+			name:     "postgres refused",
+			protocol: "postgres",
+			steps:    []starttlsStep{{recv: postgresSSLRequest, send: "N"}},
+			wantErr:  "Server does not support SSL",
+		},
+		{
+			name:     "ldap",
+			protocol: "ldap",
+			steps:    []starttlsStep{{recv: string(ldapStartTLSRequest), send: ldapExtendedResponse(0)}},
+		},
+		{
+			//The same response, with the length in long form
+			name:     "ldap long form length",
+			protocol: "ldap",
+			steps: []starttlsStep{{
+				recv: string(ldapStartTLSRequest),
+				send: "\x30\x81\x0c" + ldapExtendedResponse(0)[2:],
+			}},
+		},
+		{
+			name:     "ldap refused",
+			protocol: "ldap",
+			steps:    []starttlsStep{{recv: string(ldapStartTLSRequest), send: ldapExtendedResponse(2)}},
+			wantErr:  "Server refused StartTLS with resultCode 2",
+		},
+		{
+			name:     "ldap not an extended response",
+			protocol: "ldap",
+			steps: []starttlsStep{{
+				recv: string(ldapStartTLSRequest),
+				send: "\x30\x0c\x02\x01\x01\x61\x07\x0a\x01\x00\x04\x00\x04\x00",
+			}},
+			wantErr: "Response was not an ExtendedResponse",
+		},
+		{
+			name:     "ldap indefinite length",
+			protocol: "ldap",
+			steps:    []starttlsStep{{recv: string(ldapStartTLSRequest), send: "\x30\x80"}},
+			wantErr:  "Unsupported BER length encoding",
+		},
+		{
+			name:     "unknown protocol",
+			protocol: "gopher",
+			wantErr:  "Unsupported STARTTLS protocol `gopher'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			result := make(chan error, 1)
+			go runStarttlsServer(server, test.steps, result)
+
+			err := starttls(client, test.protocol, "example.com")
+			//Closing our end unblocks the server if we stopped early
+			client.Close()
+			serverErr := <-result
+			server.Close()
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if serverErr != nil {
+				t.Fatal(serverErr)
+			}
+		})
+	}
+}
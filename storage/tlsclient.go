@@ -6,29 +6,65 @@ import (
 	"fmt"
 	"net"
 	"net/url"
-	"os"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
 type TLSClientAccessor struct {
-	hosts   []string
-	timeout time.Duration
+	hosts      []string
+	timeout    time.Duration
+	discoverer *tlsDiscoverer
+	//unresolved holds the DNS names which could not be looked up during the
+	// last List, so that Get can report them as warnings
+	unresolved map[string]error
+	lock       sync.RWMutex
 }
 
 type TLSClientConfig struct {
-	Hosts   []string `yaml:"hosts"`
-	Timeout int      `yaml:"timeout"`
+	Hosts     []TLSClientHost          `yaml:"hosts"`
+	Networks  []TLSClientNetwork       `yaml:"networks"`
+	DNS       []TLSClientDNS           `yaml:"dns"`
+	Discovery TLSClientDiscoveryConfig `yaml:"discovery"`
+	Timeout   int                      `yaml:"timeout"`
+}
+
+//TLSClientHost can be given in the config as either just the address string,
+// or as a hash with the address and the server names to send with SNI.
+type TLSClientHost struct {
+	Address string `yaml:"address"`
+	//ServerNames overrides the name sent with SNI. The host will be checked
+	// once for each name given.
+	ServerNames []string `yaml:"server_names"`
+}
+
+func (h *TLSClientHost) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&h.Address); err == nil {
+		return nil
+	}
+
+	type plainTLSClientHost TLSClientHost
+	return unmarshal((*plainTLSClientHost)(h))
 }
 
 func newTLSClientAccessor(conf TLSClientConfig) (*TLSClientAccessor, interface{}, error) {
-	ret := &TLSClientAccessor{}
-	if len(conf.Hosts) == 0 {
-		return nil, nil, fmt.Errorf("No hosts list was specified in the configuration")
+	discoverer, err := newTLSDiscoverer(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ret := &TLSClientAccessor{discoverer: discoverer}
+	if len(conf.Hosts) == 0 && discoverer.empty() {
+		return nil, nil, fmt.Errorf("No hosts, networks, or dns list was specified in the configuration")
 	}
 
 	schemeRegex := regexp.MustCompile("^.+?://.+")
-	for _, host := range conf.Hosts {
+	for _, confHost := range conf.Hosts {
+		host := confHost.Address
+		if host == "" {
+			return nil, nil, fmt.Errorf("The configured hosts list contained an entry with no address")
+		}
 
 		toParse := host
 		if !schemeRegex.Match([]byte(host)) {
@@ -40,58 +76,182 @@ func newTLSClientAccessor(conf TLSClientConfig) (*TLSClientAccessor, interface{}
 			return nil, nil, fmt.Errorf("The configured hosts list contained an invalid URL (%s): %s", host, err)
 		}
 
+		//Schemes which aren't a STARTTLS protocol are ignored, and a TLS
+		// handshake is attempted immediately upon connection
+		protocol := strings.ToLower(thisURL.Scheme)
+		if !isStarttlsProtocol(protocol) {
+			protocol = ""
+		}
+
 		if thisURL.Port() == "" {
-			thisURL.Host = thisURL.Host + ":443"
+			port := "443"
+			if protocol != "" {
+				port = starttlsProtocols[protocol].defaultPort
+			}
+
+			thisURL.Host = thisURL.Host + ":" + port
 		}
 
-		ret.hosts = append(ret.hosts, thisURL.Host)
-	}
+		if len(confHost.ServerNames) == 0 {
+			ret.hosts = append(ret.hosts, joinTLSClientPath(protocol, thisURL.Host, ""))
+		}
 
-	if conf.Timeout < 0 {
-		conf.Timeout = 0
+		for _, serverName := range confHost.ServerNames {
+			ret.hosts = append(ret.hosts, joinTLSClientPath(protocol, thisURL.Host, serverName))
+		}
 	}
 
-	if conf.Timeout != 0 {
-		ret.timeout = time.Second * time.Duration(conf.Timeout)
+	if conf.Timeout <= 0 {
+		conf.Timeout = 20
 	}
 
+	ret.timeout = time.Second * time.Duration(conf.Timeout)
+
 	return ret, nil, nil
 }
 
+//Paths are of the form [protocol://]host:port[?sni=servername]. The protocol
+// is only present for hosts which need STARTTLS, and the server name is only
+// present if it was overridden in the config.
+func joinTLSClientPath(protocol, host, serverName string) string {
+	ret := host
+	if protocol != "" {
+		ret = protocol + "://" + ret
+	}
+
+	if serverName != "" {
+		ret = ret + "?sni=" + serverName
+	}
+
+	return ret
+}
+
+func splitTLSClientPath(path string) (protocol, host, serverName string) {
+	host = path
+	if parts := strings.SplitN(host, "://", 2); len(parts) == 2 {
+		protocol, host = parts[0], parts[1]
+	}
+
+	if parts := strings.SplitN(host, "?sni=", 2); len(parts) == 2 {
+		host, serverName = parts[0], parts[1]
+	}
+
+	return
+}
+
+//MaxListDuration is the longest that discovering hosts in List could take
+func (t *TLSClientAccessor) MaxListDuration() time.Duration {
+	return t.discoverer.maxDuration()
+}
+
+//List returns the configured hosts, as well as any discovered from the
+// configured networks and DNS names. DNS names which could not be looked up
+// are returned as paths for which Get gives a warning.
 func (t *TLSClientAccessor) List() (PathList, error) {
 	ret := make(PathList, 0, len(t.hosts))
+	seen := map[string]bool{}
 	for _, host := range t.hosts {
+		seen[host] = true
 		ret = append(ret, host)
 	}
 
+	discovered, unresolved := t.discoverer.discover()
+	t.lock.Lock()
+	t.unresolved = unresolved
+	t.lock.Unlock()
+
+	for _, host := range discovered {
+		if !seen[host] {
+			seen[host] = true
+			ret = append(ret, host)
+		}
+	}
+
 	return ret, nil
 }
 
-func (t *TLSClientAccessor) Get(host string) (map[string]string, error) {
-	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: t.timeout}, "tcp", host, &tls.Config{InsecureSkipVerify: true})
+//Get returns the leaf certificate presented by the host under the key "cert",
+// and each subsequent certificate in the presented chain under "chain.<n>"
+func (t *TLSClientAccessor) Get(path string) (map[string]string, error) {
+	t.lock.RLock()
+	lookupErr, unresolved := t.unresolved[path]
+	t.lock.RUnlock()
+	if unresolved {
+		return nil, Warnf("%s", lookupErr)
+	}
+
+	protocol, host, serverName := splitTLSClientPath(path)
+	conn, err := t.dial(protocol, host, serverName)
 	if err != nil {
-		//TODO: We should implement an actual warning system instead of just not
-		// erroring
-		//TODO: Also, we need to get the actual logger into these storage implementations
-		fmt.Fprintf(os.Stderr, "Failed to connect to %s: %s\n", host, err)
-		return nil, nil
+		return nil, Warnf("Failed to connect to %s: %s", path, err)
 	}
+	defer conn.Close()
 
 	certs := conn.ConnectionState().PeerCertificates
 	ret := map[string]string{}
-	if len(certs) != 0 {
-		cert := certs[0].Raw
+	for i, cert := range certs {
 		pemCert := pem.EncodeToMemory(&pem.Block{
 			Type:  "CERTIFICATE",
-			Bytes: cert,
+			Bytes: cert.Raw,
 		})
 
-		ret["cert"] = string(pemCert)
+		key := "cert"
+		if i > 0 {
+			key = fmt.Sprintf("chain.%d", i)
+		}
+
+		ret[key] = string(pemCert)
 	}
 
 	return ret, nil
 }
 
+//dial connects to the host, performs the STARTTLS negotiation for the given
+// protocol if one is given, and then completes a TLS handshake. If serverName
+// is empty, the hostname is sent for SNI.
+func (t *TLSClientAccessor) dial(protocol, host, serverName string) (*tls.Conn, error) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+
+	if serverName == "" {
+		serverName = hostname
+	}
+
+	rawConn, err := (&net.Dialer{Timeout: t.timeout}).Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rawConn.SetDeadline(time.Now().Add(t.timeout))
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+
+	if protocol != "" {
+		err = starttls(rawConn, protocol, serverName)
+		if err != nil {
+			rawConn.Close()
+			return nil, fmt.Errorf("%s STARTTLS negotiation failed: %s", protocol, err)
+		}
+	}
+
+	conn := tls.Client(rawConn, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         serverName,
+	})
+
+	err = conn.Handshake()
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
 func (t *TLSClientAccessor) Authenticate(_ interface{}) (time.Duration, interface{}, error) {
 	return TTLInfinite, nil, nil
 }
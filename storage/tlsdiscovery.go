@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//maxNetworkBits is the most host bits a configured network may have, which
+	// bounds how many addresses we're willing to probe. A /16 for IPv4.
+	maxNetworkBits         = 16
+	defaultProbeWorkers    = 64
+	defaultProbeTimeoutSec = 2
+)
+
+//TLSClientNetwork is a range of addresses which are probed on each of the
+// given ports. Any which accept a TCP connection are checked for certs.
+type TLSClientNetwork struct {
+	CIDR     string `yaml:"cidr"`
+	Ports    []int  `yaml:"ports"`
+	Protocol string `yaml:"protocol"`
+}
+
+//TLSClientDNS is a DNS name which is resolved on each refresh. For A records,
+// each resolved address is checked on each of the given ports, sending the
+// name with SNI. For SRV records, each target is checked on its given port.
+type TLSClientDNS struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Ports    []int  `yaml:"ports"`
+	Protocol string `yaml:"protocol"`
+}
+
+type TLSClientDiscoveryConfig struct {
+	Workers int `yaml:"workers"`
+	//in seconds
+	Timeout int `yaml:"timeout"`
+}
+
+type tlsNetwork struct {
+	network  *net.IPNet
+	ports    []int
+	protocol string
+}
+
+type tlsDNSName struct {
+	name     string
+	srv      bool
+	ports    []int
+	protocol string
+}
+
+type tlsDiscoverer struct {
+	networks []tlsNetwork
+	names    []tlsDNSName
+	workers  int
+	timeout  time.Duration
+}
+
+func newTLSDiscoverer(conf TLSClientConfig) (*tlsDiscoverer, error) {
+	ret := &tlsDiscoverer{
+		workers: conf.Discovery.Workers,
+		timeout: time.Duration(conf.Discovery.Timeout) * time.Second,
+	}
+
+	if ret.workers <= 0 {
+		ret.workers = defaultProbeWorkers
+	}
+
+	if ret.timeout <= 0 {
+		ret.timeout = defaultProbeTimeoutSec * time.Second
+	}
+
+	for _, n := range conf.Networks {
+		_, ipNet, err := net.ParseCIDR(n.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse network `%s': %s", n.CIDR, err)
+		}
+
+		ones, bits := ipNet.Mask.Size()
+		if bits-ones > maxNetworkBits {
+			return nil, fmt.Errorf("Network `%s' is too large. It may contain at most %d addresses", n.CIDR, 1<<maxNetworkBits)
+		}
+
+		protocol, ports, err := resolveDiscoveryPorts(n.Protocol, n.Ports)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid configuration for network `%s': %s", n.CIDR, err)
+		}
+
+		ret.networks = append(ret.networks, tlsNetwork{
+			network:  ipNet,
+			ports:    ports,
+			protocol: protocol,
+		})
+	}
+
+	for _, d := range conf.DNS {
+		if d.Name == "" {
+			return nil, fmt.Errorf("The configured dns list contained an entry with no name")
+		}
+
+		var srv bool
+		switch strings.ToLower(d.Type) {
+		case "", "a", "aaaa", "host":
+		case "srv":
+			srv = true
+			if len(d.Ports) != 0 {
+				return nil, fmt.Errorf("Ports cannot be given for SRV record `%s'", d.Name)
+			}
+		default:
+			return nil, fmt.Errorf("Unknown DNS record type `%s' for `%s'", d.Type, d.Name)
+		}
+
+		protocol, ports, err := resolveDiscoveryPorts(d.Protocol, d.Ports)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid configuration for DNS name `%s': %s", d.Name, err)
+		}
+
+		ret.names = append(ret.names, tlsDNSName{
+			name:     d.Name,
+			srv:      srv,
+			ports:    ports,
+			protocol: protocol,
+		})
+	}
+
+	return ret, nil
+}
+
+//resolveDiscoveryPorts validates the protocol and ports, defaulting the ports
+// to the standard port for the protocol if none are given.
+func resolveDiscoveryPorts(protocol string, ports []int) (string, []int, error) {
+	protocol = strings.ToLower(protocol)
+	defaultPort := 443
+	switch {
+	case protocol == "" || protocol == "tls":
+		protocol = ""
+	case isStarttlsProtocol(protocol):
+		defaultPort, _ = strconv.Atoi(starttlsProtocols[protocol].defaultPort)
+	default:
+		return "", nil, fmt.Errorf("Unsupported protocol `%s'", protocol)
+	}
+
+	for _, port := range ports {
+		if port <= 0 || port > 65535 {
+			return "", nil, fmt.Errorf("Invalid port %d", port)
+		}
+	}
+
+	if len(ports) == 0 {
+		ports = []int{defaultPort}
+	}
+
+	return protocol, ports, nil
+}
+
+func (d *tlsDiscoverer) empty() bool {
+	return len(d.networks) == 0 && len(d.names) == 0
+}
+
+//discover resolves the configured DNS names and probes the configured networks,
+// returning the paths for every host found. A name which can't be resolved
+// doesn't stop the others being checked. Instead, it is returned as a path of
+// its own in failed, along with the error. The lookups and probes share the
+// pool of workers.
+func (d *tlsDiscoverer) discover() (ret PathList, failed map[string]error) {
+	if d.empty() {
+		return nil, nil
+	}
+
+	type result struct {
+		paths PathList
+		//name is the DNS name which failed to resolve, if err is set
+		name string
+		err  error
+	}
+
+	jobs := make(chan func() result)
+	results := make(chan result)
+
+	go func() {
+		for _, name := range d.names {
+			name := name
+			jobs <- func() result {
+				paths, err := d.resolve(name)
+				return result{paths: paths, name: name.name, err: err}
+			}
+		}
+
+		for _, n := range d.networks {
+			n.eachHost(func(ip net.IP) {
+				for _, port := range n.ports {
+					host := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+					path := joinTLSClientPath(n.protocol, host, "")
+					jobs <- func() result {
+						conn, err := net.DialTimeout("tcp", host, d.timeout)
+						if err != nil {
+							return result{}
+						}
+
+						conn.Close()
+						return result{paths: PathList{path}}
+					}
+				}
+			})
+		}
+		close(jobs)
+	}()
+
+	barrier := sync.WaitGroup{}
+	barrier.Add(d.workers)
+	for i := 0; i < d.workers; i++ {
+		go func() {
+			defer barrier.Done()
+			for job := range jobs {
+				results <- job()
+			}
+		}()
+	}
+
+	go func() {
+		barrier.Wait()
+		close(results)
+	}()
+
+	failed = map[string]error{}
+	for r := range results {
+		if r.err != nil {
+			failed[r.name] = r.err
+			ret = append(ret, r.name)
+			continue
+		}
+
+		ret = append(ret, r.paths...)
+	}
+
+	sort.Strings(ret)
+	return ret, failed
+}
+
+//maxDuration is the longest that discover could take, if every lookup and
+// probe were to time out
+func (d *tlsDiscoverer) maxDuration() time.Duration {
+	jobs := len(d.names)
+	for _, n := range d.networks {
+		jobs += n.numHosts() * len(n.ports)
+	}
+
+	rounds := (jobs + d.workers - 1) / d.workers
+	return time.Duration(rounds) * d.timeout
+}
+
+func (d *tlsDiscoverer) resolve(name tlsDNSName) (PathList, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	var ret PathList
+	if name.srv {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name.name)
+		if err != nil {
+			return nil, fmt.Errorf("Could not look up SRV record `%s': %s", name.name, err)
+		}
+
+		for _, record := range records {
+			host := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+			ret = append(ret, joinTLSClientPath(name.protocol, host, ""))
+		}
+
+		return ret, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name.name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not look up addresses for `%s': %s", name.name, err)
+	}
+
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		for _, port := range name.ports {
+			host := net.JoinHostPort(addr, strconv.Itoa(port))
+			ret = append(ret, joinTLSClientPath(name.protocol, host, name.name))
+		}
+	}
+
+	return ret, nil
+}
+
+//skipsEnds returns true for IPv4 networks with a network and broadcast address,
+// which can't be hosts. /31s and /32s have neither.
+func (n tlsNetwork) skipsEnds() bool {
+	ones, bits := n.network.Mask.Size()
+	return bits == 32 && ones < 31
+}
+
+func (n tlsNetwork) numHosts() int {
+	ones, bits := n.network.Mask.Size()
+	ret := 1 << uint(bits-ones)
+	if n.skipsEnds() {
+		ret -= 2
+	}
+
+	return ret
+}
+
+//eachHost calls fn with every address in the network which could be a host
+func (n tlsNetwork) eachHost(fn func(net.IP)) {
+	first := n.network.IP.Mask(n.network.Mask)
+	skipEnds := n.skipsEnds()
+	for ip := first; n.network.Contains(ip); ip = nextIP(ip) {
+		if skipEnds && (ip.Equal(first) || !n.network.Contains(nextIP(ip))) {
+			continue
+		}
+
+		fn(ip)
+	}
+}
+
+//nextIP returns a copy of ip incremented by one
+func nextIP(ip net.IP) net.IP {
+	ret := make(net.IP, len(ip))
+	copy(ret, ip)
+	for i := len(ret) - 1; i >= 0; i-- {
+		ret[i]++
+		if ret[i] != 0 {
+			break
+		}
+	}
+
+	return ret
+}
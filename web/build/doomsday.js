@@ -45,17 +45,29 @@ class Doomsday {
             password: password
         });
     }
-    fetchCerts() {
-        return this.doRequest("GET", "/v1/cache")
+    fetchCerts(query) {
+        let path = "/v1/cache";
+        if (query && Object.keys(query).length > 0) {
+            path += "?" + $.param(query);
+        }
+        return this.doRequest("GET", path)
             .then(data => {
-            let ret = [];
+            let ret = new CertificatePage();
             for (let cert of data.content) {
-                ret.push($.extend(new Certificate(), cert));
+                ret.certs.push($.extend(new Certificate(), cert));
             }
+            ret.total = data.total;
+            ret.nextCursor = data.next_cursor;
             return ret;
         });
     }
 }
+class CertificatePage {
+    constructor() {
+        this.certs = [];
+        this.total = 0;
+    }
+}
 class Certificate {
     get commonName() { return this.common_name; }
     get notAfter() { return this.not_after; }
@@ -248,7 +260,7 @@ class DashboardPage extends PageBase {
     }
     initialize() {
         this.certsElement.show();
-        this.certs = new CertificateList();
+        this.certs = [];
         this.updateCertList();
     }
     teardown() {
@@ -256,33 +268,55 @@ class DashboardPage extends PageBase {
         this.certUpdateID = -1;
         this.certsElement.hide();
         this.showMoreButton.off();
+        this.loadMoreButton.off();
+    }
+    certQuery() {
+        let query = {};
+        if (!this.shouldShowAll) {
+            query["within"] = (DashboardPage.DEFAULT_EXPIRY_CUTOFF / 86400) + "d";
+        }
+        return query;
     }
     updateCertList() {
-        this.ctx.client.fetchCerts()
-            .then((content) => {
-            this.certs = new CertificateList(content);
+        let query = this.certQuery();
+        query["limit"] = String(Math.max(DashboardPage.PAGE_SIZE, this.certs.length));
+        this.ctx.client.fetchCerts(query)
+            .then((page) => {
+            this.certs = page.certs;
+            this.total = page.total;
+            this.nextCursor = page.nextCursor;
             this.repaint();
             this.certUpdateID = setTimeout(this.updateCertList.bind(this), 60 * 1000);
         })
-            .catch(e => {
-            if (e.code == 401) {
-                deleteCookie('doomsday-token');
-                this.ctx.pager.display(new LoginPage("Your session has expired"));
-            }
-            else {
-                this.ctx.pager.display(new LoginPage("Something went wrong!"));
-                console.log(`Something went wrong: ${e.errorMessage}`);
-            }
-        });
+            .catch(this.handleError.bind(this));
+    }
+    loadMore() {
+        let query = this.certQuery();
+        query["limit"] = String(DashboardPage.PAGE_SIZE);
+        query["cursor"] = this.nextCursor;
+        this.ctx.client.fetchCerts(query)
+            .then((page) => {
+            this.certs = this.certs.concat(page.certs);
+            this.total = page.total;
+            this.nextCursor = page.nextCursor;
+            this.repaint();
+        })
+            .catch(this.handleError.bind(this));
+    }
+    handleError(e) {
+        if (e.code == 401) {
+            deleteCookie('doomsday-token');
+            this.ctx.pager.display(new LoginPage("Your session has expired"));
+        }
+        else {
+            this.ctx.pager.display(new LoginPage("Something went wrong!"));
+            console.log(`Something went wrong: ${e.errorMessage}`);
+        }
     }
     repaint() {
         let now = new Date().getTime() / 1000;
         let lists = [];
-        let certsToDisplay = this.certs;
-        if (!this.shouldShowAll) {
-            certsToDisplay = this.certs.expiresWithin(DashboardPage.DEFAULT_EXPIRY_CUTOFF);
-        }
-        for (let cert of certsToDisplay) {
+        for (let cert of this.certs) {
             if (lists.length == 0 || cert.notAfter > lists[lists.length - 1].cutoff) {
                 let maxDays = Math.max(0, Math.ceil((cert.not_after - now) / 86400));
                 let label = this.durationString(maxDays - 1);
@@ -305,15 +339,29 @@ class DashboardPage extends PageBase {
             this.certsElement.show();
         }
         this.showMoreButton = $("#certs-show-more");
+        this.showMoreButton.html("show " + (this.shouldShowAll ? "less" : "all"));
         this.showMoreButton.on("click", (e) => {
             e.preventDefault();
             this.showMoreButton.prop("disabled", true);
             this.showMoreButton.html("working...");
             this.shouldShowAll = !this.shouldShowAll;
-            this.repaint();
-            this.showMoreButton = $("#certs-show-more");
-            this.showMoreButton.html("show " + (this.shouldShowAll ? "less" : "all"));
-            this.showMoreButton.prop("disabled", false);
+            clearTimeout(this.certUpdateID);
+            this.certs = [];
+            this.updateCertList();
+            return false;
+        });
+        this.loadMoreButton = $("#certs-load-more");
+        if (this.nextCursor) {
+            let remaining = this.total - this.certs.length;
+            this.loadMoreButton.html("load " + Math.min(DashboardPage.PAGE_SIZE, remaining) +
+                " more (" + remaining + " remaining)");
+            this.loadMoreButton.show();
+        }
+        this.loadMoreButton.on("click", (e) => {
+            e.preventDefault();
+            this.loadMoreButton.prop("disabled", true);
+            this.loadMoreButton.html("working...");
+            this.loadMore();
             return false;
         });
         return;
@@ -367,43 +415,7 @@ class DashboardPage extends PageBase {
     }
 }
 DashboardPage.DEFAULT_EXPIRY_CUTOFF = 7776000;
-class CertificateList {
-    constructor(initial) {
-        this.storage = [];
-        if (initial) {
-            this.storage = initial;
-        }
-    }
-    [Symbol.iterator]() {
-        const now = new Date().getTime() / 1000;
-        const maxUntil = (typeof this.expMax === 'number' ? this.expMax : Number.MAX_VALUE);
-        let idx = 0;
-        return {
-            next: () => {
-                let isDone = () => {
-                    if (idx >= this.storage.length) {
-                        return true;
-                    }
-                    if (this.storage[idx].notAfter - now > maxUntil) {
-                        return true;
-                    }
-                    return false;
-                };
-                let done = isDone();
-                return {
-                    done: done,
-                    value: done ? undefined : this.storage[idx++]
-                };
-            }
-        };
-    }
-    get length() { return this.storage.length; }
-    expiresWithin(duration) {
-        let ret = new CertificateList(this.storage);
-        ret.expMax = duration;
-        return ret;
-    }
-}
+DashboardPage.PAGE_SIZE = 500;
 let NORMAL_HAMBURGER_WIDTH;
 let NORMAL_HAMBURGER_HEIGHT;
 let HAMBURGER_BOX_PADDING;
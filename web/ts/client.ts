@@ -63,28 +63,93 @@ class Doomsday {
     });
   }
 
-  fetchCerts(): Promise<Array<Certificate>> {
-    return this.doRequest("GET", "/v1/cache")
+  /**
+   * Fetches a page of the certs in the cache. The query, if given, is passed
+   * to the server to filter, sort or page the results,
+   * e.g. {within: "30d", limit: "500"}
+   */
+  fetchCerts(query?: {[param: string]: string}): Promise<CertificatePage> {
+    let path = "/v1/cache";
+    if (query && Object.keys(query).length > 0) {
+      path += "?" + $.param(query);
+    }
+
+    return this.doRequest("GET", path)
       .then(data => {
-        let ret: Array<Certificate> = [];
+        let ret = new CertificatePage();
         for (let cert of data.content) {
-          ret.push(($.extend(new Certificate(), cert) as Certificate))
+          ret.certs.push(($.extend(new Certificate(), cert) as Certificate))
         }
+        ret.total = data.total;
+        ret.nextCursor = data.next_cursor;
         return ret;
       });
   }
 }
 
+/**
+ * A page of the certs in the cache
+ */
+class CertificatePage {
+  certs: Array<Certificate> = [];
+  //How many certs matched the query, across all pages
+  total: number = 0;
+  //If set, pass this as the cursor of the same query to get the next page
+  nextCursor?: string;
+}
+
 class Certificate {
+  type: string;
   common_name: string;
   not_after: number;
   paths: Array<CertificateStoragePath>;
+  subject: string;
+  issuer: string;
+  issuer_common_name: string;
+  serial_number: string;
+  dns_names?: Array<string>;
+  ip_addresses?: Array<string>;
+  uris?: Array<string>;
+  email_addresses?: Array<string>;
+  not_before: number;
+  key_algorithm: string;
+  key_size: number;
+  signature_algorithm: string;
+  fingerprint: string;
+  is_ca: boolean;
+  key_usages?: Array<string>;
+  ext_key_usages?: Array<string>;
+  effective_not_after: number;
+  issuers?: Array<string>;
+  replaces?: Array<HistoryEvent>;
+  key_mismatch: boolean;
+  principals?: Array<string>;
+  key_id?: string;
 
   get commonName(): string { return this.common_name; }
   get notAfter(): number { return this.not_after; }
 }
 
+class HistoryEvent {
+  at: number;
+  kind: string;
+  backend: string;
+  location: string;
+  old?: HistoryCert;
+  new?: HistoryCert;
+}
+
+class HistoryCert {
+  name: string;
+  common_name: string;
+  subject: string;
+  fingerprint: string;
+  not_after: number;
+}
+
 class CertificateStoragePath {
   backend: string;
   location: string;
+  key_mismatch?: boolean;
+  stale?: boolean;
 }
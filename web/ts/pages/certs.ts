@@ -3,10 +3,15 @@ class DashboardPage extends PageBase {
   private certUpdateID: number;
   private certsElement: JQuery;
   private showMoreButton: JQuery;
-  private certs: CertificateList;
+  private loadMoreButton: JQuery;
+  private certs: Array<Certificate>;
+  private total: number;
+  private nextCursor?: string;
   private shouldShowAll: boolean;
 
   static readonly DEFAULT_EXPIRY_CUTOFF: number = 7776000;
+  //How many certs to fetch from the server at a time
+  static readonly PAGE_SIZE: number = 500;
   constructor() {
     super();
     this.certUpdateID = -1;
@@ -15,7 +20,7 @@ class DashboardPage extends PageBase {
 
   initialize(): void {
     this.certsElement.show();
-    this.certs = new CertificateList();
+    this.certs = [];
 
     this.updateCertList();
   }
@@ -25,34 +30,65 @@ class DashboardPage extends PageBase {
     this.certUpdateID = -1;
     this.certsElement.hide();
     this.showMoreButton.off();
+    this.loadMoreButton.off();
+  }
+
+  /**
+   * Returns the query for the certs to show, leaving the server to do the
+   * filtering so that only those are downloaded
+   */
+  private certQuery(): {[param: string]: string} {
+    let query: {[param: string]: string} = {};
+    if (!this.shouldShowAll) {
+      query["within"] = (DashboardPage.DEFAULT_EXPIRY_CUTOFF / 86400) + "d";
+    }
+    return query;
   }
 
   private updateCertList() {
-    this.ctx.client.fetchCerts()
-      .then((content: Array<Certificate>) => {
-        this.certs = new CertificateList(content);
+    //Fetch as many certs as are already shown, so that any pages loaded with
+    // the load more button are kept
+    let query = this.certQuery();
+    query["limit"] = String(Math.max(DashboardPage.PAGE_SIZE, this.certs.length));
+    this.ctx.client.fetchCerts(query)
+      .then((page: CertificatePage) => {
+        this.certs = page.certs;
+        this.total = page.total;
+        this.nextCursor = page.nextCursor;
         this.repaint();
         this.certUpdateID = setTimeout(this.updateCertList.bind(this), 60 * 1000);
       })
-      .catch(e => {
-        if (e.code == 401) {
-          deleteCookie('doomsday-token');
-          this.ctx.pager.display(new LoginPage("Your session has expired"));
-        } else {
-          this.ctx.pager.display(new LoginPage("Something went wrong!"));
-          console.log(`Something went wrong: ${e.errorMessage}`);
-        }
-      });
+      .catch(this.handleError.bind(this));
+  }
+
+  private loadMore() {
+    let query = this.certQuery();
+    query["limit"] = String(DashboardPage.PAGE_SIZE);
+    query["cursor"] = this.nextCursor;
+    this.ctx.client.fetchCerts(query)
+      .then((page: CertificatePage) => {
+        this.certs = this.certs.concat(page.certs);
+        this.total = page.total;
+        this.nextCursor = page.nextCursor;
+        this.repaint();
+      })
+      .catch(this.handleError.bind(this));
+  }
+
+  private handleError(e: APIError) {
+    if (e.code == 401) {
+      deleteCookie('doomsday-token');
+      this.ctx.pager.display(new LoginPage("Your session has expired"));
+    } else {
+      this.ctx.pager.display(new LoginPage("Something went wrong!"));
+      console.log(`Something went wrong: ${e.errorMessage}`);
+    }
   }
 
   private repaint() {
     let now = new Date().getTime() / 1000;
     let lists = [];
-    let certsToDisplay = this.certs;
-    if (!this.shouldShowAll) {
-      certsToDisplay = this.certs.expiresWithin(DashboardPage.DEFAULT_EXPIRY_CUTOFF)
-    }
-    for (let cert of certsToDisplay) {
+    for (let cert of this.certs) {
       if (lists.length == 0 || cert.notAfter > lists[lists.length - 1].cutoff) {
         let maxDays = Math.max(0, Math.ceil((cert.not_after - now) / 86400));
         let label = this.durationString(maxDays - 1);
@@ -74,15 +110,32 @@ class DashboardPage extends PageBase {
       this.certsElement.show();
     }
     this.showMoreButton = $("#certs-show-more");
+    this.showMoreButton.html("show " + (this.shouldShowAll ? "less" : "all"));
     this.showMoreButton.on("click", (e: JQuery.Event) => {
       e.preventDefault();
       this.showMoreButton.prop("disabled", true);
       this.showMoreButton.html("working...");
       this.shouldShowAll = !this.shouldShowAll;
-      this.repaint();
-      this.showMoreButton = $("#certs-show-more");
-      this.showMoreButton.html("show " + (this.shouldShowAll ? "less" : "all"));
-      this.showMoreButton.prop("disabled", false);
+      //The server decides which certs to show, so start again from the
+      // first page
+      clearTimeout(this.certUpdateID);
+      this.certs = [];
+      this.updateCertList();
+      return false;
+    })
+
+    this.loadMoreButton = $("#certs-load-more");
+    if (this.nextCursor) {
+      let remaining = this.total - this.certs.length;
+      this.loadMoreButton.html("load " + Math.min(DashboardPage.PAGE_SIZE, remaining) +
+        " more (" + remaining + " remaining)");
+      this.loadMoreButton.show();
+    }
+    this.loadMoreButton.on("click", (e: JQuery.Event) => {
+      e.preventDefault();
+      this.loadMoreButton.prop("disabled", true);
+      this.loadMoreButton.html("working...");
+      this.loadMore();
       return false;
     })
     return;
@@ -130,61 +183,3 @@ class DashboardPage extends PageBase {
     return Colors.Green;
   }
 }
-
-/**
- * A list of Certificates
- *
- * @remarks
- * Given input must currently be presented in a sorted order
- */
-class CertificateList {
-  private storage: Array<Certificate>
-  private expMax: number; //inclusive
-
-  constructor(initial?: Array<Certificate>) {
-    this.storage = [];
-    if (initial) {
-      this.storage = initial;
-    }
-  }
-
-  [Symbol.iterator]() {
-    const now = new Date().getTime() / 1000;
-    const maxUntil: number =
-      (typeof this.expMax === 'number' ? this.expMax : Number.MAX_VALUE);
-    let idx: number = 0;
-    return {
-      next: () => {
-        let isDone = () => {
-          if (idx >= this.storage.length) {
-            return true;
-          }
-          if (this.storage[idx].notAfter - now > maxUntil) {
-            return true;
-          }
-          return false;
-        }
-        let done: boolean = isDone();
-        return {
-          done: done,
-          value: done ? undefined : this.storage[idx++]
-        }
-      }
-    }
-  }
-
-  get length(): number { return this.storage.length; }
-
-  /**
-   * Returns a new CertificateStore which, when iterated over, will only yield
-   * the elements of the CertificateStore which expire within the given timespan.
-   *
-   * @param duration Number of seconds from now a cert must expire within to
-   *   be returned.
-   */
-  expiresWithin(duration: number): CertificateList {
-    let ret: CertificateList = new CertificateList(this.storage);
-    ret.expMax = duration;
-    return ret;
-  }
-}